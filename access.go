@@ -0,0 +1,230 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Each audited_view is logged under _accessLog_<txId>, so concurrent views never write the same key.
+var accessLogPrefix = "_accessLog_"
+
+// ============================================================================================================================
+// ACCESS REASONS - why a caller needs to see actor contact data, recorded with each audited_view
+// ============================================================================================================================
+const REASON_SERVICE_REQUEST = "service_request"			// the actor asked for help with their own activity
+const REASON_INVESTIGATION = "investigation"				// an alert or incident is being looked into
+const REASON_LEGAL = "legal"								// a court order or statutory request
+const REASON_AUDIT = "audit"
+
+var accessReasons = []string{REASON_SERVICE_REQUEST, REASON_INVESTIGATION, REASON_LEGAL, REASON_AUDIT}
+
+const EVENT_PII_ACCESSED = "pii.accessed"
+
+//==============================================================================================================================
+//	AccessLogEntry - One audited_view. Actors holds the SHA-256 of the actorKey of every actor in the result, so the log
+//					 can be searched per actor without copying their contact data. ActivityIds are the activities the
+//					 caller may fetch with audited_result.
+//==============================================================================================================================
+type AccessLogEntry struct {
+	TxId string `json:"txId"`
+	Caller string `json:"caller"`
+	CallerAffiliation string `json:"callerAffiliation"`
+	ReasonCode string `json:"reasonCode"`
+	Filter []string `json:"filter"`							// the view_activities arguments
+	ResultCount int `json:"resultCount"`
+	ActivityIds []int64 `json:"activityIds"`
+	Actors []string `json:"actors"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type AccessEvent struct {
+	TxId string `json:"txId"`
+	Caller string `json:"caller"`
+	ReasonCode string `json:"reasonCode"`
+	ResultCount int `json:"resultCount"`
+}
+
+//=================================================================================================================================
+//	 audited_view - args[0] is the reason code, args[1:] are the view_activities arguments. Records who looked at which
+//					actors and why, then returns the activities with their contact data. A peer only hands the
+//					transaction id back to the caller, who fetches the activities with audited_result.
+//=================================================================================================================================
+func (t *SimpleChaincode) audited_view(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) < 18 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting a reason code followed by the view_activities arguments")
+	}
+
+	if !containsString(accessReasons, args[0]) {
+		return nil, invalidArgument(0, "reasonCode", "Invalid reason code " + args[0] + ". Expecting one of " + strings.Join(accessReasons, ", "))
+	}
+
+	activities, err := find_activities(stub, args[1:])
+	if err != nil { return nil, err }
+
+	entry := AccessLogEntry{TxId: stub.GetTxID(), Caller: caller, CallerAffiliation: caller_affiliation, ReasonCode: args[0],
+		Filter: args[1:], ResultCount: len(activities), ActivityIds: []int64{}, Actors: []string{}, Timestamp: makeTimestamp(stub)}
+
+	for _, activity := range activities {
+		entry.ActivityIds = append(entry.ActivityIds, activity.ActivityId)
+
+		digest := actorDigest(activity.Actor)
+		if !containsString(entry.Actors, digest) {
+			entry.Actors = append(entry.Actors, digest)
+		}
+	}
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil { log_failure(stub, "Failed to convert access log entry", err); return nil, internalError("Failed to convert access log entry", err) }
+
+	err = stub.PutState(accessLogPrefix+entry.TxId, entryAsBytes)
+	if err != nil { log_failure(stub, "Failed to save access log entry", err); return nil, internalError("Failed to save access log entry", err) }
+
+	err = set_event(stub, EVENT_PII_ACCESSED, AccessEvent{TxId: entry.TxId, Caller: caller, ReasonCode: entry.ReasonCode, ResultCount: entry.ResultCount})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(activities)
+}
+
+//=================================================================================================================================
+//	 audited_result - args[0] is the transaction id of an audited_view made by the caller. Returns the activities that
+//					  view logged, with their contact data, as they are now.
+//=================================================================================================================================
+func (t *SimpleChaincode) audited_result(stub shim.ChaincodeStubInterface, caller string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. txId")
+	}
+
+	entryAsBytes, err := stub.GetState(accessLogPrefix + args[0])
+	if err != nil { log_failure(stub, "Failed to retrieve access log entry", err); return nil, internalError("Failed to retrieve access log entry", err) }
+
+	if len(entryAsBytes) == 0 {
+		return nil, notFound("No audited view in transaction " + args[0])
+	}
+
+	var entry AccessLogEntry
+	err = json.Unmarshal(entryAsBytes, &entry)
+	if err != nil { log_failure(stub, "Corrupt access log entry", err); return nil, corruptState(accessLogPrefix + args[0], "Corrupt access log entry") }
+
+	if caller == "" || caller != entry.Caller {
+		log_warning(stub, "Permission Denied", "txId", args[0]); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(entry.ActivityIds) == 0 {
+		return json.Marshal([]Activity{})
+	}
+
+	if len(entry.Filter) < 17 {
+		log_error(stub, "Corrupt access log entry", "txId", args[0]); return nil, corruptState(accessLogPrefix + args[0], "Corrupt access log entry")
+	}
+
+	// the logged filter narrowed down to the logged activities, so activities created since are left out
+	activityIdsAsBytes, err := json.Marshal(entry.ActivityIds)
+	if err != nil { log_failure(stub, "Failed to convert activity ids", err); return nil, internalError("Failed to convert activity ids", err) }
+
+	filter := append([]string{string(activityIdsAsBytes)}, entry.Filter[1:]...)
+
+	activities, err := find_activities(stub, filter)
+	if err != nil { return nil, err }
+
+	return json.Marshal(activities)
+}
+
+//=================================================================================================================================
+//	 view_access_log - Admin only. args: actorType, name, telephone, email for the accesses to one actor's data, none
+//					   for every entry. Oldest first.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_access_log(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 0 && len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting none, or actorType, name, telephone and email")
+	}
+
+	entries, err := get_access_log(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve access log", err); return nil, internalError("Failed to retrieve access log", err) }
+
+	digest := ""
+	if len(args) == 4 {
+		digest = actorDigest(Actor{ActorType: args[0], Name: args[1], Telephone: args[2], Email: args[3]})
+	}
+
+	result := []AccessLogEntry{}
+	for _, entry := range entries {
+		if digest != "" && !containsString(entry.Actors, digest) {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	return json.Marshal(result)
+}
+
+// actorDigest is the hex SHA-256 of the actorKey.
+func actorDigest(actor Actor) string {
+	sum := sha256.Sum256([]byte(actorKey(actor)))
+	return hex.EncodeToString(sum[:])
+}
+
+// redactContact blanks the telephone and email of the actor for results outside audited_view. A digest of either
+// could be reversed by hashing every number or address, so none is returned.
+func redactContact(actor Actor) Actor {
+	actor.Telephone = ""
+	actor.Email = ""
+	return actor
+}
+
+// get_access_log returns every access log entry, oldest first.
+func get_access_log(stub shim.ChaincodeStubInterface) ([]AccessLogEntry, error) {
+	iter, err := stub.RangeQueryState(accessLogPrefix, accessLogPrefix+"~")
+	if err != nil { return nil, err }
+	defer iter.Close()
+
+	var entries []AccessLogEntry
+	for iter.HasNext() {
+		key, entryAsBytes, err := iter.Next()
+		if err != nil { return nil, err }
+
+		var entry AccessLogEntry
+		err = json.Unmarshal(entryAsBytes, &entry)
+		if err != nil { return nil, corruptState(key, "Corrupt access log entry") }
+
+		entries = append(entries, entry)
+	}
+	sort.Sort(byAccessTime(entries))
+
+	return entries, nil
+}
+
+type byAccessTime []AccessLogEntry
+
+func (a byAccessTime) Len() int      { return len(a) }
+func (a byAccessTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAccessTime) Less(i, j int) bool {
+	if a[i].Timestamp != a[j].Timestamp {
+		return a[i].Timestamp < a[j].Timestamp
+	}
+	return a[i].TxId < a[j].TxId
+}
@@ -0,0 +1,359 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var activityCountersStr = "_activityCounters"
+
+// ============================================================================================================================
+// AGGREGATE DIMENSIONS
+// ============================================================================================================================
+const DIM_ACTIVITY_TYPE = "activityType"
+const DIM_KIOSK_ID = "kioskId"
+const DIM_ACTOR_TYPE = "actorType"
+const DIM_DEVICE_TYPE = "deviceType"
+const DIM_RESOURCE_TYPE = "resourceType"
+const DIM_HOUR = "hour"
+const DIM_DAY = "day"
+const DIM_WEEK = "week"
+const DIM_MONTH = "month"
+
+var aggregateDimensions = []string{DIM_ACTIVITY_TYPE, DIM_KIOSK_ID, DIM_ACTOR_TYPE, DIM_DEVICE_TYPE, DIM_RESOURCE_TYPE, DIM_HOUR, DIM_DAY, DIM_WEEK, DIM_MONTH}
+
+// Dimensions that can be answered from the incrementally maintained counters.
+var counterDimensions = []string{DIM_ACTIVITY_TYPE, DIM_KIOSK_ID, DIM_ACTOR_TYPE, DIM_DAY, DIM_WEEK, DIM_MONTH}
+
+// ============================================================================================================================
+// AGGREGATE BUCKET
+// ============================================================================================================================
+type AggregateBucket struct {
+	Key map[string]string `json:"key"`
+	Count int64 `json:"count"`
+}
+
+//==============================================================================================================================
+//	ActivityCounters - Activity counts keyed by the JSON array [activityType, kioskId, actorType, day]. ActivityCount is
+//					   the number of activities counted so far, it must equal the activity count for the counters to be used.
+//==============================================================================================================================
+type ActivityCounters struct {
+	Counts map[string]int64 `json:"counts"`
+	ActivityCount int64 `json:"activityCount"`
+}
+
+//=================================================================================================================================
+//	 aggregate_activities - Counts the activities matching the filter, grouped by the requested dimensions.
+//							args[0] is a JSON array of dimensions, args[1:] are the view_activities filter arguments.
+//=================================================================================================================================
+func (t *SimpleChaincode) aggregate_activities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var groupBy []string
+	err := json.Unmarshal([]byte(args[0]), &groupBy)
+	if err != nil { log_warning(stub, "Invalid group by argument", "error", err); return nil, invalidArgument(0, "groupBy", "Invalid group by argument") }
+
+	for i := range groupBy {
+		if !containsString(aggregateDimensions, groupBy[i]) {
+			log_warning(stub, "Unknown dimension", "groupBy", groupBy[i]); return nil, invalidArgument(0, "groupBy", "Unknown dimension: " + groupBy[i])
+		}
+	}
+
+	filter, err := parse_activity_filter(args[1:])
+	if err != nil { log_failure(stub, "Invalid filter arguments", err); return nil, err }
+
+	err = load_consent_filter(stub, &filter)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	var buckets []AggregateBucket
+	if filter.countable() && allContained(counterDimensions, groupBy) {
+		buckets, err = t.aggregate_from_counters(stub, filter, groupBy)
+		if err != nil { log_failure(stub, "Failed to read activity counters", err); return nil, err }
+	}
+
+	if buckets == nil {
+		activities, err := load_activities(stub, filter.IncludeArchived)
+		if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+		counts := make(map[string]int64)
+		for i := range activities {
+			if !filter.matches(activities[i]) {
+				continue
+			}
+
+			for _, key := range activityBucketKeys(activities[i], groupBy, filter.Location) {
+				counts[key]++
+			}
+		}
+
+		buckets, err = toBuckets(counts, groupBy)
+		if err != nil { log_failure(stub, "Failed to group activities", err); return nil, err }
+	}
+
+	bucketsAsBytes, err := json.Marshal(buckets)
+	if err != nil { log_failure(stub, "Failed to convert buckets", err); return nil, internalError("Failed to convert buckets", err) }
+
+	return bucketsAsBytes, nil
+}
+
+//=================================================================================================================================
+//	 aggregate_from_counters - Rolls the stored counters up to the requested dimensions. Returns nil buckets when the
+//							   counters are not in sync with the activity count, so the caller falls back to a scan. A
+//							   tenant without an activity count yet has none.
+//=================================================================================================================================
+func (t *SimpleChaincode) aggregate_from_counters(stub shim.ChaincodeStubInterface, filter ActivityFilter, groupBy []string) ([]AggregateBucket, error) {
+	activityCountAsBytes, err := stub.GetState(activityCountStr)
+	if err != nil { return nil, internalError("Failed to retrieve activity count", err) }
+
+	var activityCount int64
+	if len(activityCountAsBytes) > 0 {
+		activityCount, err = strconv.ParseInt(string(activityCountAsBytes), 10, 64)
+		if err != nil { return nil, corruptState(activityCountStr, "Corrupt activity count") }
+	}
+
+	counters, err := get_activity_counters(stub)
+	if err != nil { return nil, internalError("Failed to retrieve activity counters", err) }
+
+	if counters.ActivityCount != activityCount {
+		log_debug(stub, "Activity counters out of sync, falling back to scan")
+		return nil, nil
+	}
+
+	counts := make(map[string]int64)
+	for counterKey, count := range counters.Counts {
+		var parts []string
+		err = json.Unmarshal([]byte(counterKey), &parts)
+		if err != nil || len(parts) != 4 { return nil, corruptState(activityCountersStr, "Corrupt activity counter key " + counterKey) }
+
+		activityType, kioskId, actorType := parts[0], parts[1], parts[2]
+		if (len(filter.ActivityTypes) > 0 && !containsString(filter.ActivityTypes, activityType)) ||
+			(len(filter.KioskIds) > 0 && !containsString(filter.KioskIds, kioskId)) ||
+			(len(filter.ActorTypes) > 0 && !containsString(filter.ActorTypes, actorType)) {
+			continue
+		}
+
+		day, err := time.Parse("2006-01-02", parts[3])
+		if err != nil { return nil, corruptState(activityCountersStr, "Corrupt activity counter key " + counterKey) }
+
+		values := make([]string, len(groupBy))
+		for i := range groupBy {
+			switch groupBy[i] {
+			case DIM_ACTIVITY_TYPE:
+				values[i] = activityType
+			case DIM_KIOSK_ID:
+				values[i] = kioskId
+			case DIM_ACTOR_TYPE:
+				values[i] = actorType
+			default:
+				values[i] = timeBucket(groupBy[i], day)
+			}
+		}
+
+		counts[bucketKey(values)] += count
+	}
+
+	return toBuckets(counts, groupBy)
+}
+
+//=================================================================================================================================
+//	 rebuild_activity_counters - Admin only. Recomputes the counters from the stored activities, e.g. after upgrading a
+//								 chaincode that already holds activities.
+//=================================================================================================================================
+func (t *SimpleChaincode) rebuild_activity_counters(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	activitiesAsBytes, err := stub.GetState(activitiesStr)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	var activities AllActivities
+	if len(activitiesAsBytes) > 0 {
+		err = json.Unmarshal(activitiesAsBytes, &activities)
+		if err != nil { log_failure(stub, "Corrupt activities record", err); return nil, corruptState(activitiesStr, "Corrupt activities record") }
+	}
+
+	counters := ActivityCounters{Counts: make(map[string]int64)}
+	for i := range activities.Activities {
+		counters.add(activities.Activities[i])
+	}
+
+	// archived activities are not counted but still part of the activity count
+	index, err := get_archive_index(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve archive index", err); return nil, internalError("Failed to retrieve archive index", err) }
+
+	for _, header := range index.Segments {
+		counters.ActivityCount += int64(header.Count)
+	}
+
+	err = put_activity_counters(stub, counters)
+	if err != nil { log_failure(stub, "Failed to save activity counters", err); return nil, internalError("Failed to save activity counters", err) }
+
+	err = set_event(stub, EVENT_COUNTERS_REBUILT, CountersRebuiltEvent{ActivityCount: counters.ActivityCount})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return nil, nil
+}
+
+func get_activity_counters(stub shim.ChaincodeStubInterface) (ActivityCounters, error) {
+	var counters ActivityCounters
+
+	countersAsBytes, err := stub.GetState(activityCountersStr)
+	if err != nil { return counters, err }
+
+	if len(countersAsBytes) > 0 {
+		err = json.Unmarshal(countersAsBytes, &counters)
+		if err != nil { return counters, corruptState(activityCountersStr, "Corrupt activity counters record") }
+	}
+
+	if counters.Counts == nil {
+		counters.Counts = make(map[string]int64)
+	}
+
+	return counters, nil
+}
+
+func put_activity_counters(stub shim.ChaincodeStubInterface, counters ActivityCounters) error {
+	countersAsBytes, err := json.Marshal(counters)
+	if err != nil { return err }
+
+	return stub.PutState(activityCountersStr, countersAsBytes)
+}
+
+func (counters *ActivityCounters) add(activity Activity) {
+	day := int64ToTime(activity.Timestamp).UTC().Format("2006-01-02")
+	counters.Counts[bucketKey([]string{activity.ActivityType, activity.Kiosk.KioskId, activity.Actor.ActorType, day})]++
+	counters.ActivityCount++
+}
+
+// remove takes an archived activity out of the counts. ActivityCount is left alone, it tracks the activities seen.
+func (counters *ActivityCounters) remove(activity Activity) {
+	day := int64ToTime(activity.Timestamp).UTC().Format("2006-01-02")
+	key := bucketKey([]string{activity.ActivityType, activity.Kiosk.KioskId, activity.Actor.ActorType, day})
+
+	counters.Counts[key]--
+	if counters.Counts[key] <= 0 {
+		delete(counters.Counts, key)
+	}
+}
+
+// countable reports whether the filter only uses dimensions that the counters keep. Counters are bucketed by UTC day.
+func (filter ActivityFilter) countable() bool {
+	return len(filter.ActivityIds) == 0 && len(filter.Names) == 0 && len(filter.Telephones) == 0 &&
+		len(filter.Emails) == 0 && len(filter.DeviceTypes) == 0 && len(filter.Id1s) == 0 &&
+		len(filter.Id2s) == 0 && len(filter.Id3s) == 0 && len(filter.Id4s) == 0 &&
+		len(filter.ResourceOwners) == 0 && len(filter.ResourceTypes) == 0 && len(filter.ResourceIds) == 0 &&
+		filter.Start.IsZero() && filter.End.IsZero() && filter.Near == nil && filter.Box == nil && filter.Location == time.UTC &&
+		!filter.IncludeArchived && filter.Purpose == ""
+}
+
+// activityBucketKeys returns the bucket keys an activity counts towards, time buckets are taken in the given location. Grouping by resourceType counts the activity
+// once for every distinct resource type it carries, activities without resources fall into the "" resource type.
+func activityBucketKeys(activity Activity, groupBy []string, location *time.Location) []string {
+	resourceTypes := []string{""}
+	if containsString(groupBy, DIM_RESOURCE_TYPE) && len(activity.Resources) > 0 {
+		resourceTypes = nil
+		for j := range activity.Resources {
+			if !containsString(resourceTypes, activity.Resources[j].ResourceType) {
+				resourceTypes = append(resourceTypes, activity.Resources[j].ResourceType)
+			}
+		}
+	}
+
+	timestamp := int64ToTime(activity.Timestamp).In(location)
+
+	var keys []string
+	for _, resourceType := range resourceTypes {
+		values := make([]string, len(groupBy))
+		for i := range groupBy {
+			switch groupBy[i] {
+			case DIM_ACTIVITY_TYPE:
+				values[i] = activity.ActivityType
+			case DIM_KIOSK_ID:
+				values[i] = activity.Kiosk.KioskId
+			case DIM_ACTOR_TYPE:
+				values[i] = activity.Actor.ActorType
+			case DIM_DEVICE_TYPE:
+				values[i] = activity.Device.DeviceType
+			case DIM_RESOURCE_TYPE:
+				values[i] = resourceType
+			default:
+				values[i] = timeBucket(groupBy[i], timestamp)
+			}
+		}
+		keys = append(keys, bucketKey(values))
+	}
+
+	return keys
+}
+
+func timeBucket(dimension string, t time.Time) string {
+	switch dimension {
+	case DIM_HOUR:
+		return t.Format("2006-01-02T15")
+	case DIM_DAY:
+		return t.Format("2006-01-02")
+	case DIM_WEEK:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case DIM_MONTH:
+		return t.Format("2006-01")
+	}
+	return ""
+}
+
+func bucketKey(values []string) string {
+	keyAsBytes, _ := json.Marshal(values)
+	return string(keyAsBytes)
+}
+
+func toBuckets(counts map[string]int64, groupBy []string) ([]AggregateBucket, error) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buckets := make([]AggregateBucket, 0, len(keys))
+	for _, key := range keys {
+		var values []string
+		err := json.Unmarshal([]byte(key), &values)
+		if err != nil || len(values) != len(groupBy) { return nil, corruptState(activityCountersStr, "Corrupt activity counter key " + key) }
+
+		bucket := AggregateBucket{Key: make(map[string]string), Count: counts[key]}
+		for i := range groupBy {
+			bucket.Key[groupBy[i]] = values[i]
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+func allContained(slice []string, items []string) bool {
+	for _, item := range items {
+		if !containsString(slice, item) {
+			return false
+		}
+	}
+	return true
+}
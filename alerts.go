@@ -0,0 +1,230 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var alertsStr = "_alerts"
+
+// ============================================================================================================================
+// ALERT TYPES
+// ============================================================================================================================
+const ALERT_DEVICE_MISMATCH = "device_mismatch"
+const ALERT_RULE_VIOLATION = "rule_violation"					// raised by a rule with the flag action, see rules.go
+
+// ============================================================================================================================
+// ALERT STATUS - open -> acknowledged -> resolved, an open alert may also be resolved directly
+// ============================================================================================================================
+const ALERT_OPEN = "open"
+const ALERT_ACKNOWLEDGED = "acknowledged"
+const ALERT_RESOLVED = "resolved"
+
+const EVENT_ALERT_ACKNOWLEDGED = "alert.acknowledged"
+const EVENT_ALERT_RESOLVED = "alert.resolved"
+
+//==============================================================================================================================
+//	Alert - Raised by create_activity. Alerts raised by an activity are reported in its activity.created event, a
+//			transaction carries a single event.
+//==============================================================================================================================
+type Alert struct {
+	AlertId int64 `json:"alertId"`
+	AlertType string `json:"alertType"`
+	Status string `json:"status"`
+	KioskId string `json:"kioskId"`
+	ActivityId int64 `json:"activityId"`
+	Message string `json:"message"`
+	Rule string `json:"rule,omitempty"`							// rule_violation: the ruleId
+	Device *Device `json:"device,omitempty"`					// device_mismatch: the reported device
+	KnownDevices []Device `json:"knownDevices,omitempty"`		// device_mismatch: the devices of that type known before
+	RaisedAt int64 `json:"raisedAt"`
+	AcknowledgedBy string `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt int64 `json:"acknowledgedAt,omitempty"`
+	ResolvedBy string `json:"resolvedBy,omitempty"`
+	ResolvedAt int64 `json:"resolvedAt,omitempty"`
+	Notes []string `json:"notes,omitempty"`
+}
+
+//==============================================================================================================================
+//	AllAlerts - Alerts in the order they were raised, AlertId is the index.
+//==============================================================================================================================
+type AllAlerts struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+type AlertRaised struct {
+	AlertId int64 `json:"alertId"`
+	AlertType string `json:"alertType"`
+}
+
+type AlertEvent struct {
+	AlertId int64 `json:"alertId"`
+	AlertType string `json:"alertType"`
+	Status string `json:"status"`
+	KioskId string `json:"kioskId"`
+}
+
+//=================================================================================================================================
+//	 acknowledge_alert - Admin only. args: alertId, note. Marks an open alert as being looked into.
+//=================================================================================================================================
+func (t *SimpleChaincode) acknowledge_alert(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	return t.update_alert(stub, caller, caller_affiliation, args, ALERT_ACKNOWLEDGED)
+}
+
+//=================================================================================================================================
+//	 resolve_alert - Admin only. args: alertId, note. Closes an open or acknowledged alert.
+//=================================================================================================================================
+func (t *SimpleChaincode) resolve_alert(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	return t.update_alert(stub, caller, caller_affiliation, args, ALERT_RESOLVED)
+}
+
+func (t *SimpleChaincode) update_alert(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string, status string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. alertId and note")
+	}
+
+	alertId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil { log_warning(stub, "Invalid alertId", "error", err); return nil, invalidArgument(0, "alertId", "Invalid alertId") }
+
+	alerts, err := get_alerts(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve alerts", err); return nil, internalError("Failed to retrieve alerts", err) }
+
+	if alertId < 0 || alertId >= int64(len(alerts.Alerts)) {
+		return nil, notFound("Alert " + args[0] + " not found")
+	}
+
+	alert := &alerts.Alerts[alertId]
+	timestamp := makeTimestamp(stub)
+
+	switch {
+	case alert.Status == ALERT_RESOLVED:
+		return nil, conflict("Alert " + args[0] + " is already resolved")
+	case status == ALERT_ACKNOWLEDGED && alert.Status != ALERT_OPEN:
+		return nil, conflict("Alert " + args[0] + " is already acknowledged")
+	case status == ALERT_ACKNOWLEDGED:
+		alert.AcknowledgedBy, alert.AcknowledgedAt = caller, timestamp
+	default:
+		alert.ResolvedBy, alert.ResolvedAt = caller, timestamp
+	}
+
+	alert.Status = status
+	if args[1] != "" {
+		alert.Notes = append(alert.Notes, args[1])
+	}
+
+	err = put_alerts(stub, alerts)
+	if err != nil { log_failure(stub, "Failed to save alerts", err); return nil, internalError("Failed to save alerts", err) }
+
+	event := EVENT_ALERT_ACKNOWLEDGED
+	if status == ALERT_RESOLVED {
+		event = EVENT_ALERT_RESOLVED
+	}
+
+	err = set_event(stub, event, AlertEvent{AlertId: alert.AlertId, AlertType: alert.AlertType, Status: alert.Status, KioskId: alert.KioskId})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(alert)
+}
+
+//=================================================================================================================================
+//	 view_alerts - args[0] is an optional JSON array of statuses, args[1] an optional JSON array of kiosk ids. Empty
+//				   arguments match every alert.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_alerts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var statuses, kioskIds []string
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &statuses)
+		if err != nil { log_warning(stub, "Invalid statuses argument", "error", err); return nil, invalidArgument(0, "statuses", "Invalid statuses argument") }
+	}
+	if len(args) > 1 && args[1] != "" {
+		err := json.Unmarshal([]byte(args[1]), &kioskIds)
+		if err != nil { log_warning(stub, "Invalid kioskIds argument", "error", err); return nil, invalidArgument(1, "kioskIds", "Invalid kioskIds argument") }
+	}
+
+	alerts, err := get_alerts(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve alerts", err); return nil, internalError("Failed to retrieve alerts", err) }
+
+	result := []Alert{}
+	for _, alert := range alerts.Alerts {
+		if (len(statuses) > 0 && !containsString(statuses, alert.Status)) ||
+			(len(kioskIds) > 0 && !containsString(kioskIds, alert.KioskId)) {
+			continue
+		}
+		result = append(result, alert)
+	}
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 raise_alerts - Stores new alerts, assigning their ids, and returns them for the activity.created event.
+//=================================================================================================================================
+func raise_alerts(stub shim.ChaincodeStubInterface, raised []Alert) ([]AlertRaised, error) {
+	if len(raised) == 0 {
+		return nil, nil
+	}
+
+	alerts, err := get_alerts(stub)
+	if err != nil { return nil, err }
+
+	var summary []AlertRaised
+	for _, alert := range raised {
+		alert.AlertId = int64(len(alerts.Alerts))
+		alert.Status = ALERT_OPEN
+		alerts.Alerts = append(alerts.Alerts, alert)
+		summary = append(summary, AlertRaised{AlertId: alert.AlertId, AlertType: alert.AlertType})
+	}
+
+	return summary, put_alerts(stub, alerts)
+}
+
+// deviceMismatchAlert builds the alert for a device that is new at the kiosk for a device type it already knows.
+func deviceMismatchAlert(activity Activity, known []Device, timestamp int64) Alert {
+	device := activity.Device
+	return Alert{AlertType: ALERT_DEVICE_MISMATCH, KioskId: activity.Kiosk.KioskId, ActivityId: activity.ActivityId,
+		Message: "Unknown " + device.DeviceType + " device reported at kiosk " + activity.Kiosk.KioskId,
+		Device: &device, KnownDevices: known, RaisedAt: timestamp}
+}
+
+func get_alerts(stub shim.ChaincodeStubInterface) (AllAlerts, error) {
+	var alerts AllAlerts
+
+	alertsAsBytes, err := stub.GetState(alertsStr)
+	if err != nil { return alerts, err }
+
+	if len(alertsAsBytes) > 0 {
+		err = json.Unmarshal(alertsAsBytes, &alerts)
+		if err != nil { return alerts, corruptState(alertsStr, "Corrupt alerts record") }
+	}
+
+	return alerts, nil
+}
+
+func put_alerts(stub shim.ChaincodeStubInterface, alerts AllAlerts) error {
+	alertsAsBytes, err := json.Marshal(alerts)
+	if err != nil { return err }
+
+	return stub.PutState(alertsStr, alertsAsBytes)
+}
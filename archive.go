@@ -0,0 +1,332 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var retentionStr = "_retention"
+var archiveIndexStr = "_archiveIndex"
+var archiveSegmentPrefix = "_archive_"
+
+const EVENT_ACTIVITIES_ARCHIVED = "activities.archived"
+
+// Upper bound on the activities moved by one archive_activities transaction.
+const maxArchiveSegment = 1000
+
+const dayMillis = int64(24 * 60 * 60 * 1000)
+
+// Prefixes separating Merkle leaves from inner nodes, see merkleRoot.
+const merkleLeafPrefix = byte(0x00)
+const merkleNodePrefix = byte(0x01)
+
+//==============================================================================================================================
+//	RetentionPolicy - Activities older than RetentionDays are moved out of _activities by archive_activities.
+//==============================================================================================================================
+type RetentionPolicy struct {
+	RetentionDays int `json:"retentionDays"`
+}
+
+//==============================================================================================================================
+//	ArchiveHeader - Describes a sealed archive segment. MerkleRoot is the root over the activity hashes in the segment,
+//					SealHash covers the header itself and links to the previous segment through PrevSealHash.
+//==============================================================================================================================
+type ArchiveHeader struct {
+	Segment int `json:"segment"`
+	Count int `json:"count"`
+	FirstActivityId int64 `json:"firstActivityId"`
+	LastActivityId int64 `json:"lastActivityId"`
+	From int64 `json:"from"`
+	To int64 `json:"to"`
+	MerkleRoot string `json:"merkleRoot"`
+	SealedAt int64 `json:"sealedAt"`
+	PrevSealHash string `json:"prevSealHash"`
+	SealHash string `json:"sealHash"`
+}
+
+type ArchiveSegment struct {
+	Header ArchiveHeader `json:"header"`
+	Activities []Activity `json:"activities"`
+}
+
+//==============================================================================================================================
+//	ArchiveIndex - Headers of all archive segments in sealing order, the segment itself is kept under _archive_<segment>.
+//==============================================================================================================================
+type ArchiveIndex struct {
+	Segments []ArchiveHeader `json:"segments"`
+}
+
+//=================================================================================================================================
+//	 set_retention - Admin only. args[0] is the retention period in days, activities older than that may be archived.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_retention(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. retention period in days")
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 1 { log_warning(stub, "Invalid retention period", "retentionDays", args[0]); return nil, invalidArgument(0, "retentionDays", "Invalid retention period. Expecting a positive number of days") }
+
+	policyAsBytes, err := json.Marshal(RetentionPolicy{RetentionDays: days})
+	if err != nil { log_failure(stub, "Failed to convert retention policy", err); return nil, internalError("Failed to convert retention policy", err) }
+
+	err = stub.PutState(retentionStr, policyAsBytes)
+	if err != nil { log_failure(stub, "Failed to save retention policy", err); return nil, internalError("Failed to save retention policy", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: retentionStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 archive_activities - Admin only. Seals the oldest activities past the retention period into a new archive segment
+//						  and removes them from _activities and the activity counters. Only a leading run of activities
+//						  is archived so archive segments followed by _activities stay in activity order. Returns the
+//						  header of the new segment, or nothing when no activity is due.
+//=================================================================================================================================
+func (t *SimpleChaincode) archive_activities(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	var policy RetentionPolicy
+	policyAsBytes, err := stub.GetState(retentionStr)
+	if err != nil { log_failure(stub, "Failed to retrieve retention policy", err); return nil, internalError("Failed to retrieve retention policy", err) }
+	if len(policyAsBytes) > 0 {
+		err = json.Unmarshal(policyAsBytes, &policy)
+		if err != nil { log_failure(stub, "Corrupt retention policy", err); return nil, corruptState(retentionStr, "Corrupt retention policy") }
+	}
+
+	if policy.RetentionDays < 1 {
+		return nil, conflict("Retention period not set")
+	}
+
+	now := makeTimestamp(stub)
+	cutoff := now - int64(policy.RetentionDays)*dayMillis
+
+	activitiesAsBytes, err := stub.GetState(activitiesStr)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	var activities AllActivities
+	if len(activitiesAsBytes) > 0 {
+		err = json.Unmarshal(activitiesAsBytes, &activities)
+		if err != nil { log_failure(stub, "Corrupt activities record", err); return nil, corruptState(activitiesStr, "Corrupt activities record") }
+	}
+
+	due := 0
+	for due < len(activities.Activities) && due < maxArchiveSegment && activities.Activities[due].Timestamp < cutoff {
+		due++
+	}
+
+	if due == 0 {
+		return nil, nil
+	}
+
+	index, err := get_archive_index(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve archive index", err); return nil, internalError("Failed to retrieve archive index", err) }
+
+	segment := ArchiveSegment{Activities: activities.Activities[:due]}
+	segment.Header = ArchiveHeader{Segment: len(index.Segments), Count: due, SealedAt: now,
+		FirstActivityId: segment.Activities[0].ActivityId, LastActivityId: segment.Activities[due-1].ActivityId,
+		From: segment.Activities[0].Timestamp, To: segment.Activities[due-1].Timestamp}
+
+	if len(index.Segments) > 0 {
+		segment.Header.PrevSealHash = index.Segments[len(index.Segments)-1].SealHash
+	}
+
+	segment.Header.MerkleRoot, err = merkleRoot(segment.Activities)
+	if err != nil { log_failure(stub, "Failed to hash activities", err); return nil, internalError("Failed to hash activities", err) }
+
+	segment.Header.SealHash, err = sealHash(segment.Header)
+	if err != nil { log_failure(stub, "Failed to seal segment", err); return nil, internalError("Failed to seal segment", err) }
+
+	segmentAsBytes, err := json.Marshal(segment)
+	if err != nil { log_failure(stub, "Failed to convert segment", err); return nil, internalError("Failed to convert segment", err) }
+
+	err = stub.PutState(archiveSegmentKey(segment.Header.Segment), segmentAsBytes)
+	if err != nil { log_failure(stub, "Failed to save segment", err); return nil, internalError("Failed to save segment", err) }
+
+	index.Segments = append(index.Segments, segment.Header)
+	indexAsBytes, err := json.Marshal(index)
+	if err != nil { log_failure(stub, "Failed to convert archive index", err); return nil, internalError("Failed to convert archive index", err) }
+
+	err = stub.PutState(archiveIndexStr, indexAsBytes)
+	if err != nil { log_failure(stub, "Failed to save archive index", err); return nil, internalError("Failed to save archive index", err) }
+
+	counters, err := get_activity_counters(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve activity counters", err); return nil, internalError("Failed to retrieve activity counters", err) }
+
+	for i := range segment.Activities {
+		counters.remove(segment.Activities[i])
+	}
+
+	err = put_activity_counters(stub, counters)
+	if err != nil { log_failure(stub, "Failed to save activity counters", err); return nil, internalError("Failed to save activity counters", err) }
+
+	activities.Activities = activities.Activities[due:]
+	activitiesAsBytes, err = json.Marshal(activities)
+	if err != nil { log_failure(stub, "Failed to convert activities", err); return nil, internalError("Failed to convert activities", err) }
+
+	err = stub.PutState(activitiesStr, activitiesAsBytes)
+	if err != nil { log_failure(stub, "Failed to save activities", err); return nil, internalError("Failed to save activities", err) }
+
+	err = set_event(stub, EVENT_ACTIVITIES_ARCHIVED, segment.Header)
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(segment.Header)
+}
+
+//=================================================================================================================================
+//	 view_archive - Returns the headers of all archive segments.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_archive(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	index, err := get_archive_index(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve archive index", err); return nil, internalError("Failed to retrieve archive index", err) }
+
+	if index.Segments == nil {
+		index.Segments = []ArchiveHeader{}
+	}
+
+	return json.Marshal(index.Segments)
+}
+
+func get_archive_index(stub shim.ChaincodeStubInterface) (ArchiveIndex, error) {
+	var index ArchiveIndex
+
+	indexAsBytes, err := stub.GetState(archiveIndexStr)
+	if err != nil { return index, err }
+
+	if len(indexAsBytes) > 0 {
+		err = json.Unmarshal(indexAsBytes, &index)
+		if err != nil { return index, corruptState(archiveIndexStr, "Corrupt archive index") }
+	}
+
+	return index, nil
+}
+
+func get_archive_segment(stub shim.ChaincodeStubInterface, segment int) (ArchiveSegment, error) {
+	var archived ArchiveSegment
+
+	segmentAsBytes, err := stub.GetState(archiveSegmentKey(segment))
+	if err != nil { return archived, err }
+
+	err = json.Unmarshal(segmentAsBytes, &archived)
+	if err != nil { return archived, corruptState(archiveSegmentKey(segment), "Corrupt archive segment " + strconv.Itoa(segment)) }
+
+	return archived, nil
+}
+
+//=================================================================================================================================
+//	 load_activities - Returns the activities in _activities, preceded by the archived activities when includeArchived is set.
+//=================================================================================================================================
+func load_activities(stub shim.ChaincodeStubInterface, includeArchived bool) ([]Activity, error) {
+	var activities []Activity
+
+	if includeArchived {
+		var err error
+		activities, err = load_archived_activities(stub)
+		if err != nil { return nil, err }
+	}
+
+	activitiesAsBytes, err := stub.GetState(activitiesStr)
+	if err != nil { return nil, err }
+
+	var hot AllActivities
+	if len(activitiesAsBytes) > 0 {
+		err = json.Unmarshal(activitiesAsBytes, &hot)
+		if err != nil { return nil, corruptState(activitiesStr, "Corrupt activities record") }
+	}
+
+	return append(activities, hot.Activities...), nil
+}
+
+// load_archived_activities returns the activities of every archive segment in order.
+func load_archived_activities(stub shim.ChaincodeStubInterface) ([]Activity, error) {
+	index, err := get_archive_index(stub)
+	if err != nil { return nil, err }
+
+	var activities []Activity
+	for _, header := range index.Segments {
+		segment, err := get_archive_segment(stub, header.Segment)
+		if err != nil { return nil, err }
+
+		activities = append(activities, segment.Activities...)
+	}
+
+	return activities, nil
+}
+
+// merkleRoot is the hex root of a binary Merkle tree over the activity hashes, in the RFC 6962 layout: leaves and inner
+// nodes are hashed with distinct prefixes and an odd node is promoted unpaired, so no two activity lists share a root.
+// Activities created before hash chaining are hashed the same way for their leaf.
+func merkleRoot(activities []Activity) (string, error) {
+	level := make([][]byte, len(activities))
+	for i := range activities {
+		leaf, err := activityHash(activities[i])
+		if err != nil { return "", err }
+
+		leafAsBytes, err := hex.DecodeString(leaf)
+		if err != nil { return "", err }
+
+		sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, leafAsBytes...))
+		level[i] = sum[:]
+	}
+
+	if len(level) == 0 {
+		return "", nil
+	}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			sum := sha256.Sum256(append(append([]byte{merkleNodePrefix}, level[i]...), level[i+1]...))
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0]), nil
+}
+
+func sealHash(header ArchiveHeader) (string, error) {
+	header.SealHash = ""
+
+	headerAsBytes, err := json.Marshal(header)
+	if err != nil { return "", err }
+
+	sum := sha256.Sum256(headerAsBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func archiveSegmentKey(segment int) string {
+	return archiveSegmentPrefix + strconv.Itoa(segment)
+}
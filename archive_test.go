@@ -0,0 +1,177 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func testActivities(n int) []Activity {
+	activities := make([]Activity, n)
+	for i := range activities {
+		activities[i] = Activity{ActivityId: int64(i + 1), ActivityType: "deposit", Timestamp: int64(1000 * (i + 1))}
+		activities[i].Hash, _ = activityHash(activities[i])
+	}
+	return activities
+}
+
+func testLeaf(t *testing.T, activity Activity) []byte {
+	hash, err := activityHash(activity)
+	if err != nil { t.Fatal(err) }
+
+	hashAsBytes, err := hex.DecodeString(hash)
+	if err != nil { t.Fatal(err) }
+
+	sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, hashAsBytes...))
+	return sum[:]
+}
+
+func testNode(left, right []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{merkleNodePrefix}, left...), right...))
+	return sum[:]
+}
+
+func TestMerkleRoot(t *testing.T) {
+	activities := testActivities(5)
+	leaf := func(i int) []byte { return testLeaf(t, activities[i]) }
+
+	tests := []struct {
+		name string
+		count int
+		want func() []byte
+	}{
+		{"one leaf", 1, func() []byte { return leaf(0) }},
+		{"two leaves", 2, func() []byte { return testNode(leaf(0), leaf(1)) }},
+		{"three leaves promote the odd leaf", 3, func() []byte {
+			return testNode(testNode(leaf(0), leaf(1)), leaf(2))
+		}},
+		{"four leaves", 4, func() []byte {
+			return testNode(testNode(leaf(0), leaf(1)), testNode(leaf(2), leaf(3)))
+		}},
+		{"five leaves promote the odd leaf twice", 5, func() []byte {
+			return testNode(testNode(testNode(leaf(0), leaf(1)), testNode(leaf(2), leaf(3))), leaf(4))
+		}},
+	}
+
+	for _, test := range tests {
+		root, err := merkleRoot(activities[:test.count])
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want := hex.EncodeToString(test.want()); root != want {
+			t.Errorf("%s: root %s, want %s", test.name, root, want)
+		}
+	}
+
+	root, err := merkleRoot(nil)
+	if err != nil || root != "" {
+		t.Errorf("no leaves: root %q, err %v, want empty", root, err)
+	}
+}
+
+func TestMerkleRootDomainSeparation(t *testing.T) {
+	activities := testActivities(4)
+
+	single, _ := merkleRoot(activities[:1])
+	if single == activities[0].Hash {
+		t.Errorf("a single leaf root is the bare activity hash")
+	}
+
+	tests := []struct {
+		name string
+		a, b []Activity
+	}{
+		{"odd leaf is not duplicated", activities[:3], append(append([]Activity{}, activities[:3]...), activities[2])},
+		{"order matters", activities[:2], []Activity{activities[1], activities[0]}},
+		{"different leaves", activities[:2], activities[2:4]},
+		{"prefix of the list", activities[:2], activities[:3]},
+	}
+
+	for _, test := range tests {
+		a, err := merkleRoot(test.a)
+		if err != nil { t.Fatal(err) }
+		b, err := merkleRoot(test.b)
+		if err != nil { t.Fatal(err) }
+
+		if a == b {
+			t.Errorf("%s: both lists have root %s", test.name, a)
+		}
+	}
+
+	// Hashing two leaves without prefixes must not give the root, or an inner node could pass as a leaf.
+	left, _ := hex.DecodeString(activities[0].Hash)
+	right, _ := hex.DecodeString(activities[1].Hash)
+	plain := sha256.Sum256(append(left, right...))
+	root, _ := merkleRoot(activities[:2])
+	if root == hex.EncodeToString(plain[:]) {
+		t.Errorf("root of two leaves is the unprefixed hash of their concatenation")
+	}
+}
+
+func TestVerifySegment(t *testing.T) {
+	sealed := func(activities []Activity) (ArchiveHeader, ArchiveSegment) {
+		root, err := merkleRoot(activities)
+		if err != nil { t.Fatal(err) }
+
+		header := ArchiveHeader{Segment: 0, Count: len(activities), FirstActivityId: activities[0].ActivityId,
+			LastActivityId: activities[len(activities)-1].ActivityId, MerkleRoot: root, SealedAt: 5000}
+		header.SealHash, err = sealHash(header)
+		if err != nil { t.Fatal(err) }
+
+		return header, ArchiveSegment{Header: header, Activities: activities}
+	}
+
+	tests := []struct {
+		name string
+		tamper func(header *ArchiveHeader, segment *ArchiveSegment)
+		valid bool
+	}{
+		{"untouched", func(header *ArchiveHeader, segment *ArchiveSegment) {}, true},
+		{"activity edited", func(header *ArchiveHeader, segment *ArchiveSegment) {
+			segment.Activities[1].Remark = "edited"
+		}, false},
+		{"activities swapped", func(header *ArchiveHeader, segment *ArchiveSegment) {
+			segment.Activities[0], segment.Activities[1] = segment.Activities[1], segment.Activities[0]
+		}, false},
+		{"last activity duplicated", func(header *ArchiveHeader, segment *ArchiveSegment) {
+			segment.Activities = append(segment.Activities, segment.Activities[2])
+			segment.Header.Count++
+			*header = segment.Header
+		}, false},
+		{"root replaced without resealing", func(header *ArchiveHeader, segment *ArchiveSegment) {
+			segment.Activities[1].Remark = "edited"
+			segment.Header.MerkleRoot, _ = merkleRoot(segment.Activities)
+			*header = segment.Header
+		}, false},
+		{"index entry differs", func(header *ArchiveHeader, segment *ArchiveSegment) {
+			header.SealedAt++
+		}, false},
+	}
+
+	for _, test := range tests {
+		header, segment := sealed(testActivities(3))
+		test.tamper(&header, &segment)
+
+		report := ChainReport{Scope: CHAIN_GLOBAL}
+		if valid := verify_segment(&report, header, segment, ""); valid != test.valid {
+			t.Errorf("%s: verified %v, want %v (%s)", test.name, valid, test.valid, report.Reason)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// CALL KINDS - the entry point a function is dispatched from
+// ============================================================================================================================
+const CALL_INIT = "init"
+const CALL_INVOKE = "invoke"
+const CALL_QUERY = "query"
+
+// ============================================================================================================================
+// ARGUMENT KINDS - what check_args parses an argument as, the function itself still checks ranges and formats
+// ============================================================================================================================
+const ARG_STRING = "string"
+const ARG_INT = "int"
+const ARG_NUMBER = "number"
+const ARG_BOOL = "bool"
+const ARG_JSON_ARRAY = "jsonArray"
+const ARG_JSON_OBJECT = "jsonObject"
+
+//==============================================================================================================================
+//	ArgSpec - One positional argument. Optional arguments may be left out, they and Empty arguments may be "" to take
+//			  their default.
+//==============================================================================================================================
+type ArgSpec struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Optional bool `json:"optional,omitempty"`
+	Empty bool `json:"empty,omitempty"`
+}
+
+//==============================================================================================================================
+//	FunctionSpec - The arguments of a function: Args in order, then Repeat any number of times, e.g. the resources of
+//				   create_activity. A spec with Repeat has no optional Args.
+//==============================================================================================================================
+type FunctionSpec struct {
+	Function string `json:"function"`
+	Call string `json:"call"`									// one of the CALL_ constants
+	Summary string `json:"summary"`
+	Args []ArgSpec `json:"args"`
+	Repeat []ArgSpec `json:"repeat,omitempty"`
+	Usage string `json:"usage"`									// set by usage, e.g. "heartbeat kioskId firmware health"
+}
+
+func required(name string, kind string) ArgSpec { return ArgSpec{Name: name, Kind: kind} }
+func optional(name string, kind string) ArgSpec { return ArgSpec{Name: name, Kind: kind, Optional: true} }
+func emptyable(name string, kind string) ArgSpec { return ArgSpec{Name: name, Kind: kind, Empty: true} }
+
+func concatArgs(groups ...[]ArgSpec) []ArgSpec {
+	var args []ArgSpec
+	for _, group := range groups {
+		args = append(args, group...)
+	}
+	return args
+}
+
+// The view_activities filter, shared by every activity query, see parse_activity_filter.
+var activityFilterArgs = []ArgSpec{
+	emptyable("activityIds", ARG_JSON_ARRAY), emptyable("actorTypes", ARG_JSON_ARRAY), emptyable("names", ARG_JSON_ARRAY),
+	emptyable("telephones", ARG_JSON_ARRAY), emptyable("emails", ARG_JSON_ARRAY), emptyable("activityTypes", ARG_JSON_ARRAY),
+	emptyable("kioskIds", ARG_JSON_ARRAY), emptyable("deviceTypes", ARG_JSON_ARRAY), emptyable("id1s", ARG_JSON_ARRAY),
+	emptyable("id2s", ARG_JSON_ARRAY), emptyable("id3s", ARG_JSON_ARRAY), emptyable("id4s", ARG_JSON_ARRAY),
+	emptyable("resourceOwners", ARG_JSON_ARRAY), emptyable("resourceTypes", ARG_JSON_ARRAY), emptyable("resourceIds", ARG_JSON_ARRAY),
+	emptyable("start", ARG_STRING), emptyable("end", ARG_STRING), optional("options", ARG_JSON_OBJECT),
+}
+
+var activityArgs = []ArgSpec{
+	required("actorType", ARG_STRING), required("name", ARG_STRING), required("telephone", ARG_STRING), required("email", ARG_STRING),
+	required("activityType", ARG_STRING), required("kioskId", ARG_STRING), required("latitude", ARG_NUMBER),
+	required("longitude", ARG_NUMBER), required("kioskDetails", ARG_STRING), required("remark", ARG_STRING),
+	required("deviceType", ARG_STRING), required("id1", ARG_STRING), required("id2", ARG_STRING), required("id3", ARG_STRING),
+	required("id4", ARG_STRING),
+}
+
+var resourceArgs = []ArgSpec{
+	required("resourceOwner", ARG_STRING), required("resourceType", ARG_STRING), required("resourceId", ARG_STRING),
+	required("details", ARG_STRING),
+}
+
+var actorArgs = []ArgSpec{
+	required("actorType", ARG_STRING), required("name", ARG_STRING), required("telephone", ARG_STRING), required("email", ARG_STRING),
+}
+
+var optionalActorArgs = []ArgSpec{
+	optional("actorType", ARG_STRING), optional("name", ARG_STRING), optional("telephone", ARG_STRING), optional("email", ARG_STRING),
+}
+
+//==============================================================================================================================
+//	functionSpecs - Every function the chaincode dispatches, in the order usage lists them.
+//==============================================================================================================================
+var functionSpecs = []FunctionSpec{
+	{Function: "init", Call: CALL_INIT, Summary: "Resets the activity count and stores the given user and eCert pairs",
+		Repeat: []ArgSpec{required("user", ARG_STRING), required("ecert", ARG_STRING)}},
+
+	{Function: "create_activity", Call: CALL_INVOKE, Summary: "Records an activity with any number of resources",
+		Args: activityArgs, Repeat: resourceArgs},
+	{Function: "write", Call: CALL_INVOKE, Summary: "Sets a state key, keys starting with _ are the chaincode's own",
+		Args: []ArgSpec{required("key", ARG_STRING), required("value", ARG_STRING)}},
+	{Function: "rebuild_activity_counters", Call: CALL_INVOKE, Summary: "Admin only. Recomputes the activity counters"},
+	{Function: "set_event_config", Call: CALL_INVOKE, Summary: "Admin only. Includes or excludes PII in activity events",
+		Args: []ArgSpec{required("includePII", ARG_BOOL)}},
+	{Function: "register_kiosk", Call: CALL_INVOKE, Summary: "Admin only. Registers or updates a kiosk",
+		Args: []ArgSpec{required("kioskId", ARG_STRING), required("latitude", ARG_NUMBER), required("longitude", ARG_NUMBER),
+			required("details", ARG_STRING), optional("slotCapacity", ARG_INT)}},
+	{Function: "set_retention", Call: CALL_INVOKE, Summary: "Admin only. Sets the retention period in days",
+		Args: []ArgSpec{required("retentionDays", ARG_INT)}},
+	{Function: "archive_activities", Call: CALL_INVOKE, Summary: "Admin only. Archives the activities past the retention period"},
+	{Function: "set_tariff", Call: CALL_INVOKE, Summary: "Admin only. Sets the tariff of an activity type in minor currency units",
+		Args: []ArgSpec{required("activityType", ARG_STRING), required("perActivity", ARG_INT), required("perResource", ARG_INT),
+			required("vendorFee", ARG_INT)}},
+	{Function: "close_period", Call: CALL_INVOKE, Summary: "Admin only. Settles a billing period",
+		Args: []ArgSpec{required("periodId", ARG_STRING), required("start", ARG_STRING), required("end", ARG_STRING)}},
+	{Function: "set_rule", Call: CALL_INVOKE, Summary: "Admin only. Adds or replaces a rule",
+		Args: []ArgSpec{required("rule", ARG_JSON_OBJECT)}},
+	{Function: "delete_rule", Call: CALL_INVOKE, Summary: "Admin only. Deletes a rule",
+		Args: []ArgSpec{required("ruleId", ARG_STRING)}},
+	{Function: "acknowledge_alert", Call: CALL_INVOKE, Summary: "Admin only. Marks an open alert as being looked into",
+		Args: []ArgSpec{required("alertId", ARG_INT), required("note", ARG_STRING)}},
+	{Function: "resolve_alert", Call: CALL_INVOKE, Summary: "Admin only. Closes an open or acknowledged alert",
+		Args: []ArgSpec{required("alertId", ARG_INT), required("note", ARG_STRING)}},
+	{Function: "heartbeat", Call: CALL_INVOKE, Summary: "Kiosk or admin only. Reports the firmware and health of a kiosk",
+		Args: []ArgSpec{required("kioskId", ARG_STRING), required("firmware", ARG_STRING), emptyable("health", ARG_JSON_OBJECT)}},
+	{Function: "anchor_document", Call: CALL_INVOKE, Summary: "Anchors the hash of an off-chain document to an activity resource",
+		Args: []ArgSpec{required("activityId", ARG_INT), required("resourceId", ARG_STRING), required("algorithm", ARG_STRING),
+			required("digest", ARG_STRING), required("size", ARG_INT), required("mediaType", ARG_STRING)}},
+	{Function: "set_token_award", Call: CALL_INVOKE, Summary: "Admin only. Sets the tokens awarded per activity of a type",
+		Args: []ArgSpec{required("activityType", ARG_STRING), required("amount", ARG_INT)}},
+	{Function: "transfer_tokens", Call: CALL_INVOKE, Summary: "Admin, holder or a kiosk the account used only. Moves tokens between accounts",
+		Args: []ArgSpec{required("from", ARG_STRING), required("to", ARG_STRING), required("amount", ARG_INT), required("nonce", ARG_INT)}},
+	{Function: "redeem_tokens", Call: CALL_INVOKE, Summary: "Kiosk of the activity or admin only. Records a redeem activity and debits its actor",
+		Args: concatArgs([]ArgSpec{required("amount", ARG_INT), required("nonce", ARG_INT)}, activityArgs), Repeat: resourceArgs},
+	{Function: "reserve_slot", Call: CALL_INVOKE, Summary: "Kiosk, admin or holder only. Holds a kiosk slot for a resource",
+		Args: []ArgSpec{required("kioskId", ARG_STRING), required("resourceId", ARG_STRING), required("holder", ARG_STRING),
+			emptyable("holdDuration", ARG_STRING)}},
+	{Function: "release_reservation", Call: CALL_INVOKE, Summary: "Reserving identity or admin only. Frees the slot of a held reservation",
+		Args: []ArgSpec{required("reservationId", ARG_INT)}},
+	{Function: "expire_reservations", Call: CALL_INVOKE, Summary: "Records the held reservations past their expiry as expired"},
+	{Function: "grant_consent", Call: CALL_INVOKE, Summary: "Admin or kiosk only. Records an actor's consent to a purpose",
+		Args: concatArgs(actorArgs, []ArgSpec{required("purpose", ARG_STRING), required("scope", ARG_JSON_ARRAY)})},
+	{Function: "withdraw_consent", Call: CALL_INVOKE, Summary: "Admin or kiosk only. Withdraws a consent",
+		Args: []ArgSpec{required("consentId", ARG_INT)}},
+	{Function: "set_consent_requirement", Call: CALL_INVOKE, Summary: "Admin only. Sets the purposes an activity type requires consent to",
+		Args: []ArgSpec{required("activityType", ARG_STRING), required("purposes", ARG_JSON_ARRAY)}},
+	{Function: "audited_view", Call: CALL_INVOKE, Summary: "view_activities with contact data, records the access and its reason",
+		Args: concatArgs([]ArgSpec{required("reasonCode", ARG_STRING)}, activityFilterArgs)},
+	{Function: "set_log_level", Call: CALL_INVOKE, Summary: "Super admin only. Sets the log level of every tenant",
+		Args: []ArgSpec{required("level", ARG_STRING)}},
+
+	{Function: "view_activities", Call: CALL_QUERY, Summary: "Returns the activities matching the filter, contact data redacted",
+		Args: activityFilterArgs},
+	{Function: "aggregate_activities", Call: CALL_QUERY, Summary: "Counts the activities matching the filter by dimension",
+		Args: concatArgs([]ArgSpec{required("groupBy", ARG_JSON_ARRAY)}, activityFilterArgs)},
+	{Function: "kiosks_within_radius", Call: CALL_QUERY, Summary: "Returns the kiosks within a radius in meters",
+		Args: []ArgSpec{required("latitude", ARG_NUMBER), required("longitude", ARG_NUMBER), required("radius", ARG_NUMBER)}},
+	{Function: "kiosks_in_box", Call: CALL_QUERY, Summary: "Returns the kiosks inside a bounding box, minLongitude > maxLongitude crosses the antimeridian",
+		Args: []ArgSpec{required("minLatitude", ARG_NUMBER), required("minLongitude", ARG_NUMBER), required("maxLatitude", ARG_NUMBER),
+			required("maxLongitude", ARG_NUMBER)}},
+	{Function: "nearest_kiosks", Call: CALL_QUERY, Summary: "Returns the kiosks nearest to a point",
+		Args: []ArgSpec{required("latitude", ARG_NUMBER), required("longitude", ARG_NUMBER), required("count", ARG_INT)}},
+	{Function: "export_activities", Call: CALL_QUERY, Summary: "Exports the activities matching the filter in chunks",
+		Args: concatArgs([]ArgSpec{required("format", ARG_STRING), emptyable("options", ARG_JSON_OBJECT)}, activityFilterArgs)},
+	{Function: "activity_count", Call: CALL_QUERY, Summary: "Returns the number of activities recorded"},
+	{Function: "view_kiosks", Call: CALL_QUERY, Summary: "Returns the registered kiosks",
+		Args: []ArgSpec{optional("kioskIds", ARG_JSON_ARRAY)}},
+	{Function: "verify_chain", Call: CALL_QUERY, Summary: "Verifies the global chain, or with \"kiosk\" and a kioskId that kiosk's chain",
+		Args: []ArgSpec{optional("scope", ARG_STRING), optional("kioskId", ARG_STRING)}},
+	{Function: "verify_document", Call: CALL_QUERY, Summary: "Returns whether a document hash is anchored",
+		Args: []ArgSpec{required("algorithm", ARG_STRING), required("digest", ARG_STRING)}},
+	{Function: "view_archive", Call: CALL_QUERY, Summary: "Returns the headers of the archive segments"},
+	{Function: "resource_as_of", Call: CALL_QUERY, Summary: "Returns a resource as of a point in time",
+		Args: []ArgSpec{required("resourceId", ARG_STRING), required("time", ARG_STRING)}},
+	{Function: "kiosk_as_of", Call: CALL_QUERY, Summary: "Returns a kiosk as of a point in time",
+		Args: []ArgSpec{required("kioskId", ARG_STRING), required("time", ARG_STRING)}},
+	{Function: "device_as_of", Call: CALL_QUERY, Summary: "Returns where a device was as of a point in time",
+		Args: []ArgSpec{required("deviceType", ARG_STRING), required("id1", ARG_STRING), required("time", ARG_STRING)}},
+	{Function: "stale_kiosks", Call: CALL_QUERY, Summary: "Returns the kiosks not seen within a threshold such as 90m",
+		Args: []ArgSpec{optional("threshold", ARG_STRING)}},
+	{Function: "view_alerts", Call: CALL_QUERY, Summary: "Returns the alerts by status and kiosk",
+		Args: []ArgSpec{optional("statuses", ARG_JSON_ARRAY), optional("kioskIds", ARG_JSON_ARRAY)}},
+	{Function: "view_rules", Call: CALL_QUERY, Summary: "Returns every rule"},
+	{Function: "view_tariffs", Call: CALL_QUERY, Summary: "Returns every tariff"},
+	{Function: "usage_report", Call: CALL_QUERY, Summary: "Admin only. Meters the activities in a span without settling them",
+		Args: []ArgSpec{required("start", ARG_STRING), required("end", ARG_STRING)}},
+	{Function: "view_statements", Call: CALL_QUERY, Summary: "Returns the closed periods, or the statements of one period",
+		Args: []ArgSpec{optional("periodId", ARG_STRING), optional("party", ARG_STRING)}},
+	{Function: "token_balance", Call: CALL_QUERY, Summary: "Returns the balance of a token account",
+		Args: []ArgSpec{required("accountId", ARG_STRING)}},
+	{Function: "token_statement", Call: CALL_QUERY, Summary: "Returns a token account with every entry, transfer counterparties blanked",
+		Args: []ArgSpec{required("accountId", ARG_STRING)}},
+	{Function: "view_token_awards", Call: CALL_QUERY, Summary: "Returns the tokens awarded per activity type"},
+	{Function: "view_reservations", Call: CALL_QUERY, Summary: "Returns the reservations by status and kiosk, holders blanked",
+		Args: []ArgSpec{optional("statuses", ARG_JSON_ARRAY), optional("kioskIds", ARG_JSON_ARRAY)}},
+	{Function: "slot_availability", Call: CALL_QUERY, Summary: "Returns the free slots of a kiosk",
+		Args: []ArgSpec{required("kioskId", ARG_STRING)}},
+	{Function: "view_consents", Call: CALL_QUERY, Summary: "Returns the consents, of one actor when given",
+		Args: optionalActorArgs},
+	{Function: "view_consent_requirements", Call: CALL_QUERY, Summary: "Returns the purposes required per activity type"},
+	{Function: "view_access_log", Call: CALL_QUERY, Summary: "Admin only. Returns the PII access log, of one actor when given",
+		Args: optionalActorArgs},
+	{Function: "audited_result", Call: CALL_QUERY, Summary: "Returns the activities of the caller's audited_view transaction",
+		Args: []ArgSpec{required("txId", ARG_STRING)}},
+	{Function: "tenant_report", Call: CALL_QUERY, Summary: "Super admin only. Summarises every tenant",
+		Args: []ArgSpec{optional("tenants", ARG_JSON_ARRAY)}},
+	{Function: "usage", Call: CALL_QUERY, Summary: "Describes the arguments of every function, or of one",
+		Args: []ArgSpec{optional("function", ARG_STRING)}},
+}
+
+//=================================================================================================================================
+//	 usage - args[0] is an optional function name. Returns the FunctionSpec of every function, or of that function.
+//=================================================================================================================================
+func (t *SimpleChaincode) usage(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	specs := []FunctionSpec{}
+	for _, spec := range functionSpecs {
+		if len(args) > 0 && args[0] != "" && spec.Function != args[0] {
+			continue
+		}
+		spec.Usage = spec.usage()
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, notFound("Function " + args[0] + " not found")
+	}
+
+	return json.Marshal(specs)
+}
+
+//=================================================================================================================================
+//	 check_args - Checks the number and kinds of the arguments of a function before it is dispatched, so functions can
+//				  index args without checking their length. Unknown functions are left to the dispatcher.
+//=================================================================================================================================
+func check_args(call string, function string, args []string) error {
+	for _, spec := range functionSpecs {
+		if spec.Call == call && spec.Function == function {
+			return spec.check(args)
+		}
+	}
+	return nil
+}
+
+func (spec FunctionSpec) check(args []string) error {
+	minimum := 0
+	for i, arg := range spec.Args {
+		if !arg.Optional {
+			minimum = i + 1
+		}
+	}
+
+	countOk := len(args) >= minimum && len(args) <= len(spec.Args)
+	if len(spec.Repeat) > 0 {
+		countOk = len(args) >= len(spec.Args) && (len(args)-len(spec.Args))%len(spec.Repeat) == 0
+	}
+	if !countOk {
+		return invalidArgument(-1, "", "Incorrect number of arguments. Expecting " + spec.usage())
+	}
+
+	for i, value := range args {
+		var arg ArgSpec
+		if i < len(spec.Args) {
+			arg = spec.Args[i]
+		} else {
+			arg = spec.Repeat[(i-len(spec.Args))%len(spec.Repeat)]
+		}
+
+		err := arg.check(i, value)
+		if err != nil { return err }
+	}
+
+	return nil
+}
+
+func (arg ArgSpec) check(index int, value string) error {
+	if value == "" && (arg.Optional || arg.Empty) {
+		return nil
+	}
+
+	var err error
+	var expecting string
+	switch arg.Kind {
+	case ARG_INT:
+		_, err = strconv.ParseInt(value, 10, 64)
+		expecting = "an integer"
+	case ARG_NUMBER:
+		_, err = strconv.ParseFloat(value, 64)
+		expecting = "a number"
+	case ARG_BOOL:
+		_, err = strconv.ParseBool(value)
+		expecting = "true or false"
+	case ARG_JSON_ARRAY:
+		var array []interface{}
+		err = json.Unmarshal([]byte(value), &array)
+		expecting = "a JSON array"
+	case ARG_JSON_OBJECT:
+		var object map[string]interface{}
+		err = json.Unmarshal([]byte(value), &object)
+		expecting = "a JSON object"
+	}
+
+	if err != nil {
+		return invalidArgument(index, arg.Name, "Invalid " + arg.Name + ". Expecting " + expecting)
+	}
+	return nil
+}
+
+// usage describes the arguments, optional ones in brackets and the repeated group followed by "...", e.g.
+// "view_kiosks [kioskIds]".
+func (spec FunctionSpec) usage() string {
+	parts := []string{spec.Function}
+	for _, arg := range spec.Args {
+		if arg.Optional {
+			parts = append(parts, "[" + arg.Name + "]")
+		} else {
+			parts = append(parts, arg.Name)
+		}
+	}
+
+	if len(spec.Repeat) > 0 {
+		names := make([]string, len(spec.Repeat))
+		for i, arg := range spec.Repeat {
+			names[i] = arg.Name
+		}
+		parts = append(parts, "[" + strings.Join(names, " ") + "]...")
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckArgs(t *testing.T) {
+	resource := []string{"hdb", "letter", "L1", "renewal form"}
+	activity := []string{"user", "Tan", "91234567", "tan@example.com", "deposit", "K1", "1.2903", "103.8520", "Blk 1 void deck",
+		"", "scanner", "S1", "", "", ""}
+
+	tests := []struct {
+		name string
+		call string
+		function string
+		args []string
+		ok bool
+		arg int												// index of the rejected argument, -1 for the count
+	}{
+		{"all required", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85", "Blk 1"}, true, 0},
+		{"optional given", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85", "Blk 1", "4"}, true, 0},
+		{"optional left empty", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85", "Blk 1", ""}, true, 0},
+		{"required missing", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85"}, false, -1},
+		{"too many", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85", "Blk 1", "4", "x"}, false, -1},
+		{"number not a number", CALL_INVOKE, "register_kiosk", []string{"K1", "north", "103.85", "Blk 1"}, false, 1},
+		{"required number empty", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "", "Blk 1"}, false, 2},
+		{"int not an int", CALL_INVOKE, "register_kiosk", []string{"K1", "1.29", "103.85", "Blk 1", "4.5"}, false, 4},
+		{"int", CALL_INVOKE, "set_retention", []string{"30"}, true, 0},
+		{"bool", CALL_INVOKE, "set_event_config", []string{"true"}, true, 0},
+		{"bool not a bool", CALL_INVOKE, "set_event_config", []string{"maybe"}, false, 0},
+		{"no arguments", CALL_QUERY, "activity_count", nil, true, 0},
+		{"no arguments given one", CALL_QUERY, "activity_count", []string{"x"}, false, -1},
+		{"JSON array", CALL_QUERY, "view_kiosks", []string{`["K1"]`}, true, 0},
+		{"JSON object not an array", CALL_QUERY, "view_kiosks", []string{`{"kioskId":"K1"}`}, false, 0},
+		{"JSON array malformed", CALL_QUERY, "view_kiosks", []string{`["K1"`}, false, 0},
+		{"JSON object", CALL_QUERY, "export_activities", append([]string{"csv", `{"chunkSize":10}`}, filterArgs()...), true, 0},
+		{"emptyable object left empty", CALL_QUERY, "export_activities", append([]string{"csv", ""}, filterArgs()...), true, 0},
+		{"JSON object not an object", CALL_QUERY, "export_activities", append([]string{"csv", "[]"}, filterArgs()...), false, 1},
+		{"no resources", CALL_INVOKE, "create_activity", activity, true, 0},
+		{"two resources", CALL_INVOKE, "create_activity", concatStrings(activity, resource, resource), true, 0},
+		{"partial resource", CALL_INVOKE, "create_activity", concatStrings(activity, resource, resource[:2]), false, -1},
+		{"repeated pairs", CALL_INIT, "init", []string{"ops1", "cert1", "tan", "cert2"}, true, 0},
+		{"repeated pair incomplete", CALL_INIT, "init", []string{"ops1"}, false, -1},
+		{"unknown function", CALL_INVOKE, "no_such_function", []string{"x"}, true, 0},
+		{"query called as invoke", CALL_INVOKE, "activity_count", []string{"x"}, true, 0},
+	}
+
+	for _, test := range tests {
+		err := check_args(test.call, test.function, test.args)
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: rejected %v: %v", test.name, test.args, err)
+			}
+			continue
+		}
+
+		e, isChaincodeError := err.(*ChaincodeError)
+		switch {
+		case !isChaincodeError || e.Code != ERR_INVALID_ARGUMENT:
+			t.Errorf("%s: got %v, want an invalid argument", test.name, err)
+		case test.arg < 0 && e.Arg != nil:
+			t.Errorf("%s: rejected argument %d, want the count rejected", test.name, *e.Arg)
+		case test.arg >= 0 && (e.Arg == nil || *e.Arg != test.arg):
+			t.Errorf("%s: got %v, want argument %d rejected", test.name, err, test.arg)
+		}
+	}
+}
+
+func TestFunctionSpecs(t *testing.T) {
+	seen := map[string]bool{}
+	for _, spec := range functionSpecs {
+		key := spec.Call + " " + spec.Function
+		if seen[key] {
+			t.Errorf("%s: listed twice", key)
+		}
+		seen[key] = true
+
+		if len(spec.Repeat) > 0 {
+			for _, arg := range spec.Args {
+				if arg.Optional {
+					t.Errorf("%s: optional argument %s before a repeated group", key, arg.Name)
+				}
+			}
+		}
+
+		optionalSeen := false
+		for _, arg := range spec.Args {
+			if optionalSeen && !arg.Optional {
+				t.Errorf("%s: required argument %s after an optional one", key, arg.Name)
+			}
+			optionalSeen = optionalSeen || arg.Optional
+		}
+	}
+}
+
+// filterArgs is an empty view_activities filter.
+func filterArgs() []string {
+	args := make([]string, len(activityFilterArgs))
+	for i, arg := range activityFilterArgs {
+		if arg.Kind == ARG_JSON_ARRAY {
+			args[i] = "[]"
+		}
+	}
+	return args
+}
+
+func concatStrings(groups ...[]string) []string {
+	var args []string
+	for _, group := range groups {
+		args = append(args, group...)
+	}
+	return args
+}
@@ -0,0 +1,249 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// KIOSK STATE SOURCE - where kiosk_as_of took the kiosk attributes from
+// ============================================================================================================================
+const SOURCE_HISTORY = "history"
+const SOURCE_ACTIVITIES = "activities"
+
+//==============================================================================================================================
+//	ResourceState - A resource as of a point in time: its attributes, where it was and who handled it in the latest
+//					activity carrying it at or before AsOf.
+//==============================================================================================================================
+type ResourceState struct {
+	ResourceId string `json:"resourceId"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	ResourceOwner string `json:"resourceOwner,omitempty"`
+	ResourceType string `json:"resourceType,omitempty"`
+	Details string `json:"details,omitempty"`
+	Kiosk *Kiosk `json:"kiosk,omitempty"`
+	Actor *Actor `json:"actor,omitempty"`
+	Device *Device `json:"device,omitempty"`
+	LastActivityId int64 `json:"lastActivityId"`
+	LastActivityType string `json:"lastActivityType,omitempty"`
+	LastActivityAt int64 `json:"lastActivityAt"`
+	Activities int `json:"activities"`						// activities carrying the resource up to AsOf
+}
+
+//==============================================================================================================================
+//	DeviceBinding - The latest device of a device type used at a kiosk.
+//==============================================================================================================================
+type DeviceBinding struct {
+	Device Device `json:"device"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen int64 `json:"lastSeen"`
+	LastActivityId int64 `json:"lastActivityId"`
+}
+
+type KioskState struct {
+	KioskId string `json:"kioskId"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Registered bool `json:"registered"`
+	Source string `json:"source,omitempty"`					// one of the SOURCE_ constants
+	Devices []DeviceBinding `json:"devices"`				// sorted by device type
+}
+
+type DeviceState struct {
+	DeviceType string `json:"deviceType"`
+	Id1 string `json:"id1"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	Device *Device `json:"device,omitempty"`
+	KioskId string `json:"kioskId,omitempty"`
+	FirstSeen int64 `json:"firstSeen"`						// first use at KioskId
+	LastSeen int64 `json:"lastSeen"`
+	LastActivityId int64 `json:"lastActivityId"`
+}
+
+//=================================================================================================================================
+//	 resource_as_of - args: resourceId, time. Replays the activities carrying the resource, archived ones included,
+//					  up to the time. The time takes the same formats as the view_activities bounds.
+//=================================================================================================================================
+func (t *SimpleChaincode) resource_as_of(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. resourceId and time")
+	}
+
+	asOf, err := parseAsOf(args, 1)
+	if err != nil { log_failure(stub, "Invalid time", err); return nil, err }
+
+	activities, err := load_activities(stub, true)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	state := ResourceState{ResourceId: args[0], AsOf: asOf}
+	for i := range activities {
+		activity := activities[i]
+		if activity.Timestamp > asOf {
+			continue
+		}
+
+		for _, resource := range activity.Resources {
+			if resource.ResourceId != state.ResourceId {
+				continue
+			}
+
+			// activities are kept in creation order, a later activity with an earlier timestamp does not override
+			if state.Found && activity.Timestamp < state.LastActivityAt {
+				state.Activities++
+				break
+			}
+
+			state.Found = true
+			state.Activities++
+			state.ResourceOwner = resource.ResourceOwner
+			state.ResourceType = resource.ResourceType
+			state.Details = resource.Details
+			state.Kiosk = &activity.Kiosk
+			state.Actor = &activity.Actor
+			state.Device = &activity.Device
+			state.LastActivityId = activity.ActivityId
+			state.LastActivityType = activity.ActivityType
+			state.LastActivityAt = activity.Timestamp
+			break
+		}
+	}
+
+	return json.Marshal(state)
+}
+
+//=================================================================================================================================
+//	 kiosk_as_of - args: kioskId, time. Takes the kiosk attributes from the registry history, or from the activities at
+//				   the kiosk when the history does not go back that far, and the device bindings from the activities.
+//=================================================================================================================================
+func (t *SimpleChaincode) kiosk_as_of(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. kioskId and time")
+	}
+
+	asOf, err := parseAsOf(args, 1)
+	if err != nil { log_failure(stub, "Invalid time", err); return nil, err }
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	activities, err := load_activities(stub, true)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	state := KioskState{KioskId: args[0], AsOf: asOf, Devices: []DeviceBinding{}}
+
+	for _, revision := range kiosks.Kiosks[state.KioskId].History {
+		if revision.ChangedAt > asOf {
+			break
+		}
+		state.Found = true
+		state.Source = SOURCE_HISTORY
+		state.Latitude, state.Longitude, state.Details, state.Registered = revision.Latitude, revision.Longitude, revision.Details, revision.Registered
+	}
+
+	bindings := make(map[string]DeviceBinding)
+	for i := range activities {
+		activity := activities[i]
+		if activity.Kiosk.KioskId != state.KioskId || activity.Timestamp > asOf {
+			continue
+		}
+
+		if state.Source != SOURCE_HISTORY {
+			state.Found = true
+			state.Source = SOURCE_ACTIVITIES
+			state.Latitude, state.Longitude, state.Details = activity.Kiosk.Latitude, activity.Kiosk.Longitude, activity.Kiosk.Details
+		}
+
+		binding, bound := bindings[activity.Device.DeviceType]
+		if !bound || binding.Device != activity.Device {
+			binding = DeviceBinding{Device: activity.Device, FirstSeen: activity.Timestamp}
+		}
+		binding.LastSeen = activity.Timestamp
+		binding.LastActivityId = activity.ActivityId
+		bindings[activity.Device.DeviceType] = binding
+	}
+
+	for _, binding := range bindings {
+		state.Devices = append(state.Devices, binding)
+	}
+	sort.Sort(byDeviceType(state.Devices))
+
+	return json.Marshal(state)
+}
+
+//=================================================================================================================================
+//	 device_as_of - args: deviceType, id1, time. Returns the kiosk the device was last used at, at or before the time.
+//=================================================================================================================================
+func (t *SimpleChaincode) device_as_of(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 3. deviceType, id1 and time")
+	}
+
+	asOf, err := parseAsOf(args, 2)
+	if err != nil { log_failure(stub, "Invalid time", err); return nil, err }
+
+	activities, err := load_activities(stub, true)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	state := DeviceState{DeviceType: args[0], Id1: args[1], AsOf: asOf}
+	for i := range activities {
+		activity := activities[i]
+		if activity.Device.DeviceType != state.DeviceType || activity.Device.Id1 != state.Id1 || activity.Timestamp > asOf {
+			continue
+		}
+
+		if !state.Found || activity.Kiosk.KioskId != state.KioskId {
+			state.KioskId = activity.Kiosk.KioskId
+			state.FirstSeen = activity.Timestamp
+		}
+		state.Found = true
+		state.Device = &activity.Device
+		state.LastSeen = activity.Timestamp
+		state.LastActivityId = activity.ActivityId
+	}
+
+	return json.Marshal(state)
+}
+
+// parseAsOf reads a point in time in any of the view_activities time formats, in UTC when it carries no offset, and
+// returns it in epoch milliseconds. A date means the end of that day.
+func parseAsOf(args []string, arg int) (int64, error) {
+	value := args[arg]
+	asOf, err := parseTime(value, time.UTC)
+	if err != nil { return 0, invalidArgument(arg, "time", "Invalid time format " + value) }
+
+	if _, err := time.Parse(dateLayout, value); err == nil {
+		asOf = asOf.AddDate(0, 0, 1).Add(-time.Millisecond)
+	}
+
+	return asOf.UnixNano() / int64(time.Millisecond), nil
+}
+
+type byDeviceType []DeviceBinding
+
+func (a byDeviceType) Len() int           { return len(a) }
+func (a byDeviceType) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDeviceType) Less(i, j int) bool { return a[i].Device.DeviceType < a[j].Device.DeviceType }
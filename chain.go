@@ -0,0 +1,256 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var chainHeadsStr = "_chainHeads"
+
+// ============================================================================================================================
+// CHAIN SCOPE
+// ============================================================================================================================
+const CHAIN_GLOBAL = "global"
+const CHAIN_KIOSK = "kiosk"
+
+//==============================================================================================================================
+//	ChainHeads - Hash of the latest activity overall and per kiosk, the PrevHash of the next activity.
+//==============================================================================================================================
+type ChainHeads struct {
+	Global string `json:"global"`
+	Kiosks map[string]string `json:"kiosks"`
+}
+
+//==============================================================================================================================
+//	ChainReport - Result of verify_chain. Unchained counts the activities created before hash chaining was introduced,
+//				  they precede the chain and are not verified. BrokenAt is the first activity failing verification.
+//==============================================================================================================================
+type ChainReport struct {
+	Scope string `json:"scope"`
+	KioskId string `json:"kioskId,omitempty"`
+	Checked int `json:"checked"`
+	Unchained int `json:"unchained"`
+	Segments int `json:"segments"`						// archive segments verified before _activities
+	Valid bool `json:"valid"`
+	BrokenAt *int64 `json:"brokenAt,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Head string `json:"head"`
+}
+
+//=================================================================================================================================
+//	 chain_activity - Sets PrevHash, KioskPrevHash and Hash on a new activity and moves the chain heads to it.
+//=================================================================================================================================
+func chain_activity(stub shim.ChaincodeStubInterface, activity *Activity) error {
+	heads, err := get_chain_heads(stub)
+	if err != nil { return err }
+
+	activity.PrevHash = heads.Global
+	activity.KioskPrevHash = heads.Kiosks[activity.Kiosk.KioskId]
+	activity.Hash, err = activityHash(*activity)
+	if err != nil { return err }
+
+	heads.Global = activity.Hash
+	heads.Kiosks[activity.Kiosk.KioskId] = activity.Hash
+
+	headsAsBytes, err := json.Marshal(heads)
+	if err != nil { return err }
+
+	return stub.PutState(chainHeadsStr, headsAsBytes)
+}
+
+func get_chain_heads(stub shim.ChaincodeStubInterface) (ChainHeads, error) {
+	var heads ChainHeads
+
+	headsAsBytes, err := stub.GetState(chainHeadsStr)
+	if err != nil { return heads, err }
+
+	if len(headsAsBytes) > 0 {
+		err = json.Unmarshal(headsAsBytes, &heads)
+		if err != nil { return heads, corruptState(chainHeadsStr, "Corrupt chain heads record") }
+	}
+
+	if heads.Kiosks == nil {
+		heads.Kiosks = make(map[string]string)
+	}
+
+	return heads, nil
+}
+
+// activityHash is the hex SHA-256 of the canonical serialization: the stored JSON without the hash itself and without
+// the fields only set on query results.
+func activityHash(activity Activity) (string, error) {
+	activity.Hash = ""
+	activity.TimestampFormatted = ""
+
+	if len(activity.Resources) > 0 {
+		resources := make([]Resource, len(activity.Resources))
+		for i, resource := range activity.Resources {
+			resource.Documents = nil
+			resources[i] = resource
+		}
+		activity.Resources = resources
+	}
+
+	canonical, err := json.Marshal(activity)
+	if err != nil { return "", err }
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//=================================================================================================================================
+//	 verify_chain - Walks the activity chain and reports the first broken link. args: none for the global chain, or
+//					"kiosk" and a kioskId for that kiosk's chain.
+//=================================================================================================================================
+func (t *SimpleChaincode) verify_chain(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	report := ChainReport{Scope: CHAIN_GLOBAL}
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != CHAIN_KIOSK {
+			return nil, invalidArgument(-1, "", "Incorrect arguments. Expecting none for the global chain, or \"kiosk\" and a kioskId")
+		}
+		report.Scope = CHAIN_KIOSK
+		report.KioskId = args[1]
+	}
+
+	heads, err := get_chain_heads(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve chain heads", err); return nil, internalError("Failed to retrieve chain heads", err) }
+
+	report.Head = heads.Global
+	if report.Scope == CHAIN_KIOSK {
+		report.Head = heads.Kiosks[report.KioskId]
+	}
+
+	index, err := get_archive_index(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve archive index", err); return nil, internalError("Failed to retrieve archive index", err) }
+
+	prevHash, prevSealHash := "", ""
+	for _, header := range index.Segments {
+		segment, err := get_archive_segment(stub, header.Segment)
+		if err != nil { log_failure(stub, "Failed to retrieve archive segment", err); return nil, internalError("Failed to retrieve archive segment " + strconv.Itoa(header.Segment), err) }
+
+		if !verify_segment(&report, header, segment, prevSealHash) {
+			return json.Marshal(report)
+		}
+		prevSealHash = header.SealHash
+
+		prevHash = verify_activities(&report, prevHash, segment.Activities)
+		if report.BrokenAt != nil {
+			return json.Marshal(report)
+		}
+		report.Segments++
+	}
+
+	activitiesAsBytes, err := stub.GetState(activitiesStr)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	var activities AllActivities
+	err = json.Unmarshal(activitiesAsBytes, &activities)
+	if len(activitiesAsBytes) > 0 && err != nil { log_failure(stub, "Corrupt activities record", err); return nil, corruptState(activitiesStr, "Corrupt activities record") }
+
+	prevHash = verify_activities(&report, prevHash, activities.Activities)
+	if report.BrokenAt != nil {
+		return json.Marshal(report)
+	}
+
+	report.Valid = prevHash == report.Head
+	if !report.Valid {
+		report.Reason = "last activity does not match the chain head, activities were removed from the end"
+	}
+
+	return json.Marshal(report)
+}
+
+// verify_segment checks that the archive segment matches its index header, its Merkle root and seal, and links to the
+// previous segment.
+func verify_segment(report *ChainReport, header ArchiveHeader, segment ArchiveSegment, prevSealHash string) bool {
+	reason := ""
+
+	root, rootErr := merkleRoot(segment.Activities)
+	seal, sealErr := sealHash(segment.Header)
+	switch {
+	case segment.Header != header:
+		reason = "does not match its archive index entry"
+	case segment.Header.Count != len(segment.Activities):
+		reason = "activity count does not match its header"
+	case rootErr != nil || root != segment.Header.MerkleRoot:
+		reason = "activities do not match the Merkle root"
+	case sealErr != nil || seal != segment.Header.SealHash:
+		reason = "header does not match its seal"
+	case segment.Header.PrevSealHash != prevSealHash:
+		reason = "previous seal hash does not match the preceding segment"
+	}
+
+	if reason != "" {
+		report.broken(header.FirstActivityId, "archive segment " + strconv.Itoa(header.Segment) + " " + reason)
+		return false
+	}
+	return true
+}
+
+// verify_activities checks the activities in order starting from prevHash and fills the report, returning the hash
+// of the last verified activity. Activities created before hash chaining are only skipped while no hashed activity
+// has been seen.
+func verify_activities(report *ChainReport, prevHash string, activities []Activity) string {
+	started := prevHash != ""
+
+	for _, activity := range activities {
+		if report.Scope == CHAIN_KIOSK && activity.Kiosk.KioskId != report.KioskId {
+			continue
+		}
+
+		if activity.Hash == "" && !started {
+			report.Unchained++
+			continue
+		}
+		started = true
+
+		link := activity.PrevHash
+		if report.Scope == CHAIN_KIOSK {
+			link = activity.KioskPrevHash
+		}
+
+		hash, err := activityHash(activity)
+		switch {
+		case activity.Hash == "":
+			report.broken(activity.ActivityId, "activity is not hashed")
+		case err != nil || hash != activity.Hash:
+			report.broken(activity.ActivityId, "activity content does not match its hash")
+		case link != prevHash:
+			report.broken(activity.ActivityId, "previous hash does not match the preceding activity")
+		}
+		if report.BrokenAt != nil {
+			return prevHash
+		}
+
+		report.Checked++
+		prevHash = activity.Hash
+	}
+
+	return prevHash
+}
+
+func (report *ChainReport) broken(activityId int64, reason string) {
+	report.Valid = false
+	report.BrokenAt = &activityId
+	report.Reason = reason
+}
@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/khoazany/smart/sim"
+)
+
+// testChain links the activities the way chain_activity does, alternating between two kiosks.
+func testChain(n int) []Activity {
+	activities := make([]Activity, n)
+	global, kiosks := "", map[string]string{}
+	for i := range activities {
+		activity := Activity{ActivityId: int64(i + 1), ActivityType: "deposit", Timestamp: int64(1000 * (i + 1))}
+		activity.Kiosk.KioskId = []string{"K1", "K2"}[i%2]
+		activity.PrevHash = global
+		activity.KioskPrevHash = kiosks[activity.Kiosk.KioskId]
+		activity.Hash, _ = activityHash(activity)
+
+		global, kiosks[activity.Kiosk.KioskId] = activity.Hash, activity.Hash
+		activities[i] = activity
+	}
+	return activities
+}
+
+func TestVerifyActivities(t *testing.T) {
+	tests := []struct {
+		name string
+		scope string
+		tamper func(activities []Activity) []Activity
+		brokenAt int64
+		checked int
+	}{
+		{"untouched", CHAIN_GLOBAL, func(a []Activity) []Activity { return a }, 0, 5},
+		{"untouched kiosk chain", CHAIN_KIOSK, func(a []Activity) []Activity { return a }, 0, 3},
+		{"content edited", CHAIN_GLOBAL, func(a []Activity) []Activity {
+			a[2].Remark = "edited"
+			return a
+		}, 3, 2},
+		{"content edited and rehashed", CHAIN_GLOBAL, func(a []Activity) []Activity {
+			a[2].Remark = "edited"
+			a[2].Hash, _ = activityHash(a[2])
+			return a
+		}, 4, 3},
+		{"activity removed", CHAIN_GLOBAL, func(a []Activity) []Activity {
+			return append(a[:1], a[2:]...)
+		}, 3, 1},
+		{"activity removed from the kiosk chain", CHAIN_KIOSK, func(a []Activity) []Activity {
+			return append(a[:2], a[3:]...)
+		}, 5, 1},
+		{"activities swapped", CHAIN_GLOBAL, func(a []Activity) []Activity {
+			a[1], a[2] = a[2], a[1]
+			return a
+		}, 3, 1},
+		{"hash removed", CHAIN_GLOBAL, func(a []Activity) []Activity {
+			a[3].Hash = ""
+			return a
+		}, 4, 3},
+	}
+
+	for _, test := range tests {
+		activities := test.tamper(testChain(5))
+
+		report := ChainReport{Scope: test.scope}
+		if test.scope == CHAIN_KIOSK {
+			report.KioskId = "K1"
+		}
+		verify_activities(&report, "", activities)
+
+		var brokenAt int64
+		if report.BrokenAt != nil {
+			brokenAt = *report.BrokenAt
+		}
+		if brokenAt != test.brokenAt || report.Checked != test.checked {
+			t.Errorf("%s: broken at %d after %d checked (%s), want %d after %d", test.name, brokenAt, report.Checked,
+				report.Reason, test.brokenAt, test.checked)
+		}
+	}
+}
+
+func TestVerifyActivitiesUnchained(t *testing.T) {
+	chained := testChain(2)
+	activities := append([]Activity{{ActivityId: 0, ActivityType: "deposit"}}, chained...)
+
+	report := ChainReport{Scope: CHAIN_GLOBAL}
+	head := verify_activities(&report, "", activities)
+	if report.BrokenAt != nil || report.Unchained != 1 || report.Checked != 2 || head != chained[1].Hash {
+		t.Errorf("leading unchained activity: %+v, head %s", report, head)
+	}
+
+	// An unhashed activity after the chain has started is a break, not an unchained activity.
+	activities = append(testChain(2), Activity{ActivityId: 3, ActivityType: "deposit"})
+	report = ChainReport{Scope: CHAIN_GLOBAL}
+	verify_activities(&report, "", activities)
+	if report.BrokenAt == nil || *report.BrokenAt != 3 {
+		t.Errorf("trailing unhashed activity: %+v", report)
+	}
+}
+
+func TestVerifyChainAfterTampering(t *testing.T) {
+	ops := sim.Caller{Account: "ops1", Role: ADMIN}
+	at := time.Date(2016, 11, 2, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		tamper func(activities *AllActivities)
+		valid bool
+		brokenAt int64
+	}{
+		{"untouched", func(activities *AllActivities) {}, true, 0},
+		{"remark edited", func(activities *AllActivities) {
+			activities.Activities[1].Remark = "edited"
+		}, false, 1},
+		{"activity removed from the middle", func(activities *AllActivities) {
+			activities.Activities = append(activities.Activities[:1], activities.Activities[2:]...)
+		}, false, 2},
+		{"activity removed from the end", func(activities *AllActivities) {
+			activities.Activities = activities.Activities[:2]
+		}, false, 0},
+	}
+
+	for _, test := range tests {
+		h := sim.NewHarness("hdb", new(SimpleChaincode))
+		results := []sim.Result{
+			h.Init(ops, at, "init", nil),
+			h.Invoke(ops, at, "register_kiosk", []string{"K1", "1.2903", "103.8520", "Blk 1 void deck"}),
+		}
+		for i := 0; i < 3; i++ {
+			results = append(results, h.Invoke(ops, at.Add(time.Duration(i+1)*time.Minute), "create_activity", []string{
+				"user", "Tan", "91234567", "tan@example.com", "deposit", "K1", "1.2903", "103.8520", "Blk 1 void deck", "",
+				"scanner", "S1", "", "", "", "hdb", "letter", "L1", "renewal form"}))
+		}
+		for _, result := range results {
+			if result.Err != nil { t.Fatalf("%s: %s: %v", test.name, result.TxID, result.Err) }
+		}
+
+		activitiesAsBytes, _ := h.Stub.GetState(activitiesStr)
+		var activities AllActivities
+		err := json.Unmarshal(activitiesAsBytes, &activities)
+		if err != nil { t.Fatal(err) }
+
+		test.tamper(&activities)
+		activitiesAsBytes, _ = json.Marshal(activities)
+		h.Stub.PutState(activitiesStr, activitiesAsBytes)
+
+		result := h.Query(ops, at.Add(time.Hour), "verify_chain", nil)
+		if result.Err != nil { t.Fatalf("%s: %v", test.name, result.Err) }
+
+		var report ChainReport
+		err = json.Unmarshal(result.Payload, &report)
+		if err != nil { t.Fatal(err) }
+
+		var brokenAt int64
+		if report.BrokenAt != nil {
+			brokenAt = *report.BrokenAt
+		}
+		if report.Valid != test.valid || brokenAt != test.brokenAt {
+			t.Errorf("%s: valid %v broken at %d (%s), want valid %v broken at %d", test.name, report.Valid, brokenAt,
+				report.Reason, test.valid, test.brokenAt)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"strconv"
+)
+
+//==============================================================================================================================
+//	Backend - What the gateway and tools need from the chaincode. Peer talks to a Fabric network, Memory keeps
+//			  activities in process for local development.
+//==============================================================================================================================
+type Backend interface {
+	CreateActivity(activity NewActivity) (CreateResult, error)
+	ViewActivities(filter ActivityFilter) ([]Activity, error)
+}
+
+// NewActivity is the input of create_activity, the chaincode assigns ActivityId and Timestamp.
+type NewActivity struct {
+	Actor Actor `json:"actor"`
+	ActivityType string `json:"activityType"`
+	Kiosk Kiosk `json:"kiosk"`
+	Remark string `json:"remark"`
+	Device Device `json:"device"`
+	Resources []Resource `json:"resources"`
+}
+
+// CreateResult carries the transaction id when the activity was submitted to a peer, which does not return the
+// committed activity, or the activity itself when the backend creates it synchronously.
+type CreateResult struct {
+	TxId string `json:"txId,omitempty"`
+	Activity *Activity `json:"activity,omitempty"`
+}
+
+// Args returns the positional arguments expected by create_activity.
+func (a NewActivity) Args() []string {
+	args := []string{
+		a.Actor.ActorType, a.Actor.Name, a.Actor.Telephone, a.Actor.Email,
+		a.ActivityType,
+		a.Kiosk.KioskId, strconv.FormatFloat(a.Kiosk.Latitude, 'f', -1, 64), strconv.FormatFloat(a.Kiosk.Longitude, 'f', -1, 64), a.Kiosk.Details,
+		a.Remark,
+		a.Device.DeviceType, a.Device.Id1, a.Device.Id2, a.Device.Id3, a.Device.Id4,
+	}
+
+	for _, resource := range a.Resources {
+		args = append(args, resource.ResourceOwner, resource.ResourceType, resource.ResourceId, resource.Details)
+	}
+
+	return args
+}
+
+func (p *Peer) CreateActivity(activity NewActivity) (CreateResult, error) {
+	txId, err := p.Invoke("create_activity", activity.Args())
+	if err != nil {
+		return CreateResult{}, err
+	}
+	return CreateResult{TxId: txId}, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+)
+
+//==============================================================================================================================
+//	ActivityFilter - Named form of the positional view_activities arguments. Empty fields do not filter, Start and End
+//					 take any format the chaincode accepts (RFC3339, epoch milliseconds, date-only).
+//==============================================================================================================================
+type ActivityFilter struct {
+	ActivityIds []int64 `json:"activityIds,omitempty"`
+	ActorTypes []string `json:"actorTypes,omitempty"`
+	Names []string `json:"names,omitempty"`
+	Telephones []string `json:"telephones,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	ActivityTypes []string `json:"activityTypes,omitempty"`
+	KioskIds []string `json:"kioskIds,omitempty"`
+	DeviceTypes []string `json:"deviceTypes,omitempty"`
+	Id1s []string `json:"id1s,omitempty"`
+	Id2s []string `json:"id2s,omitempty"`
+	Id3s []string `json:"id3s,omitempty"`
+	Id4s []string `json:"id4s,omitempty"`
+	ResourceOwners []string `json:"resourceOwners,omitempty"`
+	ResourceTypes []string `json:"resourceTypes,omitempty"`
+	ResourceIds []string `json:"resourceIds,omitempty"`
+	Start string `json:"start,omitempty"`
+	End string `json:"end,omitempty"`
+	Options *QueryOptions `json:"options,omitempty"`
+}
+
+type QueryOptions struct {
+	Near *GeoCircle `json:"near,omitempty"`
+	Box *GeoBox `json:"box,omitempty"`
+	Bounds string `json:"bounds,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	TimeFormat string `json:"timeFormat,omitempty"`
+	IncludeArchived bool `json:"includeArchived,omitempty"`
+	Purpose string `json:"purpose,omitempty"`				// leave out actors without a covering consent to the purpose
+}
+
+type GeoCircle struct {
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius float64 `json:"radius"`
+}
+
+type GeoBox struct {
+	MinLatitude float64 `json:"minLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLatitude float64 `json:"maxLatitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+// Args returns the positional arguments expected by view_activities and the other filtered queries.
+func (f ActivityFilter) Args() []string {
+	args := []string{
+		jsonArray(f.ActivityIds), jsonArray(f.ActorTypes), jsonArray(f.Names), jsonArray(f.Telephones),
+		jsonArray(f.Emails), jsonArray(f.ActivityTypes), jsonArray(f.KioskIds), jsonArray(f.DeviceTypes),
+		jsonArray(f.Id1s), jsonArray(f.Id2s), jsonArray(f.Id3s), jsonArray(f.Id4s),
+		jsonArray(f.ResourceOwners), jsonArray(f.ResourceTypes), jsonArray(f.ResourceIds),
+		f.Start, f.End,
+	}
+
+	if f.Options != nil {
+		optionsAsBytes, _ := json.Marshal(f.Options)
+		args = append(args, string(optionsAsBytes))
+	}
+
+	return args
+}
+
+func jsonArray(values interface{}) string {
+	valuesAsBytes, err := json.Marshal(values)
+	if err != nil || string(valuesAsBytes) == "null" {
+		return "[]"
+	}
+	return string(valuesAsBytes)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+//==============================================================================================================================
+//	Memory - In-process Backend for local development. It applies the list filters and an inclusive start/end given as
+//			 RFC3339 or epoch milliseconds; the geo and timezone options are ignored.
+//==============================================================================================================================
+type Memory struct {
+	mu sync.Mutex
+	activities []Activity
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) CreateActivity(activity NewActivity) (CreateResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := Activity{
+		ActivityId: int64(len(m.activities)), Actor: activity.Actor, ActivityType: activity.ActivityType, Kiosk: activity.Kiosk,
+		Resources: activity.Resources, Device: activity.Device, Remark: activity.Remark,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	m.activities = append(m.activities, created)
+
+	return CreateResult{Activity: &created}, nil
+}
+
+func (m *Memory) ViewActivities(filter ActivityFilter) ([]Activity, error) {
+	start, err := parseBound(filter.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseBound(filter.End)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []Activity{}
+	for _, activity := range m.activities {
+		if filter.matches(activity) && (start == nil || activity.Timestamp >= *start) && (end == nil || activity.Timestamp <= *end) {
+			result = append(result, activity)
+		}
+	}
+
+	return result, nil
+}
+
+func (f ActivityFilter) matches(a Activity) bool {
+	if len(f.ActivityIds) > 0 {
+		found := false
+		for _, id := range f.ActivityIds {
+			found = found || id == a.ActivityId
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !in(f.ActorTypes, a.Actor.ActorType) || !in(f.Names, a.Actor.Name) || !in(f.Telephones, a.Actor.Telephone) ||
+		!in(f.Emails, a.Actor.Email) || !in(f.ActivityTypes, a.ActivityType) || !in(f.KioskIds, a.Kiosk.KioskId) ||
+		!in(f.DeviceTypes, a.Device.DeviceType) || !in(f.Id1s, a.Device.Id1) || !in(f.Id2s, a.Device.Id2) ||
+		!in(f.Id3s, a.Device.Id3) || !in(f.Id4s, a.Device.Id4) {
+		return false
+	}
+
+	if len(f.ResourceOwners) > 0 || len(f.ResourceTypes) > 0 || len(f.ResourceIds) > 0 {
+		for _, r := range a.Resources {
+			if in(f.ResourceOwners, r.ResourceOwner) && in(f.ResourceTypes, r.ResourceType) && in(f.ResourceIds, r.ResourceId) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// in reports whether value is allowed by the list, an empty list allows everything.
+func in(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBound(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return &ms, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return &ms, nil
+}
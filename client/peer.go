@@ -0,0 +1,587 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================================================================
+// JSON-RPC over the peer REST API (port 7050 in docker-compose.yml)
+// ============================================================================================================================
+const chaincodeTypeGolang = 1
+
+type rpcRequest struct {
+	JsonRPC string `json:"jsonrpc"`
+	Method string `json:"method"`
+	Params rpcParams `json:"params"`
+	Id int64 `json:"id"`
+}
+
+type rpcParams struct {
+	Type int `json:"type"`
+	ChaincodeID rpcChaincodeID `json:"chaincodeID"`
+	CtorMsg rpcCtorMsg `json:"ctorMsg"`
+	SecureContext string `json:"secureContext,omitempty"`
+}
+
+type rpcChaincodeID struct {
+	Name string `json:"name"`
+}
+
+type rpcCtorMsg struct {
+	Function string `json:"function"`
+	Args []string `json:"args"`
+}
+
+type rpcResponse struct {
+	Result *struct {
+		Status string `json:"status"`
+		Message string `json:"message"`
+	} `json:"result"`
+	Error *struct {
+		Code int `json:"code"`
+		Message string `json:"message"`
+		Data string `json:"data"`
+	} `json:"error"`
+}
+
+//==============================================================================================================================
+//	Peer - Calls the chaincode through a validating peer. SecureContext is the enrolled user to transact as, leave it
+//		   empty when security is disabled.
+//==============================================================================================================================
+type Peer struct {
+	URL string
+	ChaincodeName string
+	SecureContext string
+	HTTPClient *http.Client
+
+	nextId int64
+}
+
+func NewPeer(url string, chaincodeName string, secureContext string) *Peer {
+	return &Peer{URL: url, ChaincodeName: chaincodeName, SecureContext: secureContext, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Query runs a chaincode query and returns its payload.
+func (p *Peer) Query(function string, args []string) ([]byte, error) {
+	message, err := p.call("query", function, args)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(message), nil
+}
+
+// Invoke submits a chaincode transaction and returns its transaction id. The transaction is not yet committed when
+// Invoke returns.
+func (p *Peer) Invoke(function string, args []string) (string, error) {
+	return p.call("invoke", function, args)
+}
+
+func (p *Peer) call(method string, function string, args []string) (string, error) {
+	if args == nil {
+		args = []string{}
+	}
+
+	p.nextId++
+	request := rpcRequest{JsonRPC: "2.0", Method: method, Id: p.nextId, Params: rpcParams{
+		Type: chaincodeTypeGolang, ChaincodeID: rpcChaincodeID{Name: p.ChaincodeName},
+		CtorMsg: rpcCtorMsg{Function: function, Args: args}, SecureContext: p.SecureContext,
+	}}
+
+	requestAsBytes, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	httpResponse, err := p.HTTPClient.Post(p.URL+"/chaincode", "application/json", bytes.NewReader(requestAsBytes))
+	if err != nil {
+		return "", err
+	}
+	defer httpResponse.Body.Close()
+
+	var response rpcResponse
+	err = json.NewDecoder(httpResponse.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("invalid response from peer: %s", err)
+	}
+
+	if response.Error != nil {
+		chaincodeError := &ChaincodeError{Function: function, Code: response.Error.Code, Message: response.Error.Message, Data: response.Error.Data}
+		chaincodeError.parseData()
+		return "", chaincodeError
+	}
+	if response.Result == nil {
+		return "", errors.New("empty response from peer")
+	}
+
+	return response.Result.Message, nil
+}
+
+// Error codes reported by the chaincode, see ChaincodeError.ErrorCode.
+const ERR_INVALID_ARGUMENT = "INVALID_ARGUMENT"
+const ERR_NOT_FOUND = "NOT_FOUND"
+const ERR_PERMISSION_DENIED = "PERMISSION_DENIED"
+const ERR_CONFLICT = "CONFLICT"
+const ERR_CORRUPT_STATE = "CORRUPT_STATE"
+const ERR_INTERNAL = "INTERNAL"
+
+// ChaincodeError is a failure reported by the peer, Data carries the chaincode's own error. When Data holds the
+// chaincode's structured error, ErrorCode, Detail, Arg and Field are set from it; Arg is the index of the offending
+// argument or -1.
+type ChaincodeError struct {
+	Function string
+	Code int
+	Message string
+	Data string
+	ErrorCode string
+	Detail string
+	Arg int
+	Field string
+}
+
+func (e *ChaincodeError) Error() string {
+	return fmt.Sprintf("%s: %s (%d): %s", e.Function, e.Message, e.Code, e.Data)
+}
+
+// parseData reads the structured error from Data, which the peer may prefix with its own text.
+func (e *ChaincodeError) parseData() {
+	e.Arg = -1
+	e.Detail = e.Data
+
+	start := strings.Index(e.Data, "{")
+	if start < 0 {
+		return
+	}
+
+	var detail struct {
+		Code string `json:"code"`
+		Message string `json:"message"`
+		Arg *int `json:"arg"`
+		Field string `json:"field"`
+	}
+	if err := json.NewDecoder(strings.NewReader(e.Data[start:])).Decode(&detail); err != nil || detail.Code == "" {
+		return
+	}
+
+	e.ErrorCode, e.Detail, e.Field = detail.Code, detail.Message, detail.Field
+	if detail.Arg != nil {
+		e.Arg = *detail.Arg
+	}
+}
+
+// ============================================================================================================================
+// Typed chaincode calls
+// ============================================================================================================================
+// ViewActivities returns the activities matching the filter with the actors' telephone and email blanked.
+func (p *Peer) ViewActivities(filter ActivityFilter) ([]Activity, error) {
+	payload, err := p.Query("view_activities", filter.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	var activities []Activity
+	err = json.Unmarshal(payload, &activities)
+	if err != nil {
+		return nil, fmt.Errorf("invalid view_activities result: %s", err)
+	}
+	return activities, nil
+}
+
+func (p *Peer) ActivityCount() (int64, error) {
+	payload, err := p.Query("activity_count", nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(payload), 10, 64)
+}
+
+func (p *Peer) Aggregate(groupBy []string, filter ActivityFilter) ([]AggregateBucket, error) {
+	groupByAsBytes, err := json.Marshal(groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := p.Query("aggregate_activities", append([]string{string(groupByAsBytes)}, filter.Args()...))
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []AggregateBucket
+	err = json.Unmarshal(payload, &buckets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aggregate_activities result: %s", err)
+	}
+	return buckets, nil
+}
+
+func (p *Peer) Export(format string, options ExportOptions, filter ActivityFilter) (ExportChunk, error) {
+	var chunk ExportChunk
+
+	optionsAsBytes, err := json.Marshal(options)
+	if err != nil {
+		return chunk, err
+	}
+
+	payload, err := p.Query("export_activities", append([]string{format, string(optionsAsBytes)}, filter.Args()...))
+	if err != nil {
+		return chunk, err
+	}
+
+	err = json.Unmarshal(payload, &chunk)
+	if err != nil {
+		return chunk, fmt.Errorf("invalid export_activities result: %s", err)
+	}
+	return chunk, nil
+}
+
+func (p *Peer) RegisterKiosk(kiosk Kiosk) (string, error) {
+	return p.Invoke("register_kiosk", []string{kiosk.KioskId, strconv.FormatFloat(kiosk.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(kiosk.Longitude, 'f', -1, 64), kiosk.Details})
+}
+
+// RegisterKioskSlots registers the kiosk like RegisterKiosk and sets its number of reservable slots.
+func (p *Peer) RegisterKioskSlots(kiosk Kiosk, slotCapacity int) (string, error) {
+	return p.Invoke("register_kiosk", []string{kiosk.KioskId, strconv.FormatFloat(kiosk.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(kiosk.Longitude, 'f', -1, 64), kiosk.Details, strconv.Itoa(slotCapacity)})
+}
+
+func (p *Peer) ViewKiosks(kioskIds []string) ([]KioskRecord, error) {
+	payload, err := p.Query("view_kiosks", []string{jsonArray(kioskIds)})
+	if err != nil {
+		return nil, err
+	}
+
+	var kiosks []KioskRecord
+	err = json.Unmarshal(payload, &kiosks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid view_kiosks result: %s", err)
+	}
+	return kiosks, nil
+}
+
+// VerifyChain verifies the global activity chain, or the chain of a single kiosk when kioskId is set.
+func (p *Peer) VerifyChain(kioskId string) (ChainReport, error) {
+	var report ChainReport
+
+	var args []string
+	if kioskId != "" {
+		args = []string{"kiosk", kioskId}
+	}
+
+	payload, err := p.Query("verify_chain", args)
+	if err != nil {
+		return report, err
+	}
+
+	err = json.Unmarshal(payload, &report)
+	if err != nil {
+		return report, fmt.Errorf("invalid verify_chain result: %s", err)
+	}
+	return report, nil
+}
+
+// AnchorDocument anchors the hash of an off-chain document to a resource of an existing activity.
+func (p *Peer) AnchorDocument(activityId int64, resourceId string, document DocumentHash) (string, error) {
+	return p.Invoke("anchor_document", []string{strconv.FormatInt(activityId, 10), resourceId, document.Algorithm,
+		document.Digest, strconv.FormatInt(document.Size, 10), document.MediaType})
+}
+
+func (p *Peer) VerifyDocument(algorithm string, digest string) (DocumentVerification, error) {
+	var verification DocumentVerification
+
+	payload, err := p.Query("verify_document", []string{algorithm, digest})
+	if err != nil {
+		return verification, err
+	}
+
+	err = json.Unmarshal(payload, &verification)
+	if err != nil {
+		return verification, fmt.Errorf("invalid verify_document result: %s", err)
+	}
+	return verification, nil
+}
+
+func (p *Peer) ViewArchive() ([]ArchiveHeader, error) {
+	payload, err := p.Query("view_archive", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []ArchiveHeader
+	err = json.Unmarshal(payload, &headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid view_archive result: %s", err)
+	}
+	return headers, nil
+}
+
+// ResourceAsOf, KioskAsOf and DeviceAsOf reconstruct state at a point in time, given in any view_activities time format.
+func (p *Peer) ResourceAsOf(resourceId string, at string) (ResourceState, error) {
+	var state ResourceState
+	return state, p.queryInto("resource_as_of", []string{resourceId, at}, &state)
+}
+
+func (p *Peer) KioskAsOf(kioskId string, at string) (KioskState, error) {
+	var state KioskState
+	return state, p.queryInto("kiosk_as_of", []string{kioskId, at}, &state)
+}
+
+func (p *Peer) DeviceAsOf(deviceType string, id1 string, at string) (DeviceState, error) {
+	var state DeviceState
+	return state, p.queryInto("device_as_of", []string{deviceType, id1, at}, &state)
+}
+
+func (p *Peer) queryInto(function string, args []string, result interface{}) error {
+	payload, err := p.Query(function, args)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(payload, result)
+	if err != nil {
+		return fmt.Errorf("invalid %s result: %s", function, err)
+	}
+	return nil
+}
+
+func (p *Peer) Heartbeat(kioskId string, firmware string, health map[string]string) (string, error) {
+	healthAsBytes, err := json.Marshal(health)
+	if err != nil {
+		return "", err
+	}
+	return p.Invoke("heartbeat", []string{kioskId, firmware, string(healthAsBytes)})
+}
+
+// StaleKiosks lists the kiosks silent for longer than threshold, the chaincode default of one hour when zero.
+func (p *Peer) StaleKiosks(threshold time.Duration) ([]StaleKiosk, error) {
+	var args []string
+	if threshold > 0 {
+		args = []string{threshold.String()}
+	}
+
+	var kiosks []StaleKiosk
+	return kiosks, p.queryInto("stale_kiosks", args, &kiosks)
+}
+
+func (p *Peer) AcknowledgeAlert(alertId int64, note string) (string, error) {
+	return p.Invoke("acknowledge_alert", []string{strconv.FormatInt(alertId, 10), note})
+}
+
+func (p *Peer) ResolveAlert(alertId int64, note string) (string, error) {
+	return p.Invoke("resolve_alert", []string{strconv.FormatInt(alertId, 10), note})
+}
+
+// ViewAlerts lists the alerts with one of the statuses at one of the kiosks, empty slices match everything.
+func (p *Peer) ViewAlerts(statuses []string, kioskIds []string) ([]Alert, error) {
+	var alerts []Alert
+	return alerts, p.queryInto("view_alerts", []string{jsonArray(statuses), jsonArray(kioskIds)}, &alerts)
+}
+
+// SetRule creates or replaces a rule, parameters left zero take the chaincode defaults.
+func (p *Peer) SetRule(rule Rule) (string, error) {
+	ruleAsBytes, err := json.Marshal(rule)
+	if err != nil {
+		return "", err
+	}
+	return p.Invoke("set_rule", []string{string(ruleAsBytes)})
+}
+
+func (p *Peer) DeleteRule(ruleId string) (string, error) {
+	return p.Invoke("delete_rule", []string{ruleId})
+}
+
+func (p *Peer) ViewRules() ([]Rule, error) {
+	var rules []Rule
+	return rules, p.queryInto("view_rules", nil, &rules)
+}
+
+func (p *Peer) SetTariff(tariff Tariff) (string, error) {
+	return p.Invoke("set_tariff", []string{tariff.ActivityType, strconv.FormatInt(tariff.PerActivity, 10),
+		strconv.FormatInt(tariff.PerResource, 10), strconv.FormatInt(tariff.VendorFee, 10)})
+}
+
+func (p *Peer) ViewTariffs() ([]Tariff, error) {
+	var tariffs []Tariff
+	return tariffs, p.queryInto("view_tariffs", nil, &tariffs)
+}
+
+// UsageReport meters the activities from start up to, not including, end, as an admin. Times take any view_activities
+// time format, a date-only end includes that day.
+func (p *Peer) UsageReport(start string, end string) ([]Usage, error) {
+	var usages []Usage
+	return usages, p.queryInto("usage_report", []string{start, end}, &usages)
+}
+
+func (p *Peer) ClosePeriod(periodId string, start string, end string) (string, error) {
+	return p.Invoke("close_period", []string{periodId, start, end})
+}
+
+func (p *Peer) ViewPeriods() ([]Period, error) {
+	var periods []Period
+	return periods, p.queryInto("view_statements", nil, &periods)
+}
+
+// ViewStatements returns the statements of a closed period, only those of party when it is set.
+func (p *Peer) ViewStatements(periodId string, party string) ([]Statement, error) {
+	var statements []Statement
+	return statements, p.queryInto("view_statements", []string{periodId, party}, &statements)
+}
+
+// SetTokenAward sets the tokens credited to the actor of each activity of activityType, 0 stops the award.
+func (p *Peer) SetTokenAward(activityType string, amount int64) (string, error) {
+	return p.Invoke("set_token_award", []string{activityType, strconv.FormatInt(amount, 10)})
+}
+
+func (p *Peer) ViewTokenAwards() (TokenConfig, error) {
+	var config TokenConfig
+	return config, p.queryInto("view_token_awards", nil, &config)
+}
+
+// TransferTokens moves amount from one account to another, as an admin, the account holder or a kiosk the account earned
+// or redeemed tokens at. nonce must be the Nonce of the from account plus one, a transfer submitted twice is rejected
+// the second time.
+func (p *Peer) TransferTokens(from string, to string, amount int64, nonce int64) (string, error) {
+	return p.Invoke("transfer_tokens", []string{from, to, strconv.FormatInt(amount, 10), strconv.FormatInt(nonce, 10)})
+}
+
+// RedeemTokens records a redemption activity and debits its actor's account, as the activity's kiosk or an admin. The
+// activity type is set to TOKEN_REDEEM_ACTIVITY_TYPE.
+func (p *Peer) RedeemTokens(amount int64, nonce int64, activity NewActivity) (string, error) {
+	activity.ActivityType = TOKEN_REDEEM_ACTIVITY_TYPE
+	args := append([]string{strconv.FormatInt(amount, 10), strconv.FormatInt(nonce, 10)}, activity.Args()...)
+	return p.Invoke("redeem_tokens", args)
+}
+
+func (p *Peer) TokenBalance(accountId string) (TokenBalance, error) {
+	var balance TokenBalance
+	return balance, p.queryInto("token_balance", []string{accountId}, &balance)
+}
+
+func (p *Peer) TokenStatement(accountId string) (TokenAccount, error) {
+	var account TokenAccount
+	return account, p.queryInto("token_statement", []string{accountId}, &account)
+}
+
+// ReserveSlot holds a slot at the kiosk for the resource, as the kiosk, an admin or the holder. hold 0 uses the chaincode
+// default of 30 minutes.
+func (p *Peer) ReserveSlot(kioskId string, resourceId string, holder string, hold time.Duration) (string, error) {
+	duration := ""
+	if hold > 0 {
+		duration = hold.String()
+	}
+	return p.Invoke("reserve_slot", []string{kioskId, resourceId, holder, duration})
+}
+
+func (p *Peer) ReleaseReservation(reservationId int64) (string, error) {
+	return p.Invoke("release_reservation", []string{strconv.FormatInt(reservationId, 10)})
+}
+
+func (p *Peer) ExpireReservations() (string, error) {
+	return p.Invoke("expire_reservations", nil)
+}
+
+// ViewReservations returns the reservations with the holder blanked.
+func (p *Peer) ViewReservations(statuses []string, kioskIds []string) ([]Reservation, error) {
+	var reservations []Reservation
+	return reservations, p.queryInto("view_reservations", []string{jsonArray(statuses), jsonArray(kioskIds)}, &reservations)
+}
+
+func (p *Peer) SlotAvailability(kioskId string) (SlotAvailability, error) {
+	var availability SlotAvailability
+	return availability, p.queryInto("slot_availability", []string{kioskId}, &availability)
+}
+
+// TenantReport summarises each tenant, the default tenant first, only those in tenants when it is not empty. The
+// peer's secure context must be a super-admin.
+func (p *Peer) TenantReport(tenants []string) ([]TenantSummary, error) {
+	var summaries []TenantSummary
+	return summaries, p.queryInto("tenant_report", []string{jsonArray(tenants)}, &summaries)
+}
+
+// GrantConsent records the actor's consent to the use of the contact data in scope for the purpose.
+func (p *Peer) GrantConsent(actor Actor, purpose string, scope []string) (string, error) {
+	return p.Invoke("grant_consent", []string{actor.ActorType, actor.Name, actor.Telephone, actor.Email, purpose, jsonArray(scope)})
+}
+
+func (p *Peer) WithdrawConsent(consentId int64) (string, error) {
+	return p.Invoke("withdraw_consent", []string{strconv.FormatInt(consentId, 10)})
+}
+
+// ViewConsents returns the consents of the actor, every consent when actor is nil.
+func (p *Peer) ViewConsents(actor *Actor) ([]Consent, error) {
+	var args []string
+	if actor != nil {
+		args = []string{actor.ActorType, actor.Name, actor.Telephone, actor.Email}
+	}
+
+	var consents []Consent
+	return consents, p.queryInto("view_consents", args, &consents)
+}
+
+// SetConsentRequirement sets the purposes an actor must have consented to before an activity of activityType is
+// accepted, no purposes removes the requirement.
+func (p *Peer) SetConsentRequirement(activityType string, purposes []string) (string, error) {
+	return p.Invoke("set_consent_requirement", []string{activityType, jsonArray(purposes)})
+}
+
+func (p *Peer) ViewConsentRequirements() (ConsentRequirements, error) {
+	var requirements ConsentRequirements
+	return requirements, p.queryInto("view_consent_requirements", nil, &requirements)
+}
+
+// AuditedView submits a view_activities filter as an audited_view transaction, recording the reason in the access log.
+// A peer's REST interface only returns the transaction id of an invoke, pass it to AuditedResult once committed.
+func (p *Peer) AuditedView(reasonCode string, filter ActivityFilter) (string, error) {
+	return p.Invoke("audited_view", append([]string{reasonCode}, filter.Args()...))
+}
+
+// AuditedResult returns the activities, with contact data, of an audited_view transaction submitted by the same user.
+func (p *Peer) AuditedResult(txId string) ([]Activity, error) {
+	var activities []Activity
+	return activities, p.queryInto("audited_result", []string{txId}, &activities)
+}
+
+// ViewAccessLog returns the audited views that returned the actor's activities, every entry when actor is nil.
+func (p *Peer) ViewAccessLog(actor *Actor) ([]AccessLogEntry, error) {
+	var args []string
+	if actor != nil {
+		args = []string{actor.ActorType, actor.Name, actor.Telephone, actor.Email}
+	}
+
+	var entries []AccessLogEntry
+	return entries, p.queryInto("view_access_log", args, &entries)
+}
+
+// Usage returns the argument specs of every chaincode function, or only of function when it is not empty.
+func (p *Peer) Usage(function string) ([]FunctionSpec, error) {
+	var specs []FunctionSpec
+	return specs, p.queryInto("usage", []string{function}, &specs)
+}
+
+// SetLogLevel sets the chaincode log level of every tenant to DEBUG, INFO, NOTICE, WARNING, ERROR or CRITICAL. The
+// peer's secure context must be a super admin.
+func (p *Peer) SetLogLevel(level string) (string, error) {
+	return p.Invoke("set_log_level", []string{level})
+}
@@ -0,0 +1,496 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client talks to the HDB chaincode from off-chain programs. The types mirror the JSON written by the
+// chaincode and must be kept in step with it.
+package client
+
+// ============================================================================================================================
+// ACTIVITY
+// ============================================================================================================================
+type Activity struct {
+	ActivityId int64 `json:"activityId"`
+	Actor Actor `json:"actor"`
+	ActivityType string `json:"activityType"`
+	Kiosk Kiosk `json:"kiosk"`
+	Resources []Resource `json:"resources"`
+	Device Device `json:"device"`
+	Remark string `json:"remark"`
+	Timestamp int64 `json:"timestamp"`
+	TimestampFormatted string `json:"timestampFormatted,omitempty"`
+	PrevHash string `json:"prevHash,omitempty"`
+	KioskPrevHash string `json:"kioskPrevHash,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	Reservations []int64 `json:"reservations,omitempty"`
+}
+
+type Device struct {
+	DeviceType string `json:"deviceType"`
+	Id1 string `json:"id1"`
+	Id2 string `json:"id2"`
+	Id3 string `json:"id3"`
+	Id4 string `json:"id4"`
+}
+
+type Actor struct {
+	ActorType string `json:"actorType"`
+	Name string `json:"name"`
+	Telephone string `json:"telephone"`
+	Email string `json:"email"`
+}
+
+type Kiosk struct {
+	KioskId string `json:"kioskId"`
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+}
+
+type Resource struct {
+	ResourceOwner string `json:"resourceOwner"`
+	ResourceType string `json:"resourceType"`
+	ResourceId string `json:"resourceId"`
+	Details string `json:"details"`
+	Documents []DocumentHash `json:"documents,omitempty"`
+}
+
+// ============================================================================================================================
+// KIOSK REGISTRY
+// ============================================================================================================================
+type KioskRecord struct {
+	KioskId string `json:"kioskId"`
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Geohash string `json:"geohash"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen int64 `json:"lastSeen"`
+	Registered bool `json:"registered"`
+	RegisteredAt int64 `json:"registeredAt"`
+	History []KioskRevision `json:"history,omitempty"`
+	LastHeartbeat int64 `json:"lastHeartbeat,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+	Health map[string]string `json:"health,omitempty"`
+	KnownDevices []Device `json:"knownDevices,omitempty"`
+	SlotCapacity int `json:"slotCapacity,omitempty"`
+}
+
+type StaleKiosk struct {
+	KioskRecord
+	SilentFor int64 `json:"silentFor"`
+}
+
+type KioskRevision struct {
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Registered bool `json:"registered"`
+	ChangedAt int64 `json:"changedAt"`
+}
+
+// ============================================================================================================================
+// AGGREGATES AND EXPORTS
+// ============================================================================================================================
+type AggregateBucket struct {
+	Key map[string]string `json:"key"`
+	Count int64 `json:"count"`
+}
+
+type ExportOptions struct {
+	PerResource bool `json:"perResource"`
+	Offset int `json:"offset"`
+	Limit int `json:"limit"`
+}
+
+type ExportChunk struct {
+	Format string `json:"format"`
+	Offset int `json:"offset"`
+	NextOffset int `json:"nextOffset"`
+	Total int `json:"total"`
+	Rows int `json:"rows"`
+	Data string `json:"data"`
+}
+
+// ============================================================================================================================
+// CHAIN VERIFICATION
+// ============================================================================================================================
+type ChainReport struct {
+	Scope string `json:"scope"`
+	KioskId string `json:"kioskId,omitempty"`
+	Checked int `json:"checked"`
+	Unchained int `json:"unchained"`
+	Segments int `json:"segments"`
+	Valid bool `json:"valid"`
+	BrokenAt *int64 `json:"brokenAt,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Head string `json:"head"`
+}
+
+// ============================================================================================================================
+// DOCUMENT ANCHORS
+// ============================================================================================================================
+type DocumentHash struct {
+	Algorithm string `json:"algorithm"`
+	Digest string `json:"digest"`
+	Size int64 `json:"size"`
+	MediaType string `json:"mediaType"`
+}
+
+type DocumentReference struct {
+	ActivityId int64 `json:"activityId"`
+	ResourceId string `json:"resourceId"`
+	AnchoredAt int64 `json:"anchoredAt"`
+	AnchoredBy string `json:"anchoredBy"`
+	TxId string `json:"txId"`
+}
+
+type DocumentRecord struct {
+	DocumentHash
+	FirstAnchored int64 `json:"firstAnchored"`
+	References []DocumentReference `json:"references"`
+}
+
+type DocumentVerification struct {
+	Anchored bool `json:"anchored"`
+	Document *DocumentRecord `json:"document,omitempty"`
+}
+
+// ============================================================================================================================
+// ARCHIVE
+// ============================================================================================================================
+type ArchiveHeader struct {
+	Segment int `json:"segment"`
+	Count int `json:"count"`
+	FirstActivityId int64 `json:"firstActivityId"`
+	LastActivityId int64 `json:"lastActivityId"`
+	From int64 `json:"from"`
+	To int64 `json:"to"`
+	MerkleRoot string `json:"merkleRoot"`
+	SealedAt int64 `json:"sealedAt"`
+	PrevSealHash string `json:"prevSealHash"`
+	SealHash string `json:"sealHash"`
+}
+
+// ============================================================================================================================
+// POINT IN TIME STATE
+// ============================================================================================================================
+type ResourceState struct {
+	ResourceId string `json:"resourceId"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	ResourceOwner string `json:"resourceOwner,omitempty"`
+	ResourceType string `json:"resourceType,omitempty"`
+	Details string `json:"details,omitempty"`
+	Kiosk *Kiosk `json:"kiosk,omitempty"`
+	Actor *Actor `json:"actor,omitempty"`
+	Device *Device `json:"device,omitempty"`
+	LastActivityId int64 `json:"lastActivityId"`
+	LastActivityType string `json:"lastActivityType,omitempty"`
+	LastActivityAt int64 `json:"lastActivityAt"`
+	Activities int `json:"activities"`
+}
+
+type DeviceBinding struct {
+	Device Device `json:"device"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen int64 `json:"lastSeen"`
+	LastActivityId int64 `json:"lastActivityId"`
+}
+
+type KioskState struct {
+	KioskId string `json:"kioskId"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Registered bool `json:"registered"`
+	Source string `json:"source,omitempty"`
+	Devices []DeviceBinding `json:"devices"`
+}
+
+type DeviceState struct {
+	DeviceType string `json:"deviceType"`
+	Id1 string `json:"id1"`
+	AsOf int64 `json:"asOf"`
+	Found bool `json:"found"`
+	Device *Device `json:"device,omitempty"`
+	KioskId string `json:"kioskId,omitempty"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen int64 `json:"lastSeen"`
+	LastActivityId int64 `json:"lastActivityId"`
+}
+
+// ============================================================================================================================
+// ALERTS
+// ============================================================================================================================
+const ALERT_OPEN = "open"
+const ALERT_ACKNOWLEDGED = "acknowledged"
+const ALERT_RESOLVED = "resolved"
+
+type Alert struct {
+	AlertId int64 `json:"alertId"`
+	AlertType string `json:"alertType"`
+	Status string `json:"status"`
+	KioskId string `json:"kioskId"`
+	ActivityId int64 `json:"activityId"`
+	Message string `json:"message"`
+	Rule string `json:"rule,omitempty"`
+	Device *Device `json:"device,omitempty"`
+	KnownDevices []Device `json:"knownDevices,omitempty"`
+	RaisedAt int64 `json:"raisedAt"`
+	AcknowledgedBy string `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt int64 `json:"acknowledgedAt,omitempty"`
+	ResolvedBy string `json:"resolvedBy,omitempty"`
+	ResolvedAt int64 `json:"resolvedAt,omitempty"`
+	Notes []string `json:"notes,omitempty"`
+}
+
+// ============================================================================================================================
+// ANOMALY RULES
+// ============================================================================================================================
+const RULE_IMPOSSIBLE_TRAVEL = "impossible_travel"
+const RULE_COLLECT_BEFORE_DEPOSIT = "collect_before_deposit"
+
+const RULE_FLAG = "flag"
+const RULE_REJECT = "reject"
+
+type Rule struct {
+	RuleId string `json:"ruleId"`
+	RuleType string `json:"ruleType"`
+	Action string `json:"action"`
+	Disabled bool `json:"disabled"`
+	MaxSpeedKmh float64 `json:"maxSpeedKmh,omitempty"`
+	WindowMinutes int `json:"windowMinutes,omitempty"`
+	DepositType string `json:"depositType,omitempty"`
+	CollectType string `json:"collectType,omitempty"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+}
+
+// ============================================================================================================================
+// METERING AND SETTLEMENT - amounts are in minor currency units
+// ============================================================================================================================
+const PARTY_RESOURCE_OWNER = "resourceOwner"
+const PARTY_VENDOR = "vendor"
+
+type Tariff struct {
+	ActivityType string `json:"activityType"`
+	PerActivity int64 `json:"perActivity"`
+	PerResource int64 `json:"perResource"`
+	VendorFee int64 `json:"vendorFee"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+type LineItem struct {
+	ActivityId int64 `json:"activityId"`
+	ActivityType string `json:"activityType"`
+	Timestamp int64 `json:"timestamp"`
+	ResourceIds []string `json:"resourceIds,omitempty"`
+	Amount int64 `json:"amount"`
+}
+
+type Usage struct {
+	Party string `json:"party"`							// the resourceOwner, or vendor:<telephone> for a vendor
+	PartyType string `json:"partyType"`
+	Name string `json:"name,omitempty"`						// the vendor's name
+	Activities int `json:"activities"`
+	Total int64 `json:"total"`
+	LineItems []LineItem `json:"lineItems"`
+}
+
+type Statement struct {
+	StatementId string `json:"statementId"`
+	PeriodId string `json:"periodId"`
+	Usage
+	Tariffs map[string]Tariff `json:"tariffs"`
+	Hash string `json:"hash"`
+}
+
+type Period struct {
+	PeriodId string `json:"periodId"`
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	ClosedAt int64 `json:"closedAt"`
+	ClosedBy string `json:"closedBy"`
+	Statements []string `json:"statements"`
+}
+
+// ============================================================================================================================
+// REWARD TOKENS
+// ============================================================================================================================
+const TOKEN_REDEEM_ACTIVITY_TYPE = "redeem"
+
+const ENTRY_AWARD = "award"
+const ENTRY_TRANSFER_IN = "transfer_in"
+const ENTRY_TRANSFER_OUT = "transfer_out"
+const ENTRY_REDEEM = "redeem"
+
+type TokenConfig struct {
+	Awards map[string]int64 `json:"awards"`
+}
+
+type TokenEntry struct {
+	Kind string `json:"kind"`
+	Amount int64 `json:"amount"`
+	Balance int64 `json:"balance"`
+	ActivityId *int64 `json:"activityId,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`			// the kiosk of an award or redemption, blank for a transfer
+	Caller string `json:"caller,omitempty"`
+	TxId string `json:"txId"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type TokenAccount struct {
+	AccountId string `json:"accountId"`
+	Balance int64 `json:"balance"`
+	Nonce int64 `json:"nonce"`
+	Entries []TokenEntry `json:"entries"`
+}
+
+type TokenBalance struct {
+	AccountId string `json:"accountId"`
+	Balance int64 `json:"balance"`
+	Nonce int64 `json:"nonce"`
+}
+
+// TokenAccountId returns the token account the chaincode credits for an actor's activities.
+func TokenAccountId(actor Actor) string {
+	for _, id := range []string{actor.Telephone, actor.Email, actor.Name} {
+		if id != "" {
+			return actor.ActorType + ":" + id
+		}
+	}
+	return actor.ActorType + ":"
+}
+
+// ============================================================================================================================
+// SLOT RESERVATIONS
+// ============================================================================================================================
+const RESERVATION_HELD = "held"
+const RESERVATION_OCCUPIED = "occupied"
+const RESERVATION_COMPLETED = "completed"
+const RESERVATION_RELEASED = "released"
+const RESERVATION_EXPIRED = "expired"
+
+type Reservation struct {
+	ReservationId int64 `json:"reservationId"`
+	KioskId string `json:"kioskId"`
+	ResourceId string `json:"resourceId"`
+	Holder string `json:"holder"`
+	Status string `json:"status"`
+	ReservedBy string `json:"reservedBy"`
+	ReservedAt int64 `json:"reservedAt"`
+	ExpiresAt int64 `json:"expiresAt"`
+	DepositActivityId *int64 `json:"depositActivityId,omitempty"`
+	CollectActivityId *int64 `json:"collectActivityId,omitempty"`
+	ClosedAt int64 `json:"closedAt,omitempty"`
+}
+
+type SlotAvailability struct {
+	KioskId string `json:"kioskId"`
+	SlotCapacity int `json:"slotCapacity"`
+	Held int `json:"held"`
+	Occupied int `json:"occupied"`
+	Free int `json:"free"`
+}
+
+// ============================================================================================================================
+// TENANTS - the tenant of a caller is the "tenant" attribute of its certificate
+// ============================================================================================================================
+const DEFAULT_TENANT = ""
+
+type TenantSummary struct {
+	Tenant string `json:"tenant"`
+	ActivityCount int64 `json:"activityCount"`
+	Kiosks int `json:"kiosks"`
+	RegisteredKiosks int `json:"registeredKiosks"`
+	OpenAlerts int `json:"openAlerts"`
+	ActiveReservations int `json:"activeReservations"`
+}
+
+// ============================================================================================================================
+// CONSENTS
+// ============================================================================================================================
+const SCOPE_TELEPHONE = "telephone"
+const SCOPE_EMAIL = "email"
+
+type Consent struct {
+	ConsentId int64 `json:"consentId"`
+	Actor Actor `json:"actor"`
+	Purpose string `json:"purpose"`
+	Scope []string `json:"scope"`
+	GrantedAt int64 `json:"grantedAt"`
+	GrantedBy string `json:"grantedBy"`
+	WithdrawnAt int64 `json:"withdrawnAt,omitempty"`
+	WithdrawnBy string `json:"withdrawnBy,omitempty"`
+}
+
+type ConsentRequirements struct {
+	ActivityTypes map[string][]string `json:"activityTypes"`
+}
+
+// ============================================================================================================================
+// PII ACCESS LOG
+// ============================================================================================================================
+const REASON_SERVICE_REQUEST = "service_request"
+const REASON_INVESTIGATION = "investigation"
+const REASON_LEGAL = "legal"
+const REASON_AUDIT = "audit"
+
+type AccessLogEntry struct {
+	TxId string `json:"txId"`
+	Caller string `json:"caller"`
+	CallerAffiliation string `json:"callerAffiliation"`
+	ReasonCode string `json:"reasonCode"`
+	Filter []string `json:"filter"`
+	ResultCount int `json:"resultCount"`
+	ActivityIds []int64 `json:"activityIds"`				// the activities AuditedResult returns
+	Actors []string `json:"actors"`						// SHA-256 of each actor's key
+	Timestamp int64 `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// FUNCTION SPECS
+// ============================================================================================================================
+const CALL_INIT = "init"
+const CALL_INVOKE = "invoke"
+const CALL_QUERY = "query"
+
+const ARG_STRING = "string"
+const ARG_INT = "int"
+const ARG_NUMBER = "number"
+const ARG_BOOL = "bool"
+const ARG_JSON_ARRAY = "jsonArray"
+const ARG_JSON_OBJECT = "jsonObject"
+
+// ArgSpec is one positional argument. Optional arguments may be left out, they and Empty arguments may be "".
+type ArgSpec struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Optional bool `json:"optional,omitempty"`
+	Empty bool `json:"empty,omitempty"`
+}
+
+// FunctionSpec describes the arguments the chaincode expects: Args, then Repeat any number of times.
+type FunctionSpec struct {
+	Function string `json:"function"`
+	Call string `json:"call"`
+	Summary string `json:"summary"`
+	Args []ArgSpec `json:"args"`
+	Repeat []ArgSpec `json:"repeat,omitempty"`
+	Usage string `json:"usage"`
+}
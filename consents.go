@@ -0,0 +1,354 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var consentsStr = "_consents"
+var consentRequirementsStr = "_consentRequirements"
+
+// ============================================================================================================================
+// CONSENT SCOPE - the contact data of the actor a consent covers
+// ============================================================================================================================
+const SCOPE_TELEPHONE = "telephone"
+const SCOPE_EMAIL = "email"
+
+const EVENT_CONSENT_GRANTED = "consent.granted"
+const EVENT_CONSENT_WITHDRAWN = "consent.withdrawn"
+
+//==============================================================================================================================
+//	Consent - An actor's consent to the use of their contact data for a purpose. A withdrawn consent keeps its record,
+//			  granting again creates a new one.
+//==============================================================================================================================
+type Consent struct {
+	ConsentId int64 `json:"consentId"`
+	Actor Actor `json:"actor"`
+	Purpose string `json:"purpose"`
+	Scope []string `json:"scope"`
+	GrantedAt int64 `json:"grantedAt"`
+	GrantedBy string `json:"grantedBy"`
+	WithdrawnAt int64 `json:"withdrawnAt,omitempty"`
+	WithdrawnBy string `json:"withdrawnBy,omitempty"`
+}
+
+//==============================================================================================================================
+//	AllConsents - Consents in the order they were granted, ConsentId is the index.
+//==============================================================================================================================
+type AllConsents struct {
+	Consents []Consent `json:"consents"`
+}
+
+//==============================================================================================================================
+//	ConsentRequirements - The purposes an actor must have consented to before create_activity accepts an activity type.
+//==============================================================================================================================
+type ConsentRequirements struct {
+	ActivityTypes map[string][]string `json:"activityTypes"`
+}
+
+type ConsentEvent struct {
+	ConsentId int64 `json:"consentId"`
+	Purpose string `json:"purpose"`
+	ActorType string `json:"actorType"`
+}
+
+//=================================================================================================================================
+//	 grant_consent - Admin or kiosk only. args: actorType, name, telephone, email, purpose, JSON array of scopes
+//					 (telephone, email).
+//=================================================================================================================================
+func (t *SimpleChaincode) grant_consent(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN && caller_affiliation != KIOSK {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 6 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 6. actorType, name, telephone, email, purpose and scope")
+	}
+
+	actor := Actor{ActorType: args[0], Name: args[1], Telephone: args[2], Email: args[3]}
+	purpose := args[4]
+	if purpose == "" {
+		return nil, invalidArgument(4, "purpose", "Purpose must not be empty")
+	}
+
+	var scope []string
+	err := json.Unmarshal([]byte(args[5]), &scope)
+	if err != nil || len(scope) == 0 { log_warning(stub, "Invalid scope", "scope", args[5]); return nil, invalidArgument(5, "scope", "Invalid scope. Expecting a JSON array of telephone and email") }
+
+	for _, s := range scope {
+		if s != SCOPE_TELEPHONE && s != SCOPE_EMAIL {
+			return nil, invalidArgument(5, "scope", "Invalid scope " + s + ". Expecting telephone or email")
+		}
+	}
+
+	consents, err := get_consents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	if existing := consents.active(actor, purpose); existing != nil {
+		return nil, conflict("Consent for purpose " + purpose + " is already granted as consent " + strconv.FormatInt(existing.ConsentId, 10))
+	}
+
+	consent := Consent{ConsentId: int64(len(consents.Consents)), Actor: actor, Purpose: purpose, Scope: scope,
+		GrantedAt: makeTimestamp(stub), GrantedBy: caller}
+	consents.Consents = append(consents.Consents, consent)
+
+	err = put_consents(stub, consents)
+	if err != nil { log_failure(stub, "Failed to save consents", err); return nil, internalError("Failed to save consents", err) }
+
+	err = set_event(stub, EVENT_CONSENT_GRANTED, consent.event())
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(consent)
+}
+
+//=================================================================================================================================
+//	 withdraw_consent - Admin or kiosk only. args[0] is the consentId.
+//=================================================================================================================================
+func (t *SimpleChaincode) withdraw_consent(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN && caller_affiliation != KIOSK {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. consentId")
+	}
+
+	consentId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil { log_warning(stub, "Invalid consentId", "error", err); return nil, invalidArgument(0, "consentId", "Invalid consentId") }
+
+	consents, err := get_consents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	if consentId < 0 || consentId >= int64(len(consents.Consents)) {
+		return nil, notFound("Consent " + args[0] + " not found")
+	}
+
+	consent := &consents.Consents[consentId]
+	if consent.WithdrawnAt != 0 {
+		return nil, conflict("Consent " + args[0] + " is already withdrawn")
+	}
+
+	consent.WithdrawnAt = makeTimestamp(stub)
+	consent.WithdrawnBy = caller
+
+	err = put_consents(stub, consents)
+	if err != nil { log_failure(stub, "Failed to save consents", err); return nil, internalError("Failed to save consents", err) }
+
+	err = set_event(stub, EVENT_CONSENT_WITHDRAWN, consent.event())
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(consent)
+}
+
+//=================================================================================================================================
+//	 set_consent_requirement - Admin only. args: activityType, JSON array of purposes. An empty array removes the
+//							   requirement.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_consent_requirement(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. activityType and purposes")
+	}
+
+	if args[0] == "" {
+		return nil, invalidArgument(0, "activityType", "Activity type must not be empty")
+	}
+
+	var purposes []string
+	err := json.Unmarshal([]byte(args[1]), &purposes)
+	if err != nil { log_warning(stub, "Invalid purposes", "error", err); return nil, invalidArgument(1, "purposes", "Invalid purposes. Expecting a JSON array") }
+
+	requirements, err := get_consent_requirements(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve consent requirements", err); return nil, internalError("Failed to retrieve consent requirements", err) }
+
+	if len(purposes) == 0 {
+		delete(requirements.ActivityTypes, args[0])
+	} else {
+		requirements.ActivityTypes[args[0]] = purposes
+	}
+
+	requirementsAsBytes, err := json.Marshal(requirements)
+	if err != nil { log_failure(stub, "Failed to convert consent requirements", err); return nil, internalError("Failed to convert consent requirements", err) }
+
+	err = stub.PutState(consentRequirementsStr, requirementsAsBytes)
+	if err != nil { log_failure(stub, "Failed to save consent requirements", err); return nil, internalError("Failed to save consent requirements", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: consentRequirementsStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return requirementsAsBytes, nil
+}
+
+//=================================================================================================================================
+//	 view_consents - args: actorType, name, telephone, email for the consents of one actor, none for every consent.
+//					 The actors' telephone and email are blanked.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_consents(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 && len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting none, or actorType, name, telephone and email")
+	}
+
+	consents, err := get_consents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	result := []Consent{}
+	for _, consent := range consents.Consents {
+		if len(args) == 4 && consent.Actor != (Actor{ActorType: args[0], Name: args[1], Telephone: args[2], Email: args[3]}) {
+			continue
+		}
+		consent.Actor = redactContact(consent.Actor)
+		result = append(result, consent)
+	}
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 view_consent_requirements - Returns the purposes required per activity type.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_consent_requirements(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	requirements, err := get_consent_requirements(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve consent requirements", err); return nil, internalError("Failed to retrieve consent requirements", err) }
+
+	return json.Marshal(requirements)
+}
+
+//=================================================================================================================================
+//	 check_consent - Fails when the actor of a new activity has not consented to every purpose its type requires, with
+//					 a scope covering the telephone and email the activity records.
+//=================================================================================================================================
+func check_consent(stub shim.ChaincodeStubInterface, activity Activity) error {
+	requirements, err := get_consent_requirements(stub)
+	if err != nil { return err }
+
+	purposes := requirements.ActivityTypes[activity.ActivityType]
+	if len(purposes) == 0 {
+		return nil
+	}
+
+	consents, err := get_consents(stub)
+	if err != nil { return err }
+
+	for _, purpose := range purposes {
+		consent := consents.active(activity.Actor, purpose)
+		if consent == nil {
+			return permissionDenied("Missing consent for purpose " + purpose + " required by activity type " + activity.ActivityType)
+		}
+		if field := consent.uncovered(); field != "" {
+			return permissionDenied("Consent " + strconv.FormatInt(consent.ConsentId, 10) + " for purpose " + purpose + " does not cover the actor's " + field)
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 load_consent_filter - For a filter with a purpose, loads the actors with an active consent to it that covers their
+//						   telephone and email, as check_consent does. matches leaves out the activities of every other
+//						   actor, including those who never consented.
+//=================================================================================================================================
+func load_consent_filter(stub shim.ChaincodeStubInterface, filter *ActivityFilter) error {
+	if filter.Purpose == "" {
+		return nil
+	}
+
+	consents, err := get_consents(stub)
+	if err != nil { return err }
+
+	filter.Consented = make(map[string]bool)
+	for _, consent := range consents.Consents {
+		if consent.Purpose != filter.Purpose {
+			continue
+		}
+		active := consents.active(consent.Actor, filter.Purpose)
+		filter.Consented[actorKey(consent.Actor)] = active != nil && active.uncovered() == ""
+	}
+
+	return nil
+}
+
+// active returns the consent of the actor to the purpose that is not withdrawn, nil when there is none.
+func (consents *AllConsents) active(actor Actor, purpose string) *Consent {
+	for i := range consents.Consents {
+		consent := &consents.Consents[i]
+		if consent.Actor == actor && consent.Purpose == purpose && consent.WithdrawnAt == 0 {
+			return consent
+		}
+	}
+	return nil
+}
+
+// uncovered returns the first contact field of the consenting actor outside the consent scope, "" when all are covered.
+func (consent Consent) uncovered() string {
+	if consent.Actor.Telephone != "" && !containsString(consent.Scope, SCOPE_TELEPHONE) {
+		return SCOPE_TELEPHONE
+	}
+	if consent.Actor.Email != "" && !containsString(consent.Scope, SCOPE_EMAIL) {
+		return SCOPE_EMAIL
+	}
+	return ""
+}
+
+func (consent Consent) event() ConsentEvent {
+	return ConsentEvent{ConsentId: consent.ConsentId, Purpose: consent.Purpose, ActorType: consent.Actor.ActorType}
+}
+
+func get_consents(stub shim.ChaincodeStubInterface) (AllConsents, error) {
+	var consents AllConsents
+
+	consentsAsBytes, err := stub.GetState(consentsStr)
+	if err != nil { return consents, err }
+
+	if len(consentsAsBytes) > 0 {
+		err = json.Unmarshal(consentsAsBytes, &consents)
+		if err != nil { return consents, corruptState(consentsStr, "Corrupt consents record") }
+	}
+
+	return consents, nil
+}
+
+func put_consents(stub shim.ChaincodeStubInterface, consents AllConsents) error {
+	consentsAsBytes, err := json.Marshal(consents)
+	if err != nil { return err }
+
+	return stub.PutState(consentsStr, consentsAsBytes)
+}
+
+func get_consent_requirements(stub shim.ChaincodeStubInterface) (ConsentRequirements, error) {
+	var requirements ConsentRequirements
+
+	requirementsAsBytes, err := stub.GetState(consentRequirementsStr)
+	if err != nil { return requirements, err }
+
+	if len(requirementsAsBytes) > 0 {
+		err = json.Unmarshal(requirementsAsBytes, &requirements)
+		if err != nil { return requirements, corruptState(consentRequirementsStr, "Corrupt consent requirements record") }
+	}
+
+	if requirements.ActivityTypes == nil {
+		requirements.ActivityTypes = make(map[string][]string)
+	}
+
+	return requirements, nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var documentsStr = "_documents"
+
+const EVENT_DOCUMENT_ANCHORED = "document.anchored"
+
+// Supported digest algorithms and the length of their digests in bytes.
+var documentAlgorithms = map[string]int{
+	"sha256": 32,
+	"sha512": 64,
+}
+
+//==============================================================================================================================
+//	DocumentHash - Content hash of a document kept off-chain, e.g. a scanned form or photo attached to a resource.
+//				   Digest is lower case hex.
+//==============================================================================================================================
+type DocumentHash struct {
+	Algorithm string `json:"algorithm"`
+	Digest string `json:"digest"`
+	Size int64 `json:"size"`
+	MediaType string `json:"mediaType"`
+}
+
+type DocumentReference struct {
+	ActivityId int64 `json:"activityId"`
+	ResourceId string `json:"resourceId"`
+	AnchoredAt int64 `json:"anchoredAt"`
+	AnchoredBy string `json:"anchoredBy"`
+	TxId string `json:"txId"`
+}
+
+//==============================================================================================================================
+//	DocumentRecord - An anchored document and every activity resource referencing it, in anchoring order.
+//==============================================================================================================================
+type DocumentRecord struct {
+	DocumentHash
+	FirstAnchored int64 `json:"firstAnchored"`
+	References []DocumentReference `json:"references"`
+}
+
+//==============================================================================================================================
+//	AllDocuments - Anchored documents keyed by "<algorithm>:<digest>".
+//==============================================================================================================================
+type AllDocuments struct {
+	Documents map[string]DocumentRecord `json:"documents"`
+}
+
+type DocumentVerification struct {
+	Anchored bool `json:"anchored"`
+	Document *DocumentRecord `json:"document,omitempty"`
+}
+
+type DocumentAnchoredEvent struct {
+	Algorithm string `json:"algorithm"`
+	Digest string `json:"digest"`
+	ActivityId int64 `json:"activityId"`
+	ResourceId string `json:"resourceId"`
+}
+
+//=================================================================================================================================
+//	 anchor_document - Anchors the hash of an off-chain document to a resource of an existing activity.
+//					   args: activityId, resourceId, algorithm, digest, size, mediaType
+//=================================================================================================================================
+func (t *SimpleChaincode) anchor_document(stub shim.ChaincodeStubInterface, caller string, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 6. activityId, resourceId, algorithm, digest, size, mediaType")
+	}
+
+	activityId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil { log_warning(stub, "Invalid activityId", "error", err); return nil, invalidArgument(0, "activityId", "Invalid activityId") }
+
+	resourceId := args[1]
+
+	document, err := parseDocumentHash(args, 2)
+	if err != nil { log_failure(stub, "Invalid document hash", err); return nil, err }
+
+	document.Size, err = strconv.ParseInt(args[4], 10, 64)
+	if err != nil || document.Size < 0 { log_warning(stub, "Invalid size", "size", args[4]); return nil, invalidArgument(4, "size", "Invalid size") }
+
+	document.MediaType = args[5]
+
+	activity, found, err := find_activity(stub, activityId)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+	if !found { return nil, notFound("Activity " + args[0] + " not found") }
+
+	if !hasResource(activity, resourceId) {
+		return nil, notFound("Activity " + args[0] + " has no resource " + resourceId)
+	}
+
+	documents, err := get_documents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve documents", err); return nil, internalError("Failed to retrieve documents", err) }
+
+	timestamp := makeTimestamp(stub)
+	key := documentKey(document.Algorithm, document.Digest)
+
+	record, exists := documents.Documents[key]
+	if !exists {
+		record = DocumentRecord{DocumentHash: document, FirstAnchored: timestamp, References: []DocumentReference{}}
+	} else if record.Size != document.Size {
+		return nil, conflict("Document size does not match the anchored size " + strconv.FormatInt(record.Size, 10))
+	}
+
+	for _, reference := range record.References {
+		if reference.ActivityId == activityId && reference.ResourceId == resourceId {
+			return nil, conflict("Document already anchored to this resource")
+		}
+	}
+
+	txId := stub.GetTxID()
+	record.References = append(record.References, DocumentReference{ActivityId: activityId, ResourceId: resourceId,
+		AnchoredAt: timestamp, AnchoredBy: caller, TxId: txId})
+	documents.Documents[key] = record
+
+	err = put_documents(stub, documents)
+	if err != nil { log_failure(stub, "Failed to save documents", err); return nil, internalError("Failed to save documents", err) }
+
+	err = set_event(stub, EVENT_DOCUMENT_ANCHORED, DocumentAnchoredEvent{Algorithm: document.Algorithm, Digest: document.Digest,
+		ActivityId: activityId, ResourceId: resourceId})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(record)
+}
+
+//=================================================================================================================================
+//	 verify_document - args: algorithm, digest. Returns whether the document is anchored, and if so when it was first
+//					   anchored and which activity resources reference it.
+//=================================================================================================================================
+func (t *SimpleChaincode) verify_document(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. algorithm, digest")
+	}
+
+	document, err := parseDocumentHash(args, 0)
+	if err != nil { log_failure(stub, "Invalid document hash", err); return nil, err }
+
+	documents, err := get_documents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve documents", err); return nil, internalError("Failed to retrieve documents", err) }
+
+	var verification DocumentVerification
+	if record, ok := documents.Documents[documentKey(document.Algorithm, document.Digest)]; ok {
+		verification.Anchored = true
+		verification.Document = &record
+	}
+
+	return json.Marshal(verification)
+}
+
+func get_documents(stub shim.ChaincodeStubInterface) (AllDocuments, error) {
+	var documents AllDocuments
+
+	documentsAsBytes, err := stub.GetState(documentsStr)
+	if err != nil { return documents, err }
+
+	if len(documentsAsBytes) > 0 {
+		err = json.Unmarshal(documentsAsBytes, &documents)
+		if err != nil { return documents, corruptState(documentsStr, "Corrupt documents record") }
+	}
+
+	if documents.Documents == nil {
+		documents.Documents = make(map[string]DocumentRecord)
+	}
+
+	return documents, nil
+}
+
+func put_documents(stub shim.ChaincodeStubInterface, documents AllDocuments) error {
+	documentsAsBytes, err := json.Marshal(documents)
+	if err != nil { return err }
+
+	return stub.PutState(documentsStr, documentsAsBytes)
+}
+
+// attachDocuments sets Documents on each resource of the activities from the anchored documents registry. Documents
+// are never stored in the activity itself, so anchoring does not change the activity hash.
+func attachDocuments(activities []Activity, documents AllDocuments) {
+	if len(documents.Documents) == 0 {
+		return
+	}
+
+	index := make(map[string][]DocumentHash)
+	for _, record := range documents.Documents {
+		for _, reference := range record.References {
+			key := strconv.FormatInt(reference.ActivityId, 10) + "/" + reference.ResourceId
+			index[key] = append(index[key], record.DocumentHash)
+		}
+	}
+
+	for i := range activities {
+		resources := make([]Resource, len(activities[i].Resources))
+		for j, resource := range activities[i].Resources {
+			resource.Documents = index[strconv.FormatInt(activities[i].ActivityId, 10) + "/" + resource.ResourceId]
+			resources[j] = resource
+		}
+		activities[i].Resources = resources
+	}
+}
+
+//=================================================================================================================================
+//	 find_activity - Looks up an activity by id, including archived activities.
+//=================================================================================================================================
+func find_activity(stub shim.ChaincodeStubInterface, activityId int64) (Activity, bool, error) {
+	activities, err := load_activities(stub, true)
+	if err != nil { return Activity{}, false, err }
+
+	for _, activity := range activities {
+		if activity.ActivityId == activityId {
+			return activity, true, nil
+		}
+	}
+
+	return Activity{}, false, nil
+}
+
+func hasResource(activity Activity, resourceId string) bool {
+	for _, resource := range activity.Resources {
+		if resource.ResourceId == resourceId {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDocumentHash reads the algorithm from args[first] and the digest from args[first+1].
+func parseDocumentHash(args []string, first int) (DocumentHash, error) {
+	algorithm := strings.ToLower(args[first])
+	digest := strings.ToLower(args[first+1])
+
+	length, ok := documentAlgorithms[algorithm]
+	if !ok {
+		return DocumentHash{}, invalidArgument(first, "algorithm", "Unsupported digest algorithm " + algorithm + ". Expecting sha256 or sha512")
+	}
+
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != length {
+		return DocumentHash{}, invalidArgument(first+1, "digest", "Invalid " + algorithm + " digest. Expecting " + strconv.Itoa(length*2) + " hex characters")
+	}
+
+	return DocumentHash{Algorithm: algorithm, Digest: digest}, nil
+}
+
+func documentKey(algorithm string, digest string) string {
+	return algorithm + ":" + digest
+}
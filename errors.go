@@ -0,0 +1,86 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// ============================================================================================================================
+// ERROR CODES - stable across releases, clients branch on the code and show the message
+// ============================================================================================================================
+const ERR_INVALID_ARGUMENT = "INVALID_ARGUMENT"			// an argument is malformed or out of range, see Arg and Field
+const ERR_NOT_FOUND = "NOT_FOUND"						// the activity, kiosk, alert, ... named by an argument does not exist
+const ERR_PERMISSION_DENIED = "PERMISSION_DENIED"		// the caller's role or consent does not allow the call
+const ERR_CONFLICT = "CONFLICT"							// the call is valid but the current state rejects it
+const ERR_CORRUPT_STATE = "CORRUPT_STATE"				// a stored record failed to unmarshal, Field is its key
+const ERR_INTERNAL = "INTERNAL"							// the peer failed to read or write state
+
+//==============================================================================================================================
+//	ChaincodeError - Returned by every function. Error() is the JSON the peer hands to the client. Arg is the index of
+//					 the offending argument, absent when the error is not about one argument.
+//==============================================================================================================================
+type ChaincodeError struct {
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Arg *int `json:"arg,omitempty"`
+	Field string `json:"field,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	errorAsBytes, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(errorAsBytes)
+}
+
+// invalidArgument reports args[arg], or a field inside it. arg is -1 when the error is about the arguments as a whole,
+// such as their number.
+func invalidArgument(arg int, field string, message string) error {
+	e := &ChaincodeError{Code: ERR_INVALID_ARGUMENT, Message: message, Field: field}
+	if arg >= 0 {
+		e.Arg = &arg
+	}
+	return e
+}
+
+func notFound(message string) error {
+	return &ChaincodeError{Code: ERR_NOT_FOUND, Message: message}
+}
+
+func permissionDenied(message string) error {
+	return &ChaincodeError{Code: ERR_PERMISSION_DENIED, Message: message}
+}
+
+func conflict(message string) error {
+	return &ChaincodeError{Code: ERR_CONFLICT, Message: message}
+}
+
+// corruptState reports the record stored under key.
+func corruptState(key string, message string) error {
+	return &ChaincodeError{Code: ERR_CORRUPT_STATE, Message: message, Field: key}
+}
+
+// internalError keeps the code of a ChaincodeError returned by a helper, so a corrupt record is reported as such
+// rather than as a failure to read it. Other errors become INTERNAL with the message.
+func internalError(message string, cause error) error {
+	if e, ok := cause.(*ChaincodeError); ok {
+		return e
+	}
+	return &ChaincodeError{Code: ERR_INTERNAL, Message: message}
+}
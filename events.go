@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var eventConfigStr = "_eventConfig"
+
+// ============================================================================================================================
+// EVENT NAMES - a chaincode transaction carries a single event, so each mutating function sets exactly one
+// ============================================================================================================================
+const EVENT_ACTIVITY_CREATED = "activity.created"			// suffixed with ".<activityType>"
+const EVENT_STATE_WRITTEN = "state.written"
+const EVENT_COUNTERS_REBUILT = "counters.rebuilt"
+const EVENT_CONFIG_UPDATED = "config.updated"
+
+//==============================================================================================================================
+//	EventConfig - IncludePII adds the actor name, telephone and email to activity events. Off unless an admin enables it.
+//==============================================================================================================================
+type EventConfig struct {
+	IncludePII bool `json:"includePII"`
+}
+
+type ActivityEvent struct {
+	ActivityId int64 `json:"activityId"`
+	ActivityType string `json:"activityType"`
+	KioskId string `json:"kioskId"`
+	ActorType string `json:"actorType"`
+	ResourceIds []string `json:"resourceIds"`
+	Timestamp int64 `json:"timestamp"`
+	Actor *Actor `json:"actor,omitempty"`
+	Alerts []AlertRaised `json:"alerts,omitempty"`			// alerts raised by the activity, see alerts.go
+}
+
+type StateWrittenEvent struct {
+	Key string `json:"key"`
+}
+
+type CountersRebuiltEvent struct {
+	ActivityCount int64 `json:"activityCount"`
+}
+
+type ConfigUpdatedEvent struct {
+	Config string `json:"config"`
+}
+
+//=================================================================================================================================
+//	 set_event_config - Admin only. args[0] is "true" to include PII in activity events, "false" to exclude it.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_event_config(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. true to include PII in events, false otherwise")
+	}
+
+	includePII, err := strconv.ParseBool(args[0])
+	if err != nil { log_warning(stub, "Invalid includePII value", "error", err); return nil, invalidArgument(0, "includePII", "Invalid includePII value") }
+
+	configAsBytes, err := json.Marshal(EventConfig{IncludePII: includePII})
+	if err != nil { log_failure(stub, "Failed to convert event config", err); return nil, internalError("Failed to convert event config", err) }
+
+	err = stub.PutState(eventConfigStr, configAsBytes)
+	if err != nil { log_failure(stub, "Failed to save event config", err); return nil, internalError("Failed to save event config", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: eventConfigStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return nil, nil
+}
+
+func get_event_config(stub shim.ChaincodeStubInterface) (EventConfig, error) {
+	var config EventConfig
+
+	configAsBytes, err := stub.GetState(eventConfigStr)
+	if err != nil { return config, err }
+
+	if len(configAsBytes) > 0 {
+		err = json.Unmarshal(configAsBytes, &config)
+		if err != nil { return config, corruptState(eventConfigStr, "Corrupt event config record") }
+	}
+
+	return config, nil
+}
+
+//=================================================================================================================================
+//	 set_activity_created_event - Sets the activity.created.<activityType> event, leaving out PII unless configured.
+//								  Alerts raised by the activity ride along in the same event.
+//=================================================================================================================================
+func set_activity_created_event(stub shim.ChaincodeStubInterface, activity Activity, alerts []AlertRaised) error {
+	config, err := get_event_config(stub)
+	if err != nil { return err }
+
+	event := ActivityEvent{ActivityId: activity.ActivityId, ActivityType: activity.ActivityType, KioskId: activity.Kiosk.KioskId,
+		ActorType: activity.Actor.ActorType, ResourceIds: []string{}, Timestamp: activity.Timestamp, Alerts: alerts}
+
+	for _, resource := range activity.Resources {
+		event.ResourceIds = append(event.ResourceIds, resource.ResourceId)
+	}
+
+	if config.IncludePII {
+		actor := activity.Actor
+		event.Actor = &actor
+	}
+
+	return set_event(stub, EVENT_ACTIVITY_CREATED+"."+activity.ActivityType, event)
+}
+
+func set_event(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+	payloadAsBytes, err := json.Marshal(payload)
+	if err != nil { return err }
+
+	log_debug(stub, "Setting event", "event", name)
+	return stub.SetEvent(name, payloadAsBytes)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// EXPORT FORMAT
+// ============================================================================================================================
+const EXPORT_CSV = "csv"
+const EXPORT_NDJSON = "ndjson"
+
+const defaultExportLimit = 500
+const maxExportLimit = 5000
+
+// Stable CSV column layout, new columns are only ever appended.
+var exportColumns = []string{
+	"activityId", "timestamp", "timestampFormatted", "activityType",
+	"actorType", "actorName", "actorTelephone", "actorEmail",
+	"kioskId", "kioskLatitude", "kioskLongitude", "kioskDetails",
+	"deviceType", "deviceId1", "deviceId2", "deviceId3", "deviceId4",
+	"remark", "resourceOwner", "resourceType", "resourceId", "resourceDetails",
+	"resourceDocuments",
+}
+
+//==============================================================================================================================
+//	ExportOptions - PerResource writes one row per activity-resource pair instead of one row per activity with each
+//					resource column holding a JSON array. Offset and Limit page through the matching activities.
+//==============================================================================================================================
+type ExportOptions struct {
+	PerResource bool `json:"perResource"`
+	Offset int `json:"offset"`
+	Limit int `json:"limit"`
+}
+
+type ExportChunk struct {
+	Format string `json:"format"`
+	Offset int `json:"offset"`
+	NextOffset int `json:"nextOffset"`						// offset of the next chunk, -1 once the export is complete
+	Total int `json:"total"`								// number of matching activities
+	Rows int `json:"rows"`
+	Data string `json:"data"`
+}
+
+//=================================================================================================================================
+//	 export_activities - args[0] is the format (csv or ndjson), args[1] the ExportOptions JSON, args[2:] the view_activities
+//						 filter arguments. The CSV header is only written in the chunk at offset 0. The actors'
+//						 telephone and email are blanked as in view_activities.
+//=================================================================================================================================
+func (t *SimpleChaincode) export_activities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	format := args[0]
+	if format != EXPORT_CSV && format != EXPORT_NDJSON {
+		log_warning(stub, "Unknown format", "format", format); return nil, invalidArgument(0, "format", "Unknown export format " + format)
+	}
+
+	var options ExportOptions
+	if args[1] != "" {
+		err := json.Unmarshal([]byte(args[1]), &options)
+		if err != nil { log_warning(stub, "Invalid export options", "error", err); return nil, invalidArgument(1, "options", "Invalid export options") }
+	}
+
+	if options.Limit <= 0 {
+		options.Limit = defaultExportLimit
+	}
+	if options.Limit > maxExportLimit {
+		options.Limit = maxExportLimit
+	}
+	if options.Offset < 0 {
+		options.Offset = 0
+	}
+
+	filter, err := parse_activity_filter(args[2:])
+	if err != nil { log_failure(stub, "Invalid filter arguments", err); return nil, err }
+
+	err = load_consent_filter(stub, &filter)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	activities, err := load_activities(stub, filter.IncludeArchived)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	var matched []Activity
+	for i := range activities {
+		if filter.matches(activities[i]) {
+			matched = append(matched, activities[i])
+		}
+	}
+
+	chunk := ExportChunk{Format: format, Offset: options.Offset, NextOffset: -1, Total: len(matched)}
+
+	end := options.Offset + options.Limit
+	if end < len(matched) {
+		chunk.NextOffset = end
+	} else {
+		end = len(matched)
+	}
+
+	var page []Activity
+	if options.Offset < len(matched) {
+		page = matched[options.Offset:end]
+	}
+
+	documents, err := get_documents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve documents", err); return nil, internalError("Failed to retrieve documents", err) }
+
+	page = append([]Activity(nil), page...)
+	attachDocuments(page, documents)
+
+	for i := range page {
+		page[i].Actor = redactContact(page[i].Actor)
+	}
+
+	var buffer bytes.Buffer
+	if format == EXPORT_CSV {
+		chunk.Rows, err = writeActivitiesCSV(&buffer, page, filter, options.PerResource, options.Offset == 0)
+	} else {
+		chunk.Rows, err = writeActivitiesNDJSON(&buffer, page, filter, options.PerResource)
+	}
+	if err != nil { log_failure(stub, "Failed to write " + format, err); return nil, internalError("Failed to write " + format, err) }
+
+	chunk.Data = buffer.String()
+
+	return json.Marshal(chunk)
+}
+
+func writeActivitiesCSV(buffer *bytes.Buffer, activities []Activity, filter ActivityFilter, perResource bool, header bool) (int, error) {
+	writer := csv.NewWriter(buffer)
+	if header {
+		writer.Write(exportColumns)
+	}
+
+	rows := 0
+	for _, activity := range activities {
+		formatted := exportTimestamp(activity, filter)
+		base := []string{
+			strconv.FormatInt(activity.ActivityId, 10), strconv.FormatInt(activity.Timestamp, 10), formatted, activity.ActivityType,
+			activity.Actor.ActorType, activity.Actor.Name, activity.Actor.Telephone, activity.Actor.Email,
+			activity.Kiosk.KioskId, strconv.FormatFloat(activity.Kiosk.Latitude, 'f', -1, 64), strconv.FormatFloat(activity.Kiosk.Longitude, 'f', -1, 64), activity.Kiosk.Details,
+			activity.Device.DeviceType, activity.Device.Id1, activity.Device.Id2, activity.Device.Id3, activity.Device.Id4,
+			activity.Remark,
+		}
+
+		if perResource {
+			for _, resource := range activity.Resources {
+				writer.Write(append(base, resource.ResourceOwner, resource.ResourceType, resource.ResourceId, resource.Details,
+					jsonCell(append([]DocumentHash{}, resource.Documents...))))
+				rows++
+			}
+			if len(activity.Resources) == 0 {
+				writer.Write(append(base, "", "", "", "", ""))
+				rows++
+			}
+			continue
+		}
+
+		owners, types, ids, details := []string{}, []string{}, []string{}, []string{}
+		documents := [][]DocumentHash{}
+		for _, resource := range activity.Resources {
+			owners = append(owners, resource.ResourceOwner)
+			types = append(types, resource.ResourceType)
+			ids = append(ids, resource.ResourceId)
+			details = append(details, resource.Details)
+			documents = append(documents, append([]DocumentHash{}, resource.Documents...))
+		}
+		writer.Write(append(base, jsonCell(owners), jsonCell(types), jsonCell(ids), jsonCell(details), jsonCell(documents)))
+		rows++
+	}
+
+	writer.Flush()
+	return rows, writer.Error()
+}
+
+func writeActivitiesNDJSON(buffer *bytes.Buffer, activities []Activity, filter ActivityFilter, perResource bool) (int, error) {
+	encoder := json.NewEncoder(buffer)
+
+	rows := 0
+	for _, activity := range activities {
+		activity.TimestampFormatted = exportTimestamp(activity, filter)
+
+		if perResource && len(activity.Resources) > 0 {
+			resources := activity.Resources
+			for j := range resources {
+				activity.Resources = resources[j : j+1]
+				if err := encoder.Encode(activity); err != nil {
+					return rows, err
+				}
+				rows++
+			}
+			continue
+		}
+
+		if err := encoder.Encode(activity); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+
+	return rows, nil
+}
+
+// jsonCell renders a list as a JSON array, so a CSV cell can hold values containing any separator.
+func jsonCell(v interface{}) string {
+	valueAsBytes, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(valueAsBytes)
+}
+
+// exportTimestamp renders the timestamp in the requested format, falling back to RFC3339 so exports always carry a
+// readable time.
+func exportTimestamp(activity Activity, filter ActivityFilter) string {
+	layout := filter.TimeFormat
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return int64ToTime(activity.Timestamp).In(filter.Location).Format(layout)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command gateway serves a typed REST API in front of create_activity and view_activities.
+//
+//	POST /activities        create an activity from a JSON body
+//	GET  /activities        list activities, filters are named query parameters
+//	GET  /openapi.json      OpenAPI description of the above
+//
+// With -backend memory the gateway keeps activities in process and needs no Fabric network.
+//
+// The gateway does not authenticate callers itself, so it only listens on localhost by default. To serve other hosts,
+// put an authenticating reverse proxy in front that sets -user-header to the caller's enrolled user and strips any
+// value the caller sent. Each request then transacts as that user, who must be logged in on the peer, and the
+// chaincode's role checks apply per caller. Without -user-header every request transacts as -secure-context.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/khoazany/smart/client"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8080", "address to serve on, other hosts need an authenticating proxy in front")
+	backendName := flag.String("backend", "peer", "backend to use: peer or memory")
+	peerURL := flag.String("peer", "http://localhost:7050", "peer REST API address")
+	chaincode := flag.String("chaincode", "", "chaincode name (deployment id)")
+	secureContext := flag.String("secure-context", "", "enrolled user to transact as, empty when security is disabled")
+	userHeader := flag.String("user-header", "", "request header the authenticating proxy puts the enrolled user in, e.g. X-Forwarded-User")
+	flag.Parse()
+
+	var backend client.Backend
+	var userBackend func(user string) client.Backend
+	switch *backendName {
+	case "peer":
+		if *chaincode == "" {
+			log.Fatal("gateway: -chaincode is required with the peer backend")
+		}
+		backend = client.NewPeer(*peerURL, *chaincode, *secureContext)
+		userBackend = func(user string) client.Backend {
+			return client.NewPeer(*peerURL, *chaincode, user)
+		}
+	case "memory":
+		backend = client.NewMemory()
+		userBackend = func(user string) client.Backend {
+			return backend
+		}
+	default:
+		log.Fatalf("gateway: unknown backend %s", *backendName)
+	}
+
+	server := NewServer(backend)
+	if *userHeader != "" {
+		server.PerUser(*userHeader, userBackend)
+	}
+
+	log.Printf("gateway: serving on %s with the %s backend", *listen, *backendName)
+	log.Fatal(http.ListenAndServe(*listen, server))
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+type object map[string]interface{}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPI())
+}
+
+//==============================================================================================================================
+//	openAPI - Builds the OpenAPI 3 description. Schemas are generated from the client structs and the GET parameters
+//			  from queryParams, so the description follows the code.
+//==============================================================================================================================
+func openAPI() object {
+	schemas := object{}
+	for _, value := range []interface{}{client.Activity{}, client.NewActivity{}, client.CreateResult{}, ErrorBody{}} {
+		schemaFor(reflect.TypeOf(value), schemas)
+	}
+
+	var parameters []object
+	for _, param := range queryParams {
+		parameters = append(parameters, object{
+			"name": param.Name, "in": "query", "required": false, "description": param.Description,
+			"style": "form", "explode": false,
+			"schema": object{"type": "array", "items": object{"type": param.Type}},
+		})
+	}
+
+	errorResponse := func(description string) object {
+		return object{"description": description, "content": object{"application/json": object{"schema": ref("ErrorBody")}}}
+	}
+
+	return object{
+		"openapi": "3.0.0",
+		"info": object{"title": "HDB activity gateway", "version": "1.0.0"},
+		"paths": object{
+			"/activities": object{
+				"get": object{
+					"summary": "List activities matching the filters",
+					"parameters": parameters,
+					"responses": object{
+						"200": object{"description": "matching activities", "content": object{"application/json": object{
+							"schema": object{"type": "array", "items": ref("Activity")}}}},
+						"400": errorResponse("invalid filter"),
+						"401": errorResponse("no authenticated user, with -user-header"),
+						"502": errorResponse("chaincode failure"),
+					},
+				},
+				"post": object{
+					"summary": "Create an activity",
+					"requestBody": object{"required": true, "content": object{"application/json": object{"schema": ref("NewActivity")}}},
+					"responses": object{
+						"201": object{"description": "activity created", "content": object{"application/json": object{"schema": ref("CreateResult")}}},
+						"202": object{"description": "transaction submitted to the peer", "content": object{"application/json": object{"schema": ref("CreateResult")}}},
+						"400": errorResponse("invalid activity"),
+						"401": errorResponse("no authenticated user, with -user-header"),
+						"502": errorResponse("chaincode failure"),
+					},
+				},
+			},
+		},
+		"components": object{"schemas": schemas},
+	}
+}
+
+// schemaFor returns the schema of t, registering named structs in schemas and referring to them.
+func schemaFor(t reflect.Type, schemas object) object {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), schemas)
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return object{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number", "format": "double"}
+	case reflect.Slice:
+		return object{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, done := schemas[t.Name()]; !done {
+			schemas[t.Name()] = object{}								// placeholder against recursive types
+			properties := object{}
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if name == "" || name == "-" || field.PkgPath != "" {
+					continue
+				}
+				properties[name] = schemaFor(field.Type, schemas)
+			}
+			schemas[t.Name()] = object{"type": "object", "properties": properties}
+		}
+		return ref(t.Name())
+	}
+	return object{}
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
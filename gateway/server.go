@@ -0,0 +1,343 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+// ============================================================================================================================
+// ERROR CODES
+// ============================================================================================================================
+const INVALID_ARGUMENT = "INVALID_ARGUMENT"
+const NOT_FOUND = "NOT_FOUND"
+const METHOD_NOT_ALLOWED = "METHOD_NOT_ALLOWED"
+const BACKEND_ERROR = "BACKEND_ERROR"
+const UNAUTHENTICATED = "UNAUTHENTICATED"
+
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Field string `json:"field,omitempty"`
+}
+
+//==============================================================================================================================
+//	queryParam - A GET /activities query parameter. Parameters may be repeated or hold comma separated values; the same
+//				 table drives parsing and the OpenAPI description.
+//==============================================================================================================================
+type queryParam struct {
+	Name string
+	Type string												// OpenAPI type of a single value
+	Description string
+	Apply func(filter *client.ActivityFilter, values []string) error
+}
+
+var queryParams = []queryParam{
+	{"activityId", "integer", "activity ids", func(f *client.ActivityFilter, v []string) error {
+		for _, value := range v {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid activity id %s", value)
+			}
+			f.ActivityIds = append(f.ActivityIds, id)
+		}
+		return nil
+	}},
+	{"actorType", "string", "actor types", func(f *client.ActivityFilter, v []string) error { f.ActorTypes = v; return nil }},
+	{"name", "string", "actor names", func(f *client.ActivityFilter, v []string) error { f.Names = v; return nil }},
+	{"telephone", "string", "actor telephones", func(f *client.ActivityFilter, v []string) error { f.Telephones = v; return nil }},
+	{"email", "string", "actor emails", func(f *client.ActivityFilter, v []string) error { f.Emails = v; return nil }},
+	{"activityType", "string", "activity types", func(f *client.ActivityFilter, v []string) error { f.ActivityTypes = v; return nil }},
+	{"kioskId", "string", "kiosk ids", func(f *client.ActivityFilter, v []string) error { f.KioskIds = v; return nil }},
+	{"deviceType", "string", "device types", func(f *client.ActivityFilter, v []string) error { f.DeviceTypes = v; return nil }},
+	{"id1", "string", "device id1 values", func(f *client.ActivityFilter, v []string) error { f.Id1s = v; return nil }},
+	{"id2", "string", "device id2 values", func(f *client.ActivityFilter, v []string) error { f.Id2s = v; return nil }},
+	{"id3", "string", "device id3 values", func(f *client.ActivityFilter, v []string) error { f.Id3s = v; return nil }},
+	{"id4", "string", "device id4 values", func(f *client.ActivityFilter, v []string) error { f.Id4s = v; return nil }},
+	{"resourceOwner", "string", "resource owners", func(f *client.ActivityFilter, v []string) error { f.ResourceOwners = v; return nil }},
+	{"resourceType", "string", "resource types", func(f *client.ActivityFilter, v []string) error { f.ResourceTypes = v; return nil }},
+	{"resourceId", "string", "resource ids", func(f *client.ActivityFilter, v []string) error { f.ResourceIds = v; return nil }},
+	{"start", "string", "start of the time span: RFC3339, epoch milliseconds or a date", func(f *client.ActivityFilter, v []string) error { f.Start = v[0]; return nil }},
+	{"end", "string", "end of the time span: RFC3339, epoch milliseconds or a date", func(f *client.ActivityFilter, v []string) error { f.End = v[0]; return nil }},
+	{"bounds", "string", "inclusivity of start and end: [], [), (] or ()", func(f *client.ActivityFilter, v []string) error { options(f).Bounds = v[0]; return nil }},
+	{"timezone", "string", "timezone for date-only bounds and timestampFormatted", func(f *client.ActivityFilter, v []string) error { options(f).Timezone = v[0]; return nil }},
+	{"timeFormat", "string", "rfc3339 or a Go layout for timestampFormatted", func(f *client.ActivityFilter, v []string) error { options(f).TimeFormat = v[0]; return nil }},
+	{"purpose", "string", "only actors with an active consent to this purpose covering their contact data", func(f *client.ActivityFilter, v []string) error { options(f).Purpose = v[0]; return nil }},
+	{"includeArchived", "boolean", "also search archived activities", func(f *client.ActivityFilter, v []string) error {
+		include, err := strconv.ParseBool(v[0])
+		if err != nil {
+			return fmt.Errorf("invalid boolean %s", v[0])
+		}
+		options(f).IncludeArchived = include
+		return nil
+	}},
+	{"near", "number", "latitude,longitude,radius in meters", func(f *client.ActivityFilter, v []string) error {
+		values, err := parseNumbers(v, 3)
+		if err != nil {
+			return err
+		}
+		options(f).Near = &client.GeoCircle{Latitude: values[0], Longitude: values[1], Radius: values[2]}
+		return nil
+	}},
+	{"box", "number", "minLatitude,minLongitude,maxLatitude,maxLongitude", func(f *client.ActivityFilter, v []string) error {
+		values, err := parseNumbers(v, 4)
+		if err != nil {
+			return err
+		}
+		options(f).Box = &client.GeoBox{MinLatitude: values[0], MinLongitude: values[1], MaxLatitude: values[2], MaxLongitude: values[3]}
+		return nil
+	}},
+}
+
+type Server struct {
+	backend client.Backend
+	userHeader string										// set by PerUser
+	userBackend func(user string) client.Backend
+	mux *http.ServeMux
+}
+
+func NewServer(backend client.Backend) *Server {
+	s := &Server{backend: backend, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/activities", s.handleActivities)
+	s.mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, ErrorDetail{Code: NOT_FOUND, Message: "no such resource " + r.URL.Path})
+	})
+	return s
+}
+
+// PerUser makes every request transact as the enrolled user in the header, set by the authenticating proxy in front,
+// instead of the backend the server was created with. Requests without the header are refused.
+func (s *Server) PerUser(header string, userBackend func(user string) client.Backend) {
+	s.userHeader, s.userBackend = header, userBackend
+}
+
+// backendFor returns the backend to serve the request with, nil after writing the error when there is no user.
+func (s *Server) backendFor(w http.ResponseWriter, r *http.Request) client.Backend {
+	if s.userHeader == "" {
+		return s.backend
+	}
+
+	user := r.Header.Get(s.userHeader)
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, ErrorDetail{Code: UNAUTHENTICATED, Message: "missing authenticated user"})
+		return nil
+	}
+	return s.userBackend(user)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleActivities(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.listActivities(w, r)
+	case "POST":
+		s.createActivity(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrorDetail{Code: METHOD_NOT_ALLOWED, Message: r.Method + " is not supported"})
+	}
+}
+
+func (s *Server) listActivities(w http.ResponseWriter, r *http.Request) {
+	var filter client.ActivityFilter
+	query := r.URL.Query()
+
+	for name := range query {
+		if findParam(name) == nil {
+			writeError(w, http.StatusBadRequest, ErrorDetail{Code: INVALID_ARGUMENT, Message: "unknown query parameter", Field: name})
+			return
+		}
+	}
+
+	for _, param := range queryParams {
+		values := splitValues(query[param.Name])
+		if len(values) == 0 {
+			continue
+		}
+		if err := param.Apply(&filter, values); err != nil {
+			writeError(w, http.StatusBadRequest, ErrorDetail{Code: INVALID_ARGUMENT, Message: err.Error(), Field: param.Name})
+			return
+		}
+	}
+
+	backend := s.backendFor(w, r)
+	if backend == nil {
+		return
+	}
+
+	activities, err := backend.ViewActivities(filter)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if activities == nil {
+		activities = []client.Activity{}
+	}
+
+	writeJSON(w, http.StatusOK, activities)
+}
+
+func (s *Server) createActivity(w http.ResponseWriter, r *http.Request) {
+	var activity client.NewActivity
+
+	if err := json.NewDecoder(r.Body).Decode(&activity); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorDetail{Code: INVALID_ARGUMENT, Message: "invalid activity: " + err.Error()})
+		return
+	}
+
+	if field := missingField(activity); field != "" {
+		writeError(w, http.StatusBadRequest, ErrorDetail{Code: INVALID_ARGUMENT, Message: "missing required field", Field: field})
+		return
+	}
+
+	backend := s.backendFor(w, r)
+	if backend == nil {
+		return
+	}
+
+	result, err := backend.CreateActivity(activity)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+
+	// a peer only acknowledges the transaction, the activity exists once it is committed
+	status := http.StatusCreated
+	if result.Activity == nil {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, result)
+}
+
+func missingField(activity client.NewActivity) string {
+	switch {
+	case activity.Actor.ActorType == "":
+		return "actor.actorType"
+	case activity.ActivityType == "":
+		return "activityType"
+	case activity.Kiosk.KioskId == "":
+		return "kiosk.kioskId"
+	}
+
+	for i, resource := range activity.Resources {
+		if resource.ResourceId == "" {
+			return fmt.Sprintf("resources[%d].resourceId", i)
+		}
+	}
+	return ""
+}
+
+func findParam(name string) *queryParam {
+	for i := range queryParams {
+		if queryParams[i].Name == name {
+			return &queryParams[i]
+		}
+	}
+	return nil
+}
+
+func options(filter *client.ActivityFilter) *client.QueryOptions {
+	if filter.Options == nil {
+		filter.Options = &client.QueryOptions{}
+	}
+	return filter.Options
+}
+
+func splitValues(raw []string) []string {
+	var values []string
+	for _, value := range raw {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+func parseNumbers(values []string, count int) ([]float64, error) {
+	if len(values) != count {
+		return nil, fmt.Errorf("expecting %d comma separated numbers", count)
+	}
+
+	numbers := make([]float64, count)
+	for i, value := range values {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", value)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+func writeBackendError(w http.ResponseWriter, err error) {
+	log.Printf("gateway: backend error: %s", err)
+
+	detail := ErrorDetail{Code: BACKEND_ERROR, Message: err.Error()}
+	status := http.StatusBadGateway
+	if chaincodeError, ok := err.(*client.ChaincodeError); ok {
+		detail.Message = chaincodeError.Detail
+		if chaincodeError.ErrorCode != "" {
+			detail.Code, detail.Field = chaincodeError.ErrorCode, chaincodeError.Field
+			status = chaincodeStatus(chaincodeError.ErrorCode)
+		}
+	}
+	writeError(w, status, detail)
+}
+
+// chaincodeStatus maps a chaincode error code to the HTTP status of the response, failures of the ledger itself stay
+// a bad gateway.
+func chaincodeStatus(code string) int {
+	switch code {
+	case client.ERR_INVALID_ARGUMENT:
+		return http.StatusBadRequest
+	case client.ERR_NOT_FOUND:
+		return http.StatusNotFound
+	case client.ERR_PERMISSION_DENIED:
+		return http.StatusForbidden
+	case client.ERR_CONFLICT:
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+func writeError(w http.ResponseWriter, status int, detail ErrorDetail) {
+	writeJSON(w, status, ErrorBody{Error: detail})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("gateway: failed to write response: %s", err)
+	}
+}
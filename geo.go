@@ -0,0 +1,606 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var kiosksStr = "_kiosks"
+var kioskGeoPrefix = "_kioskGeo_"
+
+// ============================================================================================================================
+// GEOHASH
+// ============================================================================================================================
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+const geohashPrecision = 9							// ~5m cells, precise enough to store
+const maxSearchCells = 64							// upper bound on cells range-queried per search
+const earthRadiusMeters = 6371000.0
+
+// ============================================================================================================================
+// KIOSK REGISTRY
+// ============================================================================================================================
+type KioskRecord struct {
+	KioskId string `json:"kioskId"`
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Geohash string `json:"geohash"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen int64 `json:"lastSeen"`
+	Registered bool `json:"registered"`					// set by register_kiosk, otherwise learnt from activities
+	RegisteredAt int64 `json:"registeredAt"`
+	History []KioskRevision `json:"history,omitempty"`	// attribute changes in order, see kiosk_as_of
+	LastHeartbeat int64 `json:"lastHeartbeat,omitempty"`	// set on query results from the heartbeat key, see heartbeat.go
+	Firmware string `json:"firmware,omitempty"`
+	Health map[string]string `json:"health,omitempty"`
+	KnownDevices []Device `json:"knownDevices,omitempty"`		// every device reported at the kiosk, see alerts.go
+	SlotCapacity int `json:"slotCapacity,omitempty"`			// reservable slots, set by register_kiosk, see reservations.go
+}
+
+//==============================================================================================================================
+//	KioskRevision - The kiosk attributes from ChangedAt until the next revision.
+//==============================================================================================================================
+type KioskRevision struct {
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Details string `json:"details"`
+	Registered bool `json:"registered"`
+	ChangedAt int64 `json:"changedAt"`
+}
+
+type AllKiosks struct {
+	Kiosks map[string]KioskRecord `json:"kiosks"`
+}
+
+type KioskDistance struct {
+	Kiosk KioskRecord `json:"kiosk"`
+	Distance float64 `json:"distance"`				// meters
+}
+
+type GeoCircle struct {
+	Latitude float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius float64 `json:"radius"`					// meters
+}
+
+type GeoBox struct {
+	MinLatitude float64 `json:"minLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLatitude float64 `json:"maxLatitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+func (c GeoCircle) contains(latitude, longitude float64) bool {
+	return haversine(c.Latitude, c.Longitude, latitude, longitude) <= c.Radius
+}
+
+// A box with MinLongitude greater than MaxLongitude crosses the antimeridian.
+func (b GeoBox) contains(latitude, longitude float64) bool {
+	if latitude < b.MinLatitude || latitude > b.MaxLatitude {
+		return false
+	}
+	if b.MinLongitude > b.MaxLongitude {
+		return longitude >= b.MinLongitude || longitude <= b.MaxLongitude
+	}
+	return longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+// split returns the box as one or, when it crosses the antimeridian, two boxes that do not.
+func (b GeoBox) split() []GeoBox {
+	if b.MinLongitude <= b.MaxLongitude {
+		return []GeoBox{b}
+	}
+	east := GeoBox{MinLatitude: b.MinLatitude, MinLongitude: b.MinLongitude, MaxLatitude: b.MaxLatitude, MaxLongitude: 180}
+	west := GeoBox{MinLatitude: b.MinLatitude, MinLongitude: -180, MaxLatitude: b.MaxLatitude, MaxLongitude: b.MaxLongitude}
+	return []GeoBox{east, west}
+}
+
+// bounds returns the box enclosing the circle, crossing the antimeridian when the circle does.
+func (c GeoCircle) bounds() GeoBox {
+	dLat := c.Radius / earthRadiusMeters * 180 / math.Pi
+	dLon := 180.0
+	if cos := math.Cos(c.Latitude * math.Pi / 180); cos > 1e-9 {
+		dLon = math.Min(180, dLat/cos)
+	}
+	box := GeoBox{MinLatitude: math.Max(-90, c.Latitude-dLat), MinLongitude: -180,
+		MaxLatitude: math.Min(90, c.Latitude+dLat), MaxLongitude: 180}
+	if dLon < 180 {
+		box.MinLongitude = wrapLongitude(c.Longitude - dLon)
+		box.MaxLongitude = wrapLongitude(c.Longitude + dLon)
+	}
+	return box
+}
+
+//=================================================================================================================================
+//	 upsert_kiosk_location - Records the kiosk reported by an activity. Registered kiosks keep their registered location
+//							 and details, activities only move their last seen time.
+//=================================================================================================================================
+func upsert_kiosk_location(stub shim.ChaincodeStubInterface, kiosk Kiosk, device Device, timestamp int64) ([]Device, error) {
+	kiosks, err := get_kiosks(stub)
+	if err != nil { return nil, err }
+
+	record, existed := kiosks.Kiosks[kiosk.KioskId]
+	if !existed {
+		record = KioskRecord{KioskId: kiosk.KioskId, FirstSeen: timestamp}
+	}
+
+	if !record.Registered {
+		err = move_kiosk(stub, &record, kiosk.Latitude, kiosk.Longitude)
+		if err != nil { return nil, err }
+		record.Details = kiosk.Details
+	}
+
+	replaced := record.learnDevice(device)
+
+	record.revise(timestamp)
+	record.LastSeen = timestamp
+	kiosks.Kiosks[kiosk.KioskId] = record
+
+	return replaced, put_kiosks(stub, kiosks)
+}
+
+// learnDevice adds the device to the known devices. When the kiosk already knew other devices of the same type they
+// are returned, the caller raises a device mismatch. The first device of a type is learnt silently.
+func (record *KioskRecord) learnDevice(device Device) []Device {
+	if device.DeviceType == "" {
+		return nil
+	}
+
+	var sameType []Device
+	for _, known := range record.KnownDevices {
+		if known == device {
+			return nil
+		}
+		if known.DeviceType == device.DeviceType {
+			sameType = append(sameType, known)
+		}
+	}
+
+	record.KnownDevices = append(record.KnownDevices, device)
+	return sameType
+}
+
+// revise appends a history revision when the kiosk attributes differ from the latest revision.
+func (record *KioskRecord) revise(timestamp int64) {
+	revision := KioskRevision{Latitude: record.Latitude, Longitude: record.Longitude, Details: record.Details,
+		Registered: record.Registered, ChangedAt: timestamp}
+
+	if len(record.History) > 0 {
+		last := record.History[len(record.History)-1]
+		last.ChangedAt = timestamp
+		if last == revision {
+			return
+		}
+	}
+
+	record.History = append(record.History, revision)
+}
+
+// move_kiosk sets the kiosk coordinates and keeps the geohash index in step.
+func move_kiosk(stub shim.ChaincodeStubInterface, record *KioskRecord, latitude, longitude float64) error {
+	geohash := geohashEncode(latitude, longitude, geohashPrecision)
+	if record.Geohash != "" && record.Geohash != geohash {
+		err := stub.DelState(kioskGeoKey(record.Geohash, record.KioskId))
+		if err != nil { return err }
+	}
+
+	record.Latitude = latitude
+	record.Longitude = longitude
+	record.Geohash = geohash
+
+	return stub.PutState(kioskGeoKey(geohash, record.KioskId), []byte(record.KioskId))
+}
+
+func get_kiosks(stub shim.ChaincodeStubInterface) (AllKiosks, error) {
+	var kiosks AllKiosks
+
+	kiosksAsBytes, err := stub.GetState(kiosksStr)
+	if err != nil { return kiosks, err }
+
+	if len(kiosksAsBytes) > 0 {
+		err = json.Unmarshal(kiosksAsBytes, &kiosks)
+		if err != nil { return kiosks, corruptState(kiosksStr, "Corrupt kiosk registry record") }
+	}
+
+	if kiosks.Kiosks == nil {
+		kiosks.Kiosks = make(map[string]KioskRecord)
+	}
+
+	return kiosks, nil
+}
+
+func put_kiosks(stub shim.ChaincodeStubInterface, kiosks AllKiosks) error {
+	kiosksAsBytes, err := json.Marshal(kiosks)
+	if err != nil { return err }
+
+	return stub.PutState(kiosksStr, kiosksAsBytes)
+}
+
+func kioskGeoKey(geohash string, kioskId string) string {
+	return kioskGeoPrefix + geohash + "_" + kioskId
+}
+
+//=================================================================================================================================
+//	 kiosks_within_radius - args: latitude, longitude, radius in meters. Returns the kiosks sorted by distance.
+//=================================================================================================================================
+func (t *SimpleChaincode) kiosks_within_radius(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 3. latitude, longitude and radius in meters")
+	}
+
+	coordinates, err := parseFloats(args)
+	if err != nil { log_warning(stub, "Invalid coordinates", "error", err); return nil, invalidArgument(-1, "coordinates", "Invalid coordinates") }
+
+	err = checkPoint(0, coordinates[0], coordinates[1])
+	if err != nil { log_failure(stub, "Invalid coordinates", err); return nil, err }
+	if !validRadius(coordinates[2]) {
+		return nil, invalidArgument(2, "radius", "Invalid radius. Expecting a non-negative number of meters")
+	}
+
+	circle := GeoCircle{Latitude: coordinates[0], Longitude: coordinates[1], Radius: coordinates[2]}
+
+	kiosks, err := search_kiosks(stub, circle.bounds())
+	if err != nil { log_failure(stub, "Failed to search kiosks", err); return nil, internalError("Failed to search kiosks", err) }
+
+	result := []KioskDistance{}
+	for _, kiosk := range kiosks {
+		if circle.contains(kiosk.Latitude, kiosk.Longitude) {
+			result = append(result, KioskDistance{Kiosk: kiosk, Distance: haversine(circle.Latitude, circle.Longitude, kiosk.Latitude, kiosk.Longitude)})
+		}
+	}
+	sort.Sort(byDistance(result))
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 kiosks_in_box - args: minimum latitude, minimum longitude, maximum latitude, maximum longitude. A minimum longitude
+//					 greater than the maximum selects a box crossing the antimeridian.
+//=================================================================================================================================
+func (t *SimpleChaincode) kiosks_in_box(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 4. minimum latitude, minimum longitude, maximum latitude and maximum longitude")
+	}
+
+	coordinates, err := parseFloats(args)
+	if err != nil { log_warning(stub, "Invalid coordinates", "error", err); return nil, invalidArgument(-1, "coordinates", "Invalid coordinates") }
+
+	for first := 0; first < 4; first += 2 {
+		err = checkPoint(first, coordinates[first], coordinates[first+1])
+		if err != nil { log_failure(stub, "Invalid coordinates", err); return nil, err }
+	}
+	if coordinates[2] < coordinates[0] {
+		return nil, invalidArgument(2, "maxLatitude", "Invalid maximum latitude. Expecting at least the minimum latitude")
+	}
+
+	box := GeoBox{MinLatitude: coordinates[0], MinLongitude: coordinates[1], MaxLatitude: coordinates[2], MaxLongitude: coordinates[3]}
+
+	kiosks, err := search_kiosks(stub, box)
+	if err != nil { log_failure(stub, "Failed to search kiosks", err); return nil, internalError("Failed to search kiosks", err) }
+
+	result := []KioskRecord{}
+	for _, kiosk := range kiosks {
+		if box.contains(kiosk.Latitude, kiosk.Longitude) {
+			result = append(result, kiosk)
+		}
+	}
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 nearest_kiosks - args: latitude, longitude, number of kiosks. Searches growing geohash cells around the point and
+//					  only accepts a result once the n-th distance is covered by the searched cells.
+//=================================================================================================================================
+func (t *SimpleChaincode) nearest_kiosks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 3. latitude, longitude and number of kiosks")
+	}
+
+	coordinates, err := parseFloats(args[:2])
+	if err != nil { log_warning(stub, "Invalid coordinates", "error", err); return nil, invalidArgument(-1, "coordinates", "Invalid coordinates") }
+
+	err = checkPoint(0, coordinates[0], coordinates[1])
+	if err != nil { log_failure(stub, "Invalid coordinates", err); return nil, err }
+
+	n, err := strconv.Atoi(args[2])
+	if err != nil || n < 1 { log_warning(stub, "Invalid number of kiosks", "count", args[2]); return nil, invalidArgument(2, "count", "Invalid number of kiosks") }
+
+	latitude, longitude := coordinates[0], coordinates[1]
+
+	for precision := 6; precision >= 1; precision-- {
+		// the 3x3 block of cells around the point covers at least one cell size in every direction
+		center := geohashEncode(latitude, longitude, precision)
+		cells := append(geohashNeighbours(center), center)
+
+		kiosks, err := range_query_kiosks(stub, cells)
+		if err != nil { log_failure(stub, "Failed to search kiosks", err); return nil, internalError("Failed to search kiosks", err) }
+
+		result := nearest(kiosks, latitude, longitude, n)
+		if len(result) == n && result[n-1].Distance <= geohashCellMeters(center) {
+			return json.Marshal(result)
+		}
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	var all []KioskRecord
+	for _, kiosk := range kiosks.Kiosks {
+		all = append(all, kiosk)
+	}
+
+	return json.Marshal(nearest(all, latitude, longitude, n))
+}
+
+// search_kiosks returns the kiosks stored in the geohash cells covering the box. The result is a superset of the kiosks
+// inside the box, callers filter precisely.
+func search_kiosks(stub shim.ChaincodeStubInterface, box GeoBox) ([]KioskRecord, error) {
+	var cells []string
+	for precision := geohashPrecision; precision >= 1; precision-- {
+		cells = nil
+		for _, part := range box.split() {
+			cells = append(cells, geohashCover(part, precision)...)
+		}
+		if len(cells) <= maxSearchCells {
+			break
+		}
+	}
+
+	return range_query_kiosks(stub, cells)
+}
+
+func range_query_kiosks(stub shim.ChaincodeStubInterface, cells []string) ([]KioskRecord, error) {
+	registry, err := get_kiosks(stub)
+	if err != nil { return nil, err }
+
+	var kiosks []KioskRecord
+	seen := make(map[string]bool)
+	for _, cell := range cells {
+		iter, err := stub.RangeQueryState(kioskGeoPrefix+cell, kioskGeoPrefix+cell+"~")
+		if err != nil { return nil, err }
+
+		for iter.HasNext() {
+			_, kioskIdAsBytes, err := iter.Next()
+			if err != nil { iter.Close(); return nil, err }
+
+			kioskId := string(kioskIdAsBytes)
+			if seen[kioskId] {
+				continue
+			}
+			seen[kioskId] = true
+
+			if kiosk, ok := registry.Kiosks[kioskId]; ok {
+				kiosks = append(kiosks, kiosk)
+			}
+		}
+		iter.Close()
+	}
+
+	return kiosks, nil
+}
+
+func nearest(kiosks []KioskRecord, latitude, longitude float64, n int) []KioskDistance {
+	result := []KioskDistance{}
+	for _, kiosk := range kiosks {
+		result = append(result, KioskDistance{Kiosk: kiosk, Distance: haversine(latitude, longitude, kiosk.Latitude, kiosk.Longitude)})
+	}
+	sort.Sort(byDistance(result))
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+type byDistance []KioskDistance
+
+func (a byDistance) Len() int           { return len(a) }
+func (a byDistance) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDistance) Less(i, j int) bool { return a[i].Distance < a[j].Distance }
+
+// checkPoint fails unless args[first] is a latitude and args[first+1] a longitude. NaN is neither.
+func checkPoint(first int, latitude, longitude float64) error {
+	if !validLatitude(latitude) {
+		return invalidArgument(first, "latitude", "Invalid latitude. Expecting a number between -90 and 90")
+	}
+	if !validLongitude(longitude) {
+		return invalidArgument(first+1, "longitude", "Invalid longitude. Expecting a number between -180 and 180")
+	}
+	return nil
+}
+
+func validLatitude(latitude float64) bool {
+	return latitude >= -90 && latitude <= 90
+}
+
+func validLongitude(longitude float64) bool {
+	return longitude >= -180 && longitude <= 180
+}
+
+func validRadius(radius float64) bool {
+	return radius >= 0 && !math.IsInf(radius, 1)
+}
+
+// valid tells whether the circle of a near option can be searched.
+func (c GeoCircle) valid() bool {
+	return validLatitude(c.Latitude) && validLongitude(c.Longitude) && validRadius(c.Radius)
+}
+
+// valid tells whether the box of a box option can be searched.
+func (b GeoBox) valid() bool {
+	return validLatitude(b.MinLatitude) && validLatitude(b.MaxLatitude) && b.MinLatitude <= b.MaxLatitude &&
+		validLongitude(b.MinLongitude) && validLongitude(b.MaxLongitude)
+}
+
+func parseFloats(args []string) ([]float64, error) {
+	values := make([]float64, 0, len(args))
+	for _, arg := range args {
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// ============================================================================================================================
+// Geohash helpers
+// ============================================================================================================================
+func geohashEncode(latitude, longitude float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	hash := make([]byte, 0, precision)
+	bit, ch, even := 0, 0, true
+	for len(hash) < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if longitude >= mid {
+				ch |= 1 << uint(4-bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if latitude >= mid {
+				ch |= 1 << uint(4-bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return string(hash)
+}
+
+// geohashBox returns the cell covered by the geohash.
+func geohashBox(hash string) GeoBox {
+	box := GeoBox{MinLatitude: -90, MaxLatitude: 90, MinLongitude: -180, MaxLongitude: 180}
+
+	even := true
+	for i := 0; i < len(hash); i++ {
+		ch := strings.IndexByte(geohashBase32, hash[i])
+		for bit := 4; bit >= 0; bit-- {
+			set := ch&(1<<uint(bit)) != 0
+			if even {
+				mid := (box.MinLongitude + box.MaxLongitude) / 2
+				if set { box.MinLongitude = mid } else { box.MaxLongitude = mid }
+			} else {
+				mid := (box.MinLatitude + box.MaxLatitude) / 2
+				if set { box.MinLatitude = mid } else { box.MaxLatitude = mid }
+			}
+			even = !even
+		}
+	}
+
+	return box
+}
+
+// geohashNeighbours returns the eight cells around the geohash, skipping those beyond the poles.
+func geohashNeighbours(hash string) []string {
+	box := geohashBox(hash)
+	height := box.MaxLatitude - box.MinLatitude
+	width := box.MaxLongitude - box.MinLongitude
+	latitude := (box.MinLatitude + box.MaxLatitude) / 2
+	longitude := (box.MinLongitude + box.MaxLongitude) / 2
+
+	var neighbours []string
+	for _, dLat := range []float64{-height, 0, height} {
+		for _, dLon := range []float64{-width, 0, width} {
+			if dLat == 0 && dLon == 0 {
+				continue
+			}
+			lat := latitude + dLat
+			if lat < -90 || lat > 90 {
+				continue
+			}
+			lon := math.Mod(longitude+dLon+540, 360) - 180
+			neighbour := geohashEncode(lat, lon, len(hash))
+			if !containsString(neighbours, neighbour) {
+				neighbours = append(neighbours, neighbour)
+			}
+		}
+	}
+
+	return neighbours
+}
+
+// geohashCover returns the cells of the given precision that intersect the box.
+func geohashCover(box GeoBox, precision int) []string {
+	cell := geohashBox(geohashEncode(box.MinLatitude, box.MinLongitude, precision))
+	height := cell.MaxLatitude - cell.MinLatitude
+	width := cell.MaxLongitude - cell.MinLongitude
+
+	var cells []string
+	for lat := cell.MinLatitude + height/2; lat < box.MaxLatitude+height/2 && lat <= 90; lat += height {
+		for lon := cell.MinLongitude + width/2; lon < box.MaxLongitude+width/2 && lon <= 180; lon += width {
+			cells = append(cells, geohashEncode(lat, lon, precision))
+			if len(cells) > maxSearchCells && precision > 1 {
+				return cells
+			}
+		}
+	}
+
+	return cells
+}
+
+// geohashCellMeters returns the smaller side of the geohash cell in meters.
+func geohashCellMeters(hash string) float64 {
+	box := geohashBox(hash)
+	latitude := (box.MinLatitude + box.MaxLatitude) / 2
+	height := haversine(box.MinLatitude, box.MinLongitude, box.MaxLatitude, box.MinLongitude)
+	width := haversine(latitude, box.MinLongitude, latitude, box.MaxLongitude)
+	return math.Min(height, width)
+}
+
+// wrapLongitude brings a longitude past the antimeridian back into -180..180.
+func wrapLongitude(longitude float64) float64 {
+	if longitude < -180 || longitude > 180 {
+		return math.Mod(longitude+540, 360) - 180
+	}
+	return longitude
+}
+
+// haversine returns the great-circle distance between two points in meters.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
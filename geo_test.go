@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/khoazany/smart/sim"
+)
+
+func TestGeohashEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		latitude, longitude float64
+		precision int
+		want string
+	}{
+		{"reference point", 57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{"origin", 0, 0, 5, "s0000"},
+		{"south west corner", -90, -180, 5, "00000"},
+		{"north east corner", 90, 180, 5, "zzzzz"},
+		{"north pole", 90, 0, 5, "upbpb"},
+		{"south pole", -90, 0, 5, "h0000"},
+		{"east of the antimeridian", 0, 179.99, 5, "xbpbp"},
+		{"west of the antimeridian", 0, -179.99, 5, "80000"},
+	}
+
+	for _, test := range tests {
+		hash := geohashEncode(test.latitude, test.longitude, test.precision)
+		if hash != test.want {
+			t.Errorf("%s: %s, want %s", test.name, hash, test.want)
+		}
+
+		box := geohashBox(hash)
+		if test.latitude < box.MinLatitude || test.latitude > box.MaxLatitude ||
+			test.longitude < box.MinLongitude || test.longitude > box.MaxLongitude {
+			t.Errorf("%s: %+v does not contain the point", test.name, box)
+		}
+	}
+}
+
+func TestGeohashNeighbours(t *testing.T) {
+	tests := []struct {
+		name string
+		latitude, longitude float64
+		count int
+		includes []string									// cells that must be neighbours, encoded at the same precision
+		excludesNorth bool
+	}{
+		{"open sea", 1.29, 103.85, 8, nil, false},
+		{"north pole", 90, 0, 5, nil, true},
+		{"south pole", -90, 0, 5, nil, false},
+		{"east of the antimeridian", 0, 179.99, 8, []string{geohashEncode(0, -179.99, 5)}, false},
+		{"west of the antimeridian", 0, -179.99, 8, []string{geohashEncode(0, 179.99, 5)}, false},
+		{"pole at the antimeridian", 90, 179.99, 5, []string{geohashEncode(89.99, -179.99, 5)}, true},
+	}
+
+	for _, test := range tests {
+		hash := geohashEncode(test.latitude, test.longitude, 5)
+		neighbours := geohashNeighbours(hash)
+
+		if len(neighbours) != test.count {
+			t.Errorf("%s: %d neighbours %v, want %d", test.name, len(neighbours), neighbours, test.count)
+		}
+		if containsString(neighbours, hash) {
+			t.Errorf("%s: %s is its own neighbour", test.name, hash)
+		}
+		for _, want := range test.includes {
+			if !containsString(neighbours, want) {
+				t.Errorf("%s: %v does not include %s", test.name, neighbours, want)
+			}
+		}
+
+		cell := geohashBox(hash)
+		for _, neighbour := range neighbours {
+			box := geohashBox(neighbour)
+			if len(neighbour) != len(hash) || box.MaxLatitude-box.MinLatitude != cell.MaxLatitude-cell.MinLatitude {
+				t.Errorf("%s: neighbour %s is not a cell of the same size", test.name, neighbour)
+			}
+			if test.excludesNorth && box.MinLatitude >= cell.MaxLatitude {
+				t.Errorf("%s: neighbour %s lies beyond the pole", test.name, neighbour)
+			}
+		}
+	}
+}
+
+func TestGeoRanges(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+
+	tests := []struct {
+		name string
+		latitude, longitude float64
+		arg int												// index of the rejected coordinate, -1 when valid
+	}{
+		{"origin", 0, 0, -1},
+		{"poles and antimeridian", 90, -180, -1},
+		{"south pole", -90, 180, -1},
+		{"latitude past the pole", 90.0001, 0, 3},
+		{"longitude past the antimeridian", 0, 180.0001, 4},
+		{"NaN latitude", nan, 0, 3},
+		{"NaN longitude", 0, nan, 4},
+		{"infinite longitude", 0, -inf, 4},
+	}
+
+	for _, test := range tests {
+		err := checkPoint(3, test.latitude, test.longitude)
+
+		e, isChaincodeError := err.(*ChaincodeError)
+		switch {
+		case test.arg < 0 && err != nil:
+			t.Errorf("%s: rejected: %v", test.name, err)
+		case test.arg >= 0 && (!isChaincodeError || e.Code != ERR_INVALID_ARGUMENT || e.Arg == nil || *e.Arg != test.arg):
+			t.Errorf("%s: got %v, want argument %d rejected", test.name, err, test.arg)
+		}
+	}
+
+	radii := map[float64]bool{0: true, 1000: true, 20037508: true, -1: false, nan: false, inf: false}
+	for radius, want := range radii {
+		if validRadius(radius) != want {
+			t.Errorf("radius %v: valid %v, want %v", radius, !want, want)
+		}
+	}
+
+	boxes := []struct {
+		name string
+		box GeoBox
+		want bool
+	}{
+		{"plain", GeoBox{MinLatitude: 1, MinLongitude: 103, MaxLatitude: 2, MaxLongitude: 104}, true},
+		{"across the antimeridian", GeoBox{MinLatitude: -10, MinLongitude: 170, MaxLatitude: 10, MaxLongitude: -170}, true},
+		{"latitudes inverted", GeoBox{MinLatitude: 10, MinLongitude: 0, MaxLatitude: -10, MaxLongitude: 1}, false},
+		{"NaN corner", GeoBox{MinLatitude: nan, MinLongitude: 0, MaxLatitude: 1, MaxLongitude: 1}, false},
+	}
+	for _, test := range boxes {
+		if test.box.valid() != test.want {
+			t.Errorf("%s: valid %v, want %v", test.name, !test.want, test.want)
+		}
+	}
+}
+
+func TestKioskSearchesAtTheEdges(t *testing.T) {
+	ops := sim.Caller{Account: "ops1", Role: ADMIN}
+	at := time.Date(2016, 11, 2, 1, 0, 0, 0, time.UTC)
+
+	h := sim.NewHarness("hdb", new(SimpleChaincode))
+	results := []sim.Result{
+		h.Init(ops, at, "init", nil),
+		h.Invoke(ops, at, "register_kiosk", []string{"EAST", "0", "179.999", "Taveuni"}),
+		h.Invoke(ops, at, "register_kiosk", []string{"WEST", "0", "-179.999", "Kiribati"}),
+		h.Invoke(ops, at, "register_kiosk", []string{"POLE", "89.999", "45", "Station"}),
+	}
+	for _, result := range results {
+		if result.Err != nil { t.Fatalf("%s: %v", result.TxID, result.Err) }
+	}
+
+	tests := []struct {
+		name string
+		function string
+		args []string
+		want []string
+	}{
+		{"radius across the antimeridian", "kiosks_within_radius", []string{"0", "180", "1000"}, []string{"EAST", "WEST"}},
+		{"radius around the pole", "kiosks_within_radius", []string{"90", "-135", "1000"}, []string{"POLE"}},
+		{"box across the antimeridian", "kiosks_in_box", []string{"-1", "179", "1", "-179"}, []string{"EAST", "WEST"}},
+		{"box west of the antimeridian", "kiosks_in_box", []string{"-1", "-180", "1", "-179"}, []string{"WEST"}},
+		{"nearest to the antimeridian", "nearest_kiosks", []string{"0", "-180", "2"}, []string{"EAST", "WEST"}},
+	}
+
+	for _, test := range tests {
+		result := h.Query(ops, at, test.function, test.args)
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.name, result.Err)
+			continue
+		}
+
+		var kioskIds []string
+		if test.function == "kiosks_in_box" {
+			var found []KioskRecord
+			err := json.Unmarshal(result.Payload, &found)
+			if err != nil { t.Fatalf("%s: %v", test.name, err) }
+			for _, kiosk := range found {
+				kioskIds = append(kioskIds, kiosk.KioskId)
+			}
+		} else {
+			var found []KioskDistance
+			err := json.Unmarshal(result.Payload, &found)
+			if err != nil { t.Fatalf("%s: %v", test.name, err) }
+			for _, distance := range found {
+				kioskIds = append(kioskIds, distance.Kiosk.KioskId)
+			}
+		}
+		if len(kioskIds) != len(test.want) {
+			t.Errorf("%s: found %v, want %v", test.name, kioskIds, test.want)
+			continue
+		}
+		for _, want := range test.want {
+			if !containsString(kioskIds, want) {
+				t.Errorf("%s: found %v, want %v", test.name, kioskIds, test.want)
+			}
+		}
+	}
+}
@@ -17,8 +17,6 @@ limitations under the License.
 package main
 
 import (
-	"errors"
-	"fmt"
 	"strconv"
 	// "strings"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -28,7 +26,6 @@ import (
 	// "encoding/binary"
 )
 
-var logger = shim.NewLogger("HDBChaincode")
 var activitiesStr = "_activities"
 var activityCountStr = "_activityCount"
 
@@ -39,6 +36,8 @@ const ADMIN = "admin"
 const USER = "user"
 const VENDOR = "vendor"
 const BUSINESS = "business"
+const SUPER_ADMIN = "superadmin"								// cross-tenant reporting, see tenants.go
+const KIOSK = "kiosk"											// a kiosk enrolled under its kiosk id, see kiosk.go
 
 // ============================================================================================================================
 // HANDLE TIME
@@ -48,6 +47,17 @@ const (
 	nanosPerMillisecond = int64(time.Millisecond / time.Nanosecond)
 )
 
+// Layouts accepted for start and end arguments, besides epoch milliseconds. RFC3339Nano also parses RFC3339 values
+// with a Z or colon offset.
+var timeLayouts = []string{"2006-01-02T15:04:05-0700", time.RFC3339Nano, "2006-01-02T15:04:05"}
+const dateLayout = "2006-01-02"
+
+// Bounds of the [start, end] time span, "[" / "]" include and "(" / ")" exclude the boundary.
+const BOUNDS_INCLUSIVE = "[]"
+const BOUNDS_EXCLUSIVE = "()"
+const BOUNDS_START_INCLUSIVE = "[)"
+const BOUNDS_END_INCLUSIVE = "(]"
+
 // ============================================================================================================================
 // ACTIVITY
 // ============================================================================================================================
@@ -60,6 +70,11 @@ type Activity struct {
 	Device Device `json:"device"`
 	Remark string `json:"remark"`
 	Timestamp int64 `json:"timestamp"`			//utc timestamp of creation
+	TimestampFormatted string `json:"timestampFormatted,omitempty"`		//only set on query results, see ActivityQueryOptions
+	PrevHash string `json:"prevHash,omitempty"`				//hash of the previous activity, see chain.go
+	KioskPrevHash string `json:"kioskPrevHash,omitempty"`		//hash of the previous activity at the same kiosk
+	Hash string `json:"hash,omitempty"`						//hash of the canonical serialization of this activity
+	Reservations []int64 `json:"reservations,omitempty"`		//reservations linked by this deposit or collect, see reservations.go
 }
 
 type AllActivities struct {
@@ -93,6 +108,7 @@ type Resource struct {
 	ResourceType string `json:"resourceType"`
 	ResourceId string `json:"resourceId"`
 	Details string `json:"details"`
+	Documents []DocumentHash `json:"documents,omitempty"`		//only set on query results, see documents.go
 }
 
 //==============================================================================================================================
@@ -109,12 +125,15 @@ type SimpleChaincode struct {
 
 // Init resets all the things
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	err := check_args(CALL_INIT, "init", args)
+	if err != nil { log_failure(stub, "Invalid arguments", err); return nil, err }
+
 	for i:=0; i < len(args); i=i+2 {
 		t.add_ecert(stub, args[i], args[i+1])
 	}
 
 	// Set initial activityCount = 0
-	err := stub.PutState(activityCountStr, []byte(strconv.FormatInt(0,10)))
+	err = stub.PutState(activityCountStr, []byte(strconv.FormatInt(0,10)))
 	if err != nil {
 		return nil, err
 	}
@@ -124,57 +143,206 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 
 // Invoke is our entry point to invoke a chaincode function
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	// caller, caller_affiliation, err := t.get_caller_data(stub)
+	// Caller attributes are only present when security is enabled, functions that need a role deny an empty affiliation
+	caller, caller_affiliation, callerErr := t.get_caller_data(stub)
+	tenant, tenantErr := get_tenant(stub)
+
+	stub, err := log_call(stub, function, caller, tenant)
+	if err != nil { log_failure(stub, "Failed to retrieve log level", err) }
+	if callerErr != nil { log_debug(stub, "Caller information unavailable", "error", callerErr) }
+
+	log_debug(stub, "Invoke", "affiliation", caller_affiliation)
 
-	// if err != nil { fmt.Printf("CREATE_ACTIVITY: Error retrieving caller information: %s", err); return nil, errors.New("Error retrieving caller information")}
-	var caller, caller_affiliation string
+	// Functions index their arguments freely once the spec has been checked, see argspec.go
+	err = check_args(CALL_INVOKE, function, args)
+	if err != nil { log_failure(stub, "Invalid arguments", err); return nil, err }
 
-	logger.Debug("function: ", function)
-    logger.Debug("caller: ", caller)
-    logger.Debug("affiliation: ", caller_affiliation)
+	// Every function works on the state of the caller's tenant, except the log level shared by every tenant
+	if tenantErr != nil { log_failure(stub, "Invalid tenant", tenantErr); return nil, tenantErr }
+
+	if function == "set_log_level" {
+		return t.set_log_level(stub, caller_affiliation, args)
+	}
+
+	err = ensure_tenant(stub, tenant)
+	if err != nil { log_failure(stub, "Failed to register tenant", err); return nil, internalError("Failed to register tenant", err) }
+
+	stub = tenant_stub(stub, tenant)
 
 	// Handle different functions	
 	if function == "create_activity" {													//initialize the chaincode state, used as reset
 		return t.create_activity(stub, caller, caller_affiliation, args)
 	} else if function == "write" {
 		return t.write(stub, args)
+	} else if function == "rebuild_activity_counters" {
+		return t.rebuild_activity_counters(stub, caller_affiliation, args)
+	} else if function == "set_event_config" {
+		return t.set_event_config(stub, caller_affiliation, args)
+	} else if function == "register_kiosk" {
+		return t.register_kiosk(stub, caller_affiliation, args)
+	} else if function == "set_retention" {
+		return t.set_retention(stub, caller_affiliation, args)
+	} else if function == "archive_activities" {
+		return t.archive_activities(stub, caller_affiliation, args)
+	} else if function == "set_tariff" {
+		return t.set_tariff(stub, caller_affiliation, args)
+	} else if function == "close_period" {
+		return t.close_period(stub, caller, caller_affiliation, args)
+	} else if function == "set_rule" {
+		return t.set_rule(stub, caller, caller_affiliation, args)
+	} else if function == "delete_rule" {
+		return t.delete_rule(stub, caller_affiliation, args)
+	} else if function == "acknowledge_alert" {
+		return t.acknowledge_alert(stub, caller, caller_affiliation, args)
+	} else if function == "resolve_alert" {
+		return t.resolve_alert(stub, caller, caller_affiliation, args)
+	} else if function == "heartbeat" {
+		return t.heartbeat(stub, caller, caller_affiliation, args)
+	} else if function == "anchor_document" {
+		return t.anchor_document(stub, caller, args)
+	} else if function == "set_token_award" {
+		return t.set_token_award(stub, caller_affiliation, args)
+	} else if function == "transfer_tokens" {
+		return t.transfer_tokens(stub, caller, caller_affiliation, args)
+	} else if function == "redeem_tokens" {
+		return t.redeem_tokens(stub, caller, caller_affiliation, args)
+	} else if function == "reserve_slot" {
+		return t.reserve_slot(stub, caller, caller_affiliation, args)
+	} else if function == "release_reservation" {
+		return t.release_reservation(stub, caller, caller_affiliation, args)
+	} else if function == "expire_reservations" {
+		return t.expire_reservations(stub, args)
+	} else if function == "grant_consent" {
+		return t.grant_consent(stub, caller, caller_affiliation, args)
+	} else if function == "withdraw_consent" {
+		return t.withdraw_consent(stub, caller, caller_affiliation, args)
+	} else if function == "set_consent_requirement" {
+		return t.set_consent_requirement(stub, caller_affiliation, args)
+	} else if function == "audited_view" {
+		return t.audited_view(stub, caller, caller_affiliation, args)
 	}
 
-	fmt.Println("invoke did not find func: " + function)					//error
+	log_warning(stub, "Unknown function")
 
-	return nil, errors.New("Received unknown function invocation: " + function)
+	return nil, invalidArgument(-1, "function", "Received unknown function invocation: " + function)
 }
 
 func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args[] string) ([]byte, error) {
 	var key, value string
 	var err error
-	fmt.Println("running write()")
 
 	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the key and value to set")
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. name of the key and value to set")
 	}
 
 	key = args[0]
 	value = args[1]
+	if reservedKey(key) {
+		log_warning(stub, "Permission Denied", "key", key); return nil, permissionDenied("Permission Denied. Reserved key " + key)
+	}
+	log_debug(stub, "Writing state", "key", key)
+
 	err = stub.PutState(key, []byte(value))
 	if err != nil {
 		return nil, err
 	}
 
+	err = set_event(stub, EVENT_STATE_WRITTEN, StateWrittenEvent{Key: key})
+	if err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
 // Query is our entry point for queries
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
+	caller, caller_affiliation, callerErr := t.get_caller_data(stub)
+	tenant, tenantErr := get_tenant(stub)
+
+	stub, err := log_call(stub, function, caller, tenant)
+	if err != nil { log_failure(stub, "Failed to retrieve log level", err) }
+	if callerErr != nil { log_debug(stub, "Caller information unavailable", "error", callerErr) }
+
+	log_debug(stub, "Query", "affiliation", caller_affiliation)
+
+	err = check_args(CALL_QUERY, function, args)
+	if err != nil { log_failure(stub, "Invalid arguments", err); return nil, err }
+
+	if function == "tenant_report" {
+		return t.tenant_report(stub, caller_affiliation, args)
+	} else if function == "usage" {
+		return t.usage(stub, args)
+	}
+
+	// Every other query reads the state of the caller's tenant
+	if tenantErr != nil { log_failure(stub, "Invalid tenant", tenantErr); return nil, tenantErr }
+
+	stub = tenant_stub(stub, tenant)
 
 	// Handle different functions
 	if function == "view_activities" {											//read a variable
 		return t.view_activities(stub, args)
+	} else if function == "aggregate_activities" {
+		return t.aggregate_activities(stub, args)
+	} else if function == "kiosks_within_radius" {
+		return t.kiosks_within_radius(stub, args)
+	} else if function == "kiosks_in_box" {
+		return t.kiosks_in_box(stub, args)
+	} else if function == "nearest_kiosks" {
+		return t.nearest_kiosks(stub, args)
+	} else if function == "export_activities" {
+		return t.export_activities(stub, args)
+	} else if function == "activity_count" {
+		return t.activity_count(stub, args)
+	} else if function == "view_kiosks" {
+		return t.view_kiosks(stub, args)
+	} else if function == "verify_chain" {
+		return t.verify_chain(stub, args)
+	} else if function == "verify_document" {
+		return t.verify_document(stub, args)
+	} else if function == "view_archive" {
+		return t.view_archive(stub, args)
+	} else if function == "resource_as_of" {
+		return t.resource_as_of(stub, args)
+	} else if function == "kiosk_as_of" {
+		return t.kiosk_as_of(stub, args)
+	} else if function == "device_as_of" {
+		return t.device_as_of(stub, args)
+	} else if function == "stale_kiosks" {
+		return t.stale_kiosks(stub, args)
+	} else if function == "view_alerts" {
+		return t.view_alerts(stub, args)
+	} else if function == "view_rules" {
+		return t.view_rules(stub, args)
+	} else if function == "view_tariffs" {
+		return t.view_tariffs(stub, args)
+	} else if function == "usage_report" {
+		return t.usage_report(stub, caller_affiliation, args)
+	} else if function == "view_statements" {
+		return t.view_statements(stub, args)
+	} else if function == "token_balance" {
+		return t.token_balance(stub, args)
+	} else if function == "token_statement" {
+		return t.token_statement(stub, args)
+	} else if function == "view_token_awards" {
+		return t.view_token_awards(stub, args)
+	} else if function == "view_reservations" {
+		return t.view_reservations(stub, args)
+	} else if function == "slot_availability" {
+		return t.slot_availability(stub, args)
+	} else if function == "view_consents" {
+		return t.view_consents(stub, args)
+	} else if function == "view_consent_requirements" {
+		return t.view_consent_requirements(stub, args)
+	} else if function == "view_access_log" {
+		return t.view_access_log(stub, caller_affiliation, args)
+	} else if function == "audited_result" {
+		return t.audited_result(stub, caller, args)
 	}
-	fmt.Println("query did not find func: " + function)						//error
+	log_warning(stub, "Unknown function")
 
-	return nil, errors.New("Received unknown function query: " + function)
+	return nil, invalidArgument(-1, "function", "Received unknown function query: " + function)
 }
 
 func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
@@ -182,21 +350,54 @@ func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string)
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1")
 	}
 
 	key = args[0]
+	if reservedKey(key) {
+		log_warning(stub, "Permission Denied", "key", key); return nil, permissionDenied("Permission Denied. Reserved key " + key)
+	}
 	valAsbytes, err := stub.GetState(key)
 	
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
-		return nil, errors.New(jsonResp)
+		jsonResp = "Failed to get state for " + key
+		return nil, internalError(jsonResp, err)
 	}
 
 	return valAsbytes, nil
 }
 
+//==============================================================================================================================
+//	 activity_count - Returns the number of activities created so far. ActivityIds run from 0 to the count minus one,
+//					  off-chain mirrors use it to detect missing activities.
+//==============================================================================================================================
+func (t *SimpleChaincode) activity_count(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	activityCountAsBytes, err := stub.GetState(activityCountStr)
+	if err != nil { log_failure(stub, "Error when retrieving activity count", err); return nil, internalError("Error when retrieving activity count", err) }
+
+	return activityCountAsBytes, nil
+}
+
+//=================================================================================================================================
+//	 view_activities - Returns the activities matching the filter with the actors' telephone and email blanked,
+//					   audited_view and audited_result return them in full.
+//=================================================================================================================================
 func (t *SimpleChaincode) view_activities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	activities, err := find_activities(stub, args)
+	if err != nil { return nil, err }
+
+	for i := range activities {
+		activities[i].Actor = redactContact(activities[i].Actor)
+	}
+
+	activitiesAsBytes, err := json.Marshal(activities)
+	if err != nil { log_failure(stub, "Failed to convert activities", err); return nil, internalError("Failed to convert activities", err) }
+
+	return activitiesAsBytes, nil
+}
+
+// find_activities returns the activities matching the view_activities filter arguments, with their documents.
+func find_activities(stub shim.ChaincodeStubInterface, args []string) ([]Activity, error) {
 	// var key, jsonResp string
 	var err error
 
@@ -204,157 +405,284 @@ func (t *SimpleChaincode) view_activities(stub shim.ChaincodeStubInterface, args
 	// 	return nil, errors.New("Incorrect number of arguments. Expecting 1")
 	// }
 
-	// get the activities struct
-	activitiesAsBytes, err := stub.GetState(activitiesStr)
-	if err != nil { fmt.Printf("VIEW_ACTIVITIES: Failed to retrieve activities: %s", err); return nil, errors.New("Failed to retrieve activities") }
-	
-	var activities AllActivities
-	json.Unmarshal(activitiesAsBytes, &activities)
+	filter, err := parse_activity_filter(args)
+	if err != nil { log_failure(stub, "Invalid filter arguments", err); return nil, err }
+
+	err = load_consent_filter(stub, &filter)
+	if err != nil { log_failure(stub, "Failed to retrieve consents", err); return nil, internalError("Failed to retrieve consents", err) }
+
+	// get the activities, archived ones first when requested
+	activities, err := load_activities(stub, filter.IncludeArchived)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
 	var returnActivities []Activity
 
-	var activityIds []int64
-	json.Unmarshal([]byte(args[0]), &activityIds)
-	if err != nil { fmt.Printf("VIEW_ACTIVITIES: Failed to retrieve activityIds argument: %s", err); return nil, errors.New("Failed to retrieve activityIds argument") }
+	for i := range activities {
+		var activity = activities[i]
 
-	var actorTypes []string
-	json.Unmarshal([]byte(args[1]), &actorTypes)
-	
-	var names []string
-	json.Unmarshal([]byte(args[2]), &names)
+		if (!filter.matches(activity)) {
+			continue
+		}
 
-	var telephones []string
-	json.Unmarshal([]byte(args[3]), &telephones)
+		returnActivities = append(returnActivities, filter.format(activity))
+	}
 
-	var emails []string
-	json.Unmarshal([]byte(args[4]), &emails)
+	documents, err := get_documents(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve documents", err); return nil, internalError("Failed to retrieve documents", err) }
 
-	var activityTypes []string
-	json.Unmarshal([]byte(args[5]), &activityTypes)
+	attachDocuments(returnActivities, documents)
 
-	var kioskIds []string
-	json.Unmarshal([]byte(args[6]), &kioskIds)
+	return returnActivities, nil
+}
 
-	var deviceTypes []string
-	json.Unmarshal([]byte(args[7]), &deviceTypes)
+// ============================================================================================================================
+// ACTIVITY FILTER - the 17 positional JSON-array arguments shared by view_activities and the other activity queries,
+//					 followed by an optional JSON object of ActivityQueryOptions
+// ============================================================================================================================
+type ActivityQueryOptions struct {
+	Near *GeoCircle `json:"near"`						// activities at kiosks within radius meters of the point
+	Box *GeoBox `json:"box"`							// activities at kiosks inside the bounding box, minLongitude > maxLongitude crosses the antimeridian
+	Bounds string `json:"bounds"`						// one of the BOUNDS_ constants, defaults to inclusive
+	Timezone string `json:"timezone"`					// IANA name or offset such as +08:00, used for date-only bounds and output
+	TimeFormat string `json:"timeFormat"`				// rfc3339, or a Go layout, sets timestampFormatted on results
+	IncludeArchived bool `json:"includeArchived"`		// also search the archive segments, see archive.go
+	Purpose string `json:"purpose"`						// leave out actors without a covering consent to the purpose, see consents.go
+}
 
-	var id1s []string
-	json.Unmarshal([]byte(args[8]), &id1s)
+type ActivityFilter struct {
+	ActivityIds []int64
+	ActorTypes []string
+	Names []string
+	Telephones []string
+	Emails []string
+	ActivityTypes []string
+	KioskIds []string
+	DeviceTypes []string
+	Id1s []string
+	Id2s []string
+	Id3s []string
+	Id4s []string
+	ResourceOwners []string
+	ResourceTypes []string
+	ResourceIds []string
+	Start time.Time
+	End time.Time
+	StartInclusive bool
+	EndInclusive bool
+	Location *time.Location
+	TimeFormat string
+	Near *GeoCircle
+	Box *GeoBox
+	IncludeArchived bool
+	Purpose string
+	Consented map[string]bool							// actorKey of the actors with a covering consent to Purpose
+}
 
-	var id2s []string
-	json.Unmarshal([]byte(args[9]), &id2s)
+// unmarshalArg reads the JSON list in args[arg] into v, an empty argument leaves v empty.
+func unmarshalArg(args []string, arg int, field string, v interface{}) error {
+	if args[arg] == "" {
+		return nil
+	}
 
-	var id3s []string
-	json.Unmarshal([]byte(args[10]), &id3s)
+	err := json.Unmarshal([]byte(args[arg]), v)
+	if err != nil { return invalidArgument(arg, field, "Invalid " + field + ". Expecting a JSON array") }
 
-	var id4s []string
-	json.Unmarshal([]byte(args[11]), &id4s)
+	return nil
+}
+
+func parse_activity_filter(args []string) (ActivityFilter, error) {
+	var filter ActivityFilter
+	var err error
+
+	err = unmarshalArg(args, 0, "activityIds", &filter.ActivityIds)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 1, "actorTypes", &filter.ActorTypes)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 2, "names", &filter.Names)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 3, "telephones", &filter.Telephones)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 4, "emails", &filter.Emails)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 5, "activityTypes", &filter.ActivityTypes)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 6, "kioskIds", &filter.KioskIds)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 7, "deviceTypes", &filter.DeviceTypes)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 8, "id1s", &filter.Id1s)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 9, "id2s", &filter.Id2s)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 10, "id3s", &filter.Id3s)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 11, "id4s", &filter.Id4s)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 12, "resourceOwners", &filter.ResourceOwners)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 13, "resourceTypes", &filter.ResourceTypes)
+	if err != nil { return filter, err }
+	err = unmarshalArg(args, 14, "resourceIds", &filter.ResourceIds)
+	if err != nil { return filter, err }
+
+	var options ActivityQueryOptions
+	if (len(args) > 17 && args[17] != "") {
+		err = json.Unmarshal([]byte(args[17]), &options)
+		if err != nil { return filter, invalidArgument(17, "options", "Invalid options") }
+	}
 
-	var resourceOwners []string
-	json.Unmarshal([]byte(args[12]), &resourceOwners)
+	if options.Near != nil && !options.Near.valid() {
+		return filter, invalidArgument(17, "near", "Invalid near circle. Expecting a latitude, longitude and non-negative radius")
+	}
+	if options.Box != nil && !options.Box.valid() {
+		return filter, invalidArgument(17, "box", "Invalid box. Expecting latitudes from -90 to 90, minimum first, and longitudes from -180 to 180")
+	}
 
-	var resourceTypes []string
-	json.Unmarshal([]byte(args[13]), &resourceTypes)
+	filter.Near = options.Near
+	filter.Box = options.Box
+	filter.IncludeArchived = options.IncludeArchived
+	filter.Purpose = options.Purpose
+
+	filter.Location, err = parseTimezone(options.Timezone)
+	if err != nil { return filter, invalidArgument(17, "timezone", "Invalid timezone " + options.Timezone) }
+
+	switch options.Bounds {
+	case "", BOUNDS_INCLUSIVE:
+		filter.StartInclusive, filter.EndInclusive = true, true
+	case BOUNDS_START_INCLUSIVE:
+		filter.StartInclusive = true
+	case BOUNDS_END_INCLUSIVE:
+		filter.EndInclusive = true
+	case BOUNDS_EXCLUSIVE:
+	default:
+		return filter, invalidArgument(17, "bounds", "Invalid bounds " + options.Bounds)
+	}
 
-	var resourceIds []string
-	json.Unmarshal([]byte(args[14]), &resourceIds)
+	switch options.TimeFormat {
+	case "":
+	case "rfc3339":
+		filter.TimeFormat = time.RFC3339
+	default:
+		filter.TimeFormat = options.TimeFormat
+	}
 
- 	start := time.Time{}
 	if (args[15] != "") {
-		start, err = time.Parse("2006-01-02T15:04:05-0700", args[15])
-		if err != nil { fmt.Printf("VIEW_ACTIVITIES: Invalid start time format: %s", err); return nil, errors.New("Invalid start time format") }
+		filter.Start, err = parseTime(args[15], filter.Location)
+		if err != nil { return filter, invalidArgument(15, "start", "Invalid start time format") }
 	}
 
-	end := time.Time{}
 	if (args[16] != "") {
-	    end, err = time.Parse("2006-01-02T15:04:05-0700", args[16])
-	    if err != nil { fmt.Printf("VIEW_ACTIVITIES: Invalid end time format: %s", err); return nil, errors.New("Invalid end time format") }
+		filter.End, err = parseEndTime(args[16], filter.Location, filter.EndInclusive)
+		if err != nil { return filter, invalidArgument(16, "end", "Invalid end time format") }
 	}
 
-	for i := range activities.Activities {
-		var activity = activities.Activities[i]
+	return filter, nil
+}
 
-		if (len(activityIds) > 0 && !containsInt64(activityIds, activity.ActivityId)) {
-			continue
-		}
+// parseEndTime reads an end bound in the view_activities time formats. A date-only end covers the whole day, as in
+// parseAsOf: the last millisecond of the day when inclusive, the start of the next day when exclusive.
+func parseEndTime(value string, location *time.Location, inclusive bool) (time.Time, error) {
+	end, err := parseTime(value, location)
+	if err != nil { return end, err }
 
-		if (len(actorTypes) > 0 && !containsString(actorTypes, activity.Actor.ActorType)) {
-			continue
+	if _, err := time.Parse(dateLayout, value); err == nil {
+		end = end.AddDate(0, 0, 1)
+		if inclusive {
+			end = end.Add(-time.Millisecond)
 		}
+	}
 
-		if (len(names) > 0 && !containsString(names, activity.Actor.Name)) {
-			continue
-		}
+	return end, nil
+}
 
-		if (len(telephones) > 0 && !containsString(telephones, activity.Actor.Telephone)) {
-			continue
-		}
+func (filter ActivityFilter) matches(activity Activity) bool {
+	if (len(filter.ActivityIds) > 0 && !containsInt64(filter.ActivityIds, activity.ActivityId)) {
+		return false
+	}
 
-		if (len(emails) > 0 && !containsString(emails, activity.Actor.Email)) {
-			continue
-		}
+	if (len(filter.ActorTypes) > 0 && !containsString(filter.ActorTypes, activity.Actor.ActorType)) {
+		return false
+	}
 
-		if (len(activityTypes) > 0 && !containsString(activityTypes, activity.ActivityType)) {
-			continue
-		}
+	if (len(filter.Names) > 0 && !containsString(filter.Names, activity.Actor.Name)) {
+		return false
+	}
 
-		if (len(kioskIds) > 0 && !containsString(kioskIds, activity.Kiosk.KioskId)) {
-			continue
-		}
+	if (len(filter.Telephones) > 0 && !containsString(filter.Telephones, activity.Actor.Telephone)) {
+		return false
+	}
 
-		if (len(resourceOwners) > 0 || len(resourceTypes) > 0 || len(resourceIds) > 0) {
-			var existed = false
-			for j := range activity.Resources {
-				if (len(resourceOwners) > 0 && !containsString(resourceOwners, activity.Resources[j].ResourceOwner)) {
-					continue
-				}
+	if (len(filter.Emails) > 0 && !containsString(filter.Emails, activity.Actor.Email)) {
+		return false
+	}
 
-				if (len(resourceTypes) > 0 && !containsString(resourceTypes, activity.Resources[j].ResourceType)) {
-					continue
-				}
+	if (len(filter.ActivityTypes) > 0 && !containsString(filter.ActivityTypes, activity.ActivityType)) {
+		return false
+	}
 
-				if (len(resourceIds) > 0 && !containsString(resourceIds, activity.Resources[j].ResourceId)) {
-					continue
-				}
+	if (len(filter.KioskIds) > 0 && !containsString(filter.KioskIds, activity.Kiosk.KioskId)) {
+		return false
+	}
 
-				existed = true
+	if (len(filter.ResourceOwners) > 0 || len(filter.ResourceTypes) > 0 || len(filter.ResourceIds) > 0) {
+		var existed = false
+		for j := range activity.Resources {
+			if (len(filter.ResourceOwners) > 0 && !containsString(filter.ResourceOwners, activity.Resources[j].ResourceOwner)) {
+				continue
 			}
 
-			if (!existed) {
+			if (len(filter.ResourceTypes) > 0 && !containsString(filter.ResourceTypes, activity.Resources[j].ResourceType)) {
 				continue
 			}
-		}
 
-		if (len(deviceTypes) > 0 && !containsString(deviceTypes, activity.Device.DeviceType)) {
-			continue
-		}
+			if (len(filter.ResourceIds) > 0 && !containsString(filter.ResourceIds, activity.Resources[j].ResourceId)) {
+				continue
+			}
 
-		if (len(id1s) > 0 && !containsString(id1s, activity.Device.Id1)) {
-			continue
+			existed = true
 		}
 
-		if (len(id2s) > 0 && !containsString(id2s, activity.Device.Id2)) {
-			continue
+		if (!existed) {
+			return false
 		}
+	}
 
-		if (len(id3s) > 0 && !containsString(id3s, activity.Device.Id3)) {
-			continue
-		}
+	if (len(filter.DeviceTypes) > 0 && !containsString(filter.DeviceTypes, activity.Device.DeviceType)) {
+		return false
+	}
 
-		if (len(id4s) > 0 && !containsString(id4s, activity.Device.Id4)) {
-			continue
-		}
+	if (len(filter.Id1s) > 0 && !containsString(filter.Id1s, activity.Device.Id1)) {
+		return false
+	}
 
-		if ((!start.IsZero() || !end.IsZero()) && !inTimeSpan(start,end,int64ToTime(activity.Timestamp))) {
-			continue
-		}
+	if (len(filter.Id2s) > 0 && !containsString(filter.Id2s, activity.Device.Id2)) {
+		return false
+	}
 
-		returnActivities = append(returnActivities, activity)
+	if (len(filter.Id3s) > 0 && !containsString(filter.Id3s, activity.Device.Id3)) {
+		return false
 	}
 
-	returnActivitiesBytes, err := json.Marshal(returnActivities)
-	if err != nil { fmt.Printf("VIEW_ACTIVITIES: Failed to convert activities: %s", err); return nil, errors.New("Failed to convert activities") }
+	if (len(filter.Id4s) > 0 && !containsString(filter.Id4s, activity.Device.Id4)) {
+		return false
+	}
+
+	if ((!filter.Start.IsZero() || !filter.End.IsZero()) && !inTimeSpan(filter.Start, filter.End, int64ToTime(activity.Timestamp), filter.StartInclusive, filter.EndInclusive)) {
+		return false
+	}
 
-	return returnActivitiesBytes, nil
+	if (filter.Purpose != "" && !filter.Consented[actorKey(activity.Actor)]) {
+		return false
+	}
+
+	if (filter.Near != nil && !filter.Near.contains(activity.Kiosk.Latitude, activity.Kiosk.Longitude)) {
+		return false
+	}
+
+	if (filter.Box != nil && !filter.Box.contains(activity.Kiosk.Latitude, activity.Kiosk.Longitude)) {
+		return false
+	}
+
+	return true
 }
 
 func sliceAtoi64(sa []string) ([]int64, error) {
@@ -389,12 +717,50 @@ func containsString(slice []string, item string) bool {
     return ok
 }
 
-func inTimeSpan(start, end, check time.Time) bool {
-	logger.Debug("start: ", start)
-	logger.Debug("end: ", end)
-	logger.Debug("check: ", check)
+func inTimeSpan(start, end, check time.Time, startInclusive, endInclusive bool) bool {
+	afterStart := start.IsZero() || check.After(start) || (startInclusive && check.Equal(start))
+	beforeEnd := end.IsZero() || check.Before(end) || (endInclusive && check.Equal(end))
+
+    return afterStart && beforeEnd
+}
 
-    return (start.IsZero() || check.After(start)) && (end.IsZero() || check.Before(end))
+// format returns a copy of the activity carrying the timestamp rendered in the filter's timezone and format.
+func (filter ActivityFilter) format(activity Activity) Activity {
+	if filter.TimeFormat != "" {
+		activity.TimestampFormatted = int64ToTime(activity.Timestamp).In(filter.Location).Format(filter.TimeFormat)
+	}
+	return activity
+}
+
+//==============================================================================================================================
+//	 parseTime - Parses epoch milliseconds, RFC3339, the legacy "2006-01-02T15:04:05-0700" layout or a date-only value.
+//				 Values without an offset, including dates, are read in the given location.
+//==============================================================================================================================
+func parseTime(value string, location *time.Location) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return int64ToTime(ms), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.ParseInLocation(dateLayout, value, location)
+}
+
+func parseTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" || timezone == "UTC" || timezone == "Z" {
+		return time.UTC, nil
+	}
+
+	if offset, err := time.Parse("-07:00", timezone); err == nil {
+		_, seconds := offset.Zone()
+		return time.FixedZone(timezone, seconds), nil
+	}
+
+	return time.LoadLocation(timezone)
 }
 
 func int64ToTime(msInt int64) (time.Time) {
@@ -415,33 +781,30 @@ func (t *SimpleChaincode) create_activity(stub shim.ChaincodeStubInterface, call
 	// }
 
 	activityCountAsBytes, err := stub.GetState(activityCountStr)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Error when retrieving activity count: %s", err); return nil, errors.New("Error when retrieving activity count") }
+	if err != nil { log_failure(stub, "Error when retrieving activity count", err); return nil, internalError("Error when retrieving activity count", err) }
 
 	activityCount, err := strconv.ParseInt(string(activityCountAsBytes), 10, 64)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Error when converting activity count: %s", err); return nil, errors.New("Error when converting activity count") }
+	if err != nil { log_failure(stub, "Error when converting activity count", err); return nil, corruptState(activityCountStr, "Error when converting activity count") }
 
-	logger.Debug("activityCount: ", activityCount)
+	log_debug(stub, "Creating activity", "activityId", activityCount)
 
 	activityId       := activityCount
 	actor            := Actor{ActorType: args[0], Name: args[1], Telephone: args[2], Email: args[3]}
 	activityType     := args[4]
 	latitude, err := strconv.ParseFloat(args[6], 64)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Invalid latitude format: %s", err); return nil, errors.New("Invalid latitude format") }	
+	if err != nil { log_warning(stub, "Invalid latitude format", "error", err); return nil, invalidArgument(6, "latitude", "Invalid latitude format") }	
 	longitude, err := strconv.ParseFloat(args[7], 64)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Invalid longitude format: %s", err); return nil, errors.New("Invalid longitude format") }
+	if err != nil { log_warning(stub, "Invalid longitude format", "error", err); return nil, invalidArgument(7, "longitude", "Invalid longitude format") }
 
 	kiosk            := Kiosk{KioskId: args[5], Latitude: latitude, Longitude: longitude, Details: args[8]}
 	remark           := args[9]
-	timestamp        := makeTimestamp()	
+	timestamp        := makeTimestamp(stub)
 	device           := Device{DeviceType: args[10], Id1: args[11], Id2: args[12], Id3: args[13], Id4: args[14]}
 
-	logger.Debug("args: ", 14)
-
 	var resources []Resource
 	for i:=15;i < len(args);i=i+4 {
 		resource := Resource{ResourceOwner: args[i], ResourceType: args[i+1], ResourceId: args[i+2], Details: args[i+3]}
 		resources = append(resources, resource)
-		logger.Debug("args: ", i+3)
 	}
 
 	// activity_json := "{" + token + actor + activityType + kioskId + resourceId + resourceName + resourceType + remark + "}" 	// Concatenates the variables to create the total JSON object
@@ -457,14 +820,36 @@ func (t *SimpleChaincode) create_activity(stub shim.ChaincodeStubInterface, call
 
     // get the activities struct
 	activitiesAsBytes, err := stub.GetState(activitiesStr)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Failed to retrieve activities: %s", err); return nil, errors.New("Failed to retrieve activities") }
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
 	var activities AllActivities
-	json.Unmarshal(activitiesAsBytes, &activities)
+	if len(activitiesAsBytes) > 0 {
+		err = json.Unmarshal(activitiesAsBytes, &activities)
+		if err != nil { log_failure(stub, "Corrupt activities record", err); return nil, corruptState(activitiesStr, "Corrupt activities record") }
+	}
+
+	err = check_consent(stub, activity)
+	if err != nil { log_failure(stub, "Missing consent", err); return nil, err }
+
+	violations, err := evaluate_rules(stub, activity, activities.Activities)
+	if err != nil { log_failure(stub, "Failed to evaluate rules", err); return nil, internalError("Failed to evaluate rules", err) }
+
+	var alerts []Alert
+	for _, violation := range violations {
+		if violation.Rule.Action == RULE_REJECT {
+			log_warning(stub, "Rejected by rule", "ruleId", violation.Rule.RuleId); return nil, conflict("Rejected by rule " + violation.Rule.RuleId + ": " + violation.Message)
+		}
+		alerts = append(alerts, ruleAlert(activity, violation, timestamp))
+	}
+
+	err = link_reservations(stub, &activity)
+	if err != nil { log_failure(stub, "Failed to link reservations", err); return nil, internalError("Failed to link reservations", err) }
+
+	err = chain_activity(stub, &activity)
+	if err != nil { log_failure(stub, "Failed to chain activity", err); return nil, internalError("Failed to chain activity", err) }
 
 	activities.Activities = append(activities.Activities, activity)
-	fmt.Println("CREATE_ACTIVITY: Add new activity")
 	jsonAsBytes, err := json.Marshal(activities)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Failed to update activities: %s", err); return nil, errors.New("Failed to update activities") }
+	if err != nil { log_failure(stub, "Failed to update activities", err); return nil, internalError("Failed to update activities", err) }
 	
 	err = stub.PutState(activitiesStr, jsonAsBytes)
 	if err != nil {
@@ -477,27 +862,32 @@ func (t *SimpleChaincode) create_activity(stub shim.ChaincodeStubInterface, call
 		return nil, err
 	}
 
-	jsonAsBytes, err = json.Marshal(activity)
-	if err != nil { fmt.Printf("CREATE_ACTIVITY: Failed to return the new activity: %s", err); return nil, errors.New("Failed to return the new activity") }
-
-	fmt.Println("CREATE_ACTIVITY: End create activity process")																	
-	// bytes, err := stub.GetState("tokens")
-	// 																	if err != nil { return nil, errors.New("Unable to get tokens") }
+	replacedDevices, err := upsert_kiosk_location(stub, kiosk, device, timestamp)
+	if err != nil { log_failure(stub, "Failed to update kiosk location", err); return nil, internalError("Failed to update kiosk location", err) }
 
-	// var tokens Tokens_Holder
+	if len(replacedDevices) > 0 {
+		alerts = append(alerts, deviceMismatchAlert(activity, replacedDevices, timestamp))
+	}
 
-	// err = json.Unmarshal(bytes, &tokens)
-	// 																	if err != nil {	return nil, errors.New("Corrupt Token_Holder record") }
+	raised, err := raise_alerts(stub, alerts)
+	if err != nil { log_failure(stub, "Failed to raise alerts", err); return nil, internalError("Failed to raise alerts", err) }
 
-	// tokens.Tokens = append(tokens.Tokens, token)
+	counters, err := get_activity_counters(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve activity counters", err); return nil, internalError("Failed to retrieve activity counters", err) }
+	counters.add(activity)
+	err = put_activity_counters(stub, counters)
+	if err != nil { log_failure(stub, "Failed to update activity counters", err); return nil, internalError("Failed to update activity counters", err) }
 
-	// bytes, err = json.Marshal(tokens)
-	// 														if err != nil { fmt.Print("Error creating Token_Holder record") }
+	err = award_tokens(stub, caller, activity)
+	if err != nil { log_failure(stub, "Failed to award tokens", err); return nil, internalError("Failed to award tokens", err) }
 
-	// err = stub.PutState("tokens", bytes)
+	err = set_activity_created_event(stub, activity, raised)
+	if err != nil { log_failure(stub, "Failed to set activity event", err); return nil, internalError("Failed to set activity event", err) }
 
-	// 														if err != nil { return nil, errors.New("Unable to put the state") }
+	jsonAsBytes, err = json.Marshal(activity)
+	if err != nil { log_failure(stub, "Failed to return the new activity", err); return nil, internalError("Failed to return the new activity", err) }
 
+	log_info(stub, "Activity created", "activityId", activity.ActivityId, "activityType", activity.ActivityType, "actor", activity.Actor)
 
 	return jsonAsBytes, nil
 }
@@ -512,7 +902,7 @@ func (t *SimpleChaincode) get_ecert(stub shim.ChaincodeStubInterface, name strin
 
 	ecert, err := stub.GetState(name)
 
-	if err != nil { return nil, errors.New("Couldn't retrieve ecert for user " + name) }
+	if err != nil { return nil, internalError("Couldn't retrieve ecert for user " + name, err) }
 
 	return ecert, nil
 }
@@ -526,7 +916,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 	err := stub.PutState(name, []byte(ecert))
 
 	if err == nil {
-		return nil, errors.New("Error storing eCert for user " + name + " identity: " + ecert)
+		return nil, internalError("Error storing eCert for user " + name + " identity: " + ecert, err)
 	}
 
 	return nil, nil
@@ -540,7 +930,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string, error) {
 
     username, err := stub.ReadCertAttribute("account");
-	if err != nil { return "", errors.New("Couldn't get attribute 'account'. Error: " + err.Error()) }
+	if err != nil { return "", internalError("Couldn't get attribute 'account'. Error: " + err.Error(), err) }
 	return string(username), nil
 }
 
@@ -551,7 +941,7 @@ func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string
 
 func (t *SimpleChaincode) check_affiliation(stub shim.ChaincodeStubInterface) (string, error) {
     affiliation, err := stub.ReadCertAttribute("role");
-	if err != nil { return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) }
+	if err != nil { return "", internalError("Couldn't get attribute 'role'. Error: " + err.Error(), err) }
 	return string(affiliation), nil
 
 }
@@ -565,8 +955,6 @@ func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (str
 
 	user, err := t.get_username(stub)
 
-	logger.Debug("user: ", user)
-
 	affiliation, err := t.check_affiliation(stub);
 
     if err != nil { return "", "", err }
@@ -575,18 +963,14 @@ func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (str
 }
 
 // ============================================================================================================================
-// Make Timestamp - create a timestamp in ms
-// ============================================================================================================================
-func makeTimestamp() int64 {
-    return time.Now().UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond))
-}
-
+// Make Timestamp - create a timestamp in ms from the transaction timestamp, falling back to the local clock when the
+//					peer does not provide one
 // ============================================================================================================================
-// Main
-// ============================================================================================================================
-func main() {
-	err := shim.Start(new(SimpleChaincode))
-	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+func makeTimestamp(stub shim.ChaincodeStubInterface) int64 {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err == nil && txTimestamp != nil {
+		return txTimestamp.Seconds*millisPerSecond + int64(txTimestamp.Nanos)/nanosPerMillisecond
 	}
+
+    return time.Now().UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond))
 }
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEndTime(t *testing.T) {
+	sgt := time.FixedZone("+08:00", 8*60*60)
+
+	tests := []struct {
+		name string
+		value string
+		location *time.Location
+		inclusive bool
+		want time.Time
+	}{
+		{"date inclusive", "2016-11-02", time.UTC, true, time.Date(2016, 11, 2, 23, 59, 59, 999000000, time.UTC)},
+		{"date exclusive", "2016-11-02", time.UTC, false, time.Date(2016, 11, 3, 0, 0, 0, 0, time.UTC)},
+		{"date in a timezone", "2016-11-02", sgt, true, time.Date(2016, 11, 2, 23, 59, 59, 999000000, sgt)},
+		{"date at the end of a month", "2016-11-30", time.UTC, false, time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"RFC3339 kept", "2016-11-02T09:00:00+08:00", time.UTC, true, time.Date(2016, 11, 2, 9, 0, 0, 0, sgt)},
+		{"legacy layout kept", "2016-11-02T09:00:00+0800", time.UTC, false, time.Date(2016, 11, 2, 9, 0, 0, 0, sgt)},
+		{"local time kept", "2016-11-02T00:00:00", sgt, true, time.Date(2016, 11, 2, 0, 0, 0, 0, sgt)},
+		{"milliseconds kept", "1478044800000", time.UTC, true, time.Date(2016, 11, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		end, err := parseEndTime(test.value, test.location, test.inclusive)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !end.Equal(test.want) {
+			t.Errorf("%s: %v, want %v", test.name, end, test.want)
+		}
+	}
+
+	_, err := parseEndTime("2016-11-32", time.UTC, true)
+	if err == nil {
+		t.Errorf("invalid date accepted")
+	}
+}
+
+func TestActivityFilterDateOnlyEnd(t *testing.T) {
+	at := func(value string) int64 {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil { t.Fatal(err) }
+		return parsed.UnixNano() / int64(time.Millisecond)
+	}
+
+	tests := []struct {
+		name string
+		end string
+		options string
+		timestamp int64
+		want bool
+	}{
+		{"start of the day", "2016-11-02", "", at("2016-11-02T00:00:00Z"), true},
+		{"last millisecond of the day", "2016-11-02", "", at("2016-11-02T23:59:59.999Z"), true},
+		{"next day", "2016-11-02", "", at("2016-11-03T00:00:00Z"), false},
+		{"end exclusive, last millisecond", "2016-11-02", `{"bounds": "[)"}`, at("2016-11-02T23:59:59.999Z"), true},
+		{"end exclusive, next day", "2016-11-02", `{"bounds": "[)"}`, at("2016-11-03T00:00:00Z"), false},
+		{"day in a timezone", "2016-11-02", `{"timezone": "+08:00"}`, at("2016-11-02T15:59:59.999Z"), true},
+		{"next day in a timezone", "2016-11-02", `{"timezone": "+08:00"}`, at("2016-11-02T16:00:00Z"), false},
+		{"end with a time", "2016-11-02T09:00:00Z", "", at("2016-11-02T09:00:00.001Z"), false},
+	}
+
+	for _, test := range tests {
+		args := filterArgs()
+		args[16] = test.end
+		args[17] = test.options
+
+		filter, err := parse_activity_filter(args)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if matched := filter.matches(Activity{Timestamp: test.timestamp}); matched != test.want {
+			t.Errorf("%s: matched %v, want %v (end %v)", test.name, matched, test.want, filter.End)
+		}
+	}
+}
+
+func TestParsePeriodDateOnlyEnd(t *testing.T) {
+	start, end, err := parsePeriod([]string{"2016-11-01", "2016-11-30"}, 0)
+	if err != nil { t.Fatal(err) }
+
+	wantStart := time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	wantEnd := time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	if start != wantStart || end != wantEnd {
+		t.Errorf("period %d to %d, want %d to %d", start, end, wantStart, wantEnd)
+	}
+
+	_, _, err = parsePeriod([]string{"2016-11-02", "2016-11-01"}, 0)
+	if err == nil {
+		t.Errorf("period ending before its start accepted")
+	}
+}
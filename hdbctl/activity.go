@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khoazany/smart/client"
+)
+
+var actorTypes = []string{"admin", "user", "vendor", "business"}
+
+// resourceList is a repeatable -resource owner:type:id[:details] flag.
+type resourceList []client.Resource
+
+func (l *resourceList) String() string {
+	return fmt.Sprintf("%d resources", len(*l))
+}
+
+func (l *resourceList) Set(value string) error {
+	parts := strings.SplitN(value, ":", 4)
+	if len(parts) < 3 {
+		return errors.New("expecting owner:type:id[:details]")
+	}
+	if parts[2] == "" {
+		return errors.New("resource id must not be empty")
+	}
+
+	resource := client.Resource{ResourceOwner: parts[0], ResourceType: parts[1], ResourceId: parts[2]}
+	if len(parts) == 4 {
+		resource.Details = parts[3]
+	}
+	*l = append(*l, resource)
+	return nil
+}
+
+func activityCreate(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("activity create", flag.ExitOnError)
+	var activity client.NewActivity
+	var resources resourceList
+	fs.StringVar(&activity.Actor.ActorType, "actor-type", "", "actor type: admin, user, vendor or business (required)")
+	fs.StringVar(&activity.Actor.Name, "name", "", "actor name")
+	fs.StringVar(&activity.Actor.Telephone, "telephone", "", "actor telephone")
+	fs.StringVar(&activity.Actor.Email, "email", "", "actor email")
+	fs.StringVar(&activity.ActivityType, "type", "", "activity type (required)")
+	fs.StringVar(&activity.Kiosk.KioskId, "kiosk", "", "kiosk id (required)")
+	fs.Float64Var(&activity.Kiosk.Latitude, "lat", 0, "kiosk latitude")
+	fs.Float64Var(&activity.Kiosk.Longitude, "lon", 0, "kiosk longitude")
+	fs.StringVar(&activity.Kiosk.Details, "kiosk-details", "", "kiosk details")
+	fs.StringVar(&activity.Remark, "remark", "", "remark")
+	fs.StringVar(&activity.Device.DeviceType, "device-type", "", "device type")
+	fs.StringVar(&activity.Device.Id1, "id1", "", "device id1")
+	fs.StringVar(&activity.Device.Id2, "id2", "", "device id2")
+	fs.StringVar(&activity.Device.Id3, "id3", "", "device id3")
+	fs.StringVar(&activity.Device.Id4, "id4", "", "device id4")
+	fs.Var(&resources, "resource", "resource as owner:type:id[:details], may be repeated")
+	fs.Parse(args)
+
+	activity.Resources = resources
+	if err := validateActivity(activity); err != nil {
+		return err
+	}
+
+	result, err := peer.CreateActivity(activity)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", result.TxId)
+	return nil
+}
+
+func validateActivity(activity client.NewActivity) error {
+	found := false
+	for _, actorType := range actorTypes {
+		found = found || actorType == activity.Actor.ActorType
+	}
+	if !found {
+		return fmt.Errorf("-actor-type: must be one of %s", strings.Join(actorTypes, ", "))
+	}
+	if activity.ActivityType == "" {
+		return errors.New("-type is required")
+	}
+	if activity.Kiosk.KioskId == "" {
+		return errors.New("-kiosk is required")
+	}
+	if activity.Kiosk.Latitude < -90 || activity.Kiosk.Latitude > 90 {
+		return errors.New("-lat: must be between -90 and 90")
+	}
+	if activity.Kiosk.Longitude < -180 || activity.Kiosk.Longitude > 180 {
+		return errors.New("-lon: must be between -180 and 180")
+	}
+	return nil
+}
+
+func activityList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("activity list", flag.ExitOnError)
+	filters := addFilterFlags(fs)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	activities, err := peer.ViewActivities(filter)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(activities)
+	}
+
+	return printActivities(activities, false)
+}
+
+// printActivities prints one row per activity, with the actor's telephone and email when contact is set.
+func printActivities(activities []client.Activity, contact bool) error {
+	var rows [][]string
+	for _, a := range activities {
+		timestamp := a.TimestampFormatted
+		if timestamp == "" {
+			timestamp = time.Unix(0, a.Timestamp*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		}
+
+		var resourceIds []string
+		for _, r := range a.Resources {
+			resourceIds = append(resourceIds, r.ResourceId)
+		}
+
+		row := []string{strconv.FormatInt(a.ActivityId, 10), timestamp, a.ActivityType, a.Actor.ActorType, a.Actor.Name}
+		if contact {
+			row = append(row, a.Actor.Telephone, a.Actor.Email)
+		}
+		rows = append(rows, append(row, a.Kiosk.KioskId, a.Device.DeviceType, strings.Join(resourceIds, ",")))
+	}
+
+	header := []string{"ID", "TIME", "TYPE", "ACTOR TYPE", "ACTOR"}
+	if contact {
+		header = append(header, "TELEPHONE", "EMAIL")
+	}
+
+	return printTable(append(header, "KIOSK", "DEVICE", "RESOURCES"), rows)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func alertList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("alert list", flag.ExitOnError)
+	var statuses, kioskIds stringList
+	fs.Var(&statuses, "status", "statuses: open, acknowledged or resolved")
+	fs.Var(&kioskIds, "kiosk", "kiosk ids")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	alerts, err := peer.ViewAlerts(statuses, kioskIds)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(alerts)
+	}
+
+	var rows [][]string
+	for _, a := range alerts {
+		rows = append(rows, []string{strconv.FormatInt(a.AlertId, 10), a.AlertType, a.Status, a.KioskId,
+			strconv.FormatInt(a.ActivityId, 10), formatMillis(a.RaisedAt), a.Message})
+	}
+
+	return printTable([]string{"ALERT", "TYPE", "STATUS", "KIOSK", "ACTIVITY", "RAISED", "MESSAGE"}, rows)
+}
+
+func alertAcknowledge(peer *client.Peer, args []string) error {
+	return updateAlert("alert ack", peer.AcknowledgeAlert, args)
+}
+
+func alertResolve(peer *client.Peer, args []string) error {
+	return updateAlert("alert resolve", peer.ResolveAlert, args)
+}
+
+func updateAlert(name string, update func(int64, string) (string, error), args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	alertId := fs.Int64("id", -1, "alert id (required)")
+	note := fs.String("note", "", "note recorded on the alert")
+	fs.Parse(args)
+
+	if *alertId < 0 {
+		return errors.New("-id is required")
+	}
+
+	txId, err := update(*alertId, *note)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
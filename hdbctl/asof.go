@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/khoazany/smart/client"
+)
+
+// The as-of commands print JSON, the states are nested and read better that way than as a table.
+
+func asOfResource(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("as-of resource", flag.ExitOnError)
+	id := fs.String("id", "", "resource id (required)")
+	at := fs.String("at", "", "point in time: RFC3339, epoch milliseconds or a date for the end of that day (required)")
+	fs.Parse(args)
+
+	if *id == "" || *at == "" {
+		return errors.New("-id and -at are required")
+	}
+
+	state, err := peer.ResourceAsOf(*id, *at)
+	if err != nil {
+		return err
+	}
+	return printJSON(state)
+}
+
+func asOfKiosk(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("as-of kiosk", flag.ExitOnError)
+	id := fs.String("id", "", "kiosk id (required)")
+	at := fs.String("at", "", "point in time: RFC3339, epoch milliseconds or a date for the end of that day (required)")
+	fs.Parse(args)
+
+	if *id == "" || *at == "" {
+		return errors.New("-id and -at are required")
+	}
+
+	state, err := peer.KioskAsOf(*id, *at)
+	if err != nil {
+		return err
+	}
+	return printJSON(state)
+}
+
+func asOfDevice(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("as-of device", flag.ExitOnError)
+	deviceType := fs.String("type", "", "device type (required)")
+	id1 := fs.String("id1", "", "device id1 (required)")
+	at := fs.String("at", "", "point in time: RFC3339, epoch milliseconds or a date for the end of that day (required)")
+	fs.Parse(args)
+
+	if *deviceType == "" || *id1 == "" || *at == "" {
+		return errors.New("-type, -id1 and -at are required")
+	}
+
+	state, err := peer.DeviceAsOf(*deviceType, *id1, *at)
+	if err != nil {
+		return err
+	}
+	return printJSON(state)
+}
@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+func addActorFlags(fs *flag.FlagSet, actor *client.Actor) {
+	fs.StringVar(&actor.ActorType, "actor-type", "", "actor type: admin, user, vendor or business")
+	fs.StringVar(&actor.Name, "name", "", "actor name")
+	fs.StringVar(&actor.Telephone, "telephone", "", "actor telephone")
+	fs.StringVar(&actor.Email, "email", "", "actor email")
+}
+
+func consentList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("consent list", flag.ExitOnError)
+	var actor client.Actor
+	addActorFlags(fs, &actor)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	var only *client.Actor
+	if actor != (client.Actor{}) {
+		only = &actor
+	}
+
+	consents, err := peer.ViewConsents(only)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(consents)
+	}
+
+	var rows [][]string
+	for _, c := range consents {
+		withdrawn := ""
+		if c.WithdrawnAt != 0 {
+			withdrawn = formatMillis(c.WithdrawnAt)
+		}
+		rows = append(rows, []string{strconv.FormatInt(c.ConsentId, 10), c.Actor.ActorType, c.Actor.Name, c.Purpose,
+			strings.Join(c.Scope, ","), formatMillis(c.GrantedAt), withdrawn})
+	}
+
+	return printTable([]string{"CONSENT", "ACTOR TYPE", "NAME", "PURPOSE", "SCOPE", "GRANTED", "WITHDRAWN"}, rows)
+}
+
+func consentGrant(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("consent grant", flag.ExitOnError)
+	var actor client.Actor
+	addActorFlags(fs, &actor)
+	purpose := fs.String("purpose", "", "purpose the contact data may be used for (required)")
+	var scope stringList
+	fs.Var(&scope, "scope", "contact data covered: telephone, email (required)")
+	fs.Parse(args)
+
+	if actor.ActorType == "" || *purpose == "" || len(scope) == 0 {
+		return errors.New("-actor-type, -purpose and -scope are required")
+	}
+
+	txId, err := peer.GrantConsent(actor, *purpose, scope)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func consentWithdraw(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("consent withdraw", flag.ExitOnError)
+	consentId := fs.Int64("id", -1, "consent id (required)")
+	fs.Parse(args)
+
+	if *consentId < 0 {
+		return errors.New("-id is required")
+	}
+
+	txId, err := peer.WithdrawConsent(*consentId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func consentRequire(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("consent require", flag.ExitOnError)
+	activityType := fs.String("type", "", "activity type (required)")
+	var purposes stringList
+	fs.Var(&purposes, "purpose", "purposes required, none removes the requirement")
+	fs.Parse(args)
+
+	if *activityType == "" {
+		return errors.New("-type is required")
+	}
+
+	txId, err := peer.SetConsentRequirement(*activityType, purposes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func accessLog(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("access log", flag.ExitOnError)
+	var actor client.Actor
+	addActorFlags(fs, &actor)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	var only *client.Actor
+	if actor != (client.Actor{}) {
+		only = &actor
+	}
+
+	entries, err := peer.ViewAccessLog(only)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(entries)
+	}
+
+	var rows [][]string
+	for _, e := range entries {
+		rows = append(rows, []string{e.TxId, formatMillis(e.Timestamp), e.Caller, e.ReasonCode,
+			strconv.Itoa(e.ResultCount), strconv.Itoa(len(e.Actors))})
+	}
+
+	return printTable([]string{"TX", "TIME", "CALLER", "REASON", "RESULTS", "ACTORS"}, rows)
+}
+
+func activityAudit(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("activity audit", flag.ExitOnError)
+	reason := fs.String("reason", "", "reason code: service_request, investigation, legal or audit (required)")
+	filters := addFilterFlags(fs)
+	fs.Parse(args)
+
+	if *reason == "" {
+		return errors.New("-reason is required")
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	txId, err := peer.AuditedView(*reason, filter)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s, fetch the activities with activity audited -tx %s\n", txId, txId)
+	return nil
+}
+
+func activityAudited(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("activity audited", flag.ExitOnError)
+	txId := fs.String("tx", "", "transaction id of your activity audit (required)")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	if *txId == "" {
+		return errors.New("-tx is required")
+	}
+
+	activities, err := peer.AuditedResult(*txId)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(activities)
+	}
+
+	return printActivities(activities, true)
+}
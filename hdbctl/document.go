@@ -0,0 +1,130 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func documentAnchor(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("document anchor", flag.ExitOnError)
+	activityId := fs.Int64("activity", -1, "activity id (required)")
+	resourceId := fs.String("resource", "", "resource id within the activity (required)")
+	file := fs.String("file", "", "document to hash (required)")
+	mediaType := fs.String("media-type", "", "media type, guessed from the file extension when empty")
+	fs.Parse(args)
+
+	if *activityId < 0 || *resourceId == "" || *file == "" {
+		return errors.New("-activity, -resource and -file are required")
+	}
+
+	document, err := hashFile(*file)
+	if err != nil {
+		return err
+	}
+
+	document.MediaType = *mediaType
+	if document.MediaType == "" {
+		document.MediaType = mime.TypeByExtension(filepath.Ext(*file))
+	}
+
+	txId, err := peer.AnchorDocument(*activityId, *resourceId, document)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s:%s\nsubmitted transaction %s\n", document.Algorithm, document.Digest, txId)
+	return nil
+}
+
+func documentVerify(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("document verify", flag.ExitOnError)
+	file := fs.String("file", "", "document to hash")
+	digest := fs.String("digest", "", "sha256 digest in hex, instead of -file")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if (*file == "") == (*digest == "") {
+		return errors.New("exactly one of -file or -digest is required")
+	}
+
+	if *file != "" {
+		document, err := hashFile(*file)
+		if err != nil {
+			return err
+		}
+		*digest = document.Digest
+	}
+
+	verification, err := peer.VerifyDocument("sha256", *digest)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(verification)
+	}
+
+	if !verification.Anchored {
+		fmt.Printf("sha256:%s is not anchored\n", *digest)
+		return nil
+	}
+
+	document := verification.Document
+	fmt.Printf("sha256:%s first anchored %s\n\n", document.Digest, formatMillis(document.FirstAnchored))
+
+	var rows [][]string
+	for _, reference := range document.References {
+		rows = append(rows, []string{strconv.FormatInt(reference.ActivityId, 10), reference.ResourceId,
+			formatMillis(reference.AnchoredAt), reference.AnchoredBy, reference.TxId})
+	}
+
+	return printTable([]string{"ACTIVITY", "RESOURCE", "ANCHORED", "BY", "TRANSACTION"}, rows)
+}
+
+func hashFile(path string) (client.DocumentHash, error) {
+	document := client.DocumentHash{Algorithm: "sha256"}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return document, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	document.Size, err = io.Copy(hash, f)
+	if err != nil {
+		return document, err
+	}
+
+	document.Digest = hex.EncodeToString(hash.Sum(nil))
+	return document, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+func export(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	filters := addFilterFlags(fs)
+	format := fs.String("format", "csv", "csv or ndjson")
+	perResource := fs.Bool("per-resource", false, "one row per activity-resource pair")
+	chunk := fs.Int("chunk", 500, "activities fetched per query")
+	out := fs.String("out", "", "output file, stdout when empty")
+	fs.Parse(args)
+
+	if *format != "csv" && *format != "ndjson" {
+		return fmt.Errorf("-format: must be csv or ndjson")
+	}
+	if *chunk <= 0 {
+		return fmt.Errorf("-chunk: must be positive")
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	// the offset is recomputed by the chaincode on every call, activities created meanwhile may shift later chunks
+	options := client.ExportOptions{PerResource: *perResource, Limit: *chunk}
+	rows := 0
+	for {
+		result, err := peer.Export(*format, options, filter)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(w, strings.NewReader(result.Data)); err != nil {
+			return err
+		}
+		rows += result.Rows
+
+		if result.NextOffset < 0 {
+			break
+		}
+		options.Offset = result.NextOffset
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d rows\n", rows)
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+// stringList is a flag that may be repeated or hold comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	filterFlags - The view_activities filter as named flags, shared by activity list, export and stats.
+//==============================================================================================================================
+type filterFlags struct {
+	activityIds, actorTypes, names, telephones, emails, activityTypes, kioskIds stringList
+	deviceTypes, id1s, id2s, id3s, id4s, resourceOwners, resourceTypes, resourceIds stringList
+	start, end, bounds, timezone, timeFormat, near, box, purpose string
+	includeArchived bool
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.Var(&f.activityIds, "id", "activity ids")
+	fs.Var(&f.actorTypes, "actor-type", "actor types")
+	fs.Var(&f.names, "name", "actor names")
+	fs.Var(&f.telephones, "telephone", "actor telephones")
+	fs.Var(&f.emails, "email", "actor emails")
+	fs.Var(&f.activityTypes, "type", "activity types")
+	fs.Var(&f.kioskIds, "kiosk", "kiosk ids")
+	fs.Var(&f.deviceTypes, "device-type", "device types")
+	fs.Var(&f.id1s, "id1", "device id1 values")
+	fs.Var(&f.id2s, "id2", "device id2 values")
+	fs.Var(&f.id3s, "id3", "device id3 values")
+	fs.Var(&f.id4s, "id4", "device id4 values")
+	fs.Var(&f.resourceOwners, "resource-owner", "resource owners")
+	fs.Var(&f.resourceTypes, "resource-type", "resource types")
+	fs.Var(&f.resourceIds, "resource-id", "resource ids")
+	fs.StringVar(&f.start, "start", "", "start of the time span: RFC3339, epoch milliseconds or a date")
+	fs.StringVar(&f.end, "end", "", "end of the time span: RFC3339, epoch milliseconds or a date")
+	fs.StringVar(&f.bounds, "bounds", "", "inclusivity of start and end: [], [), (] or ()")
+	fs.StringVar(&f.timezone, "tz", "", "timezone for date-only bounds and formatted timestamps")
+	fs.StringVar(&f.timeFormat, "time-format", "", "rfc3339 or a Go layout for formatted timestamps")
+	fs.StringVar(&f.near, "near", "", "latitude,longitude,radius in meters")
+	fs.StringVar(&f.box, "box", "", "minLatitude,minLongitude,maxLatitude,maxLongitude")
+	fs.BoolVar(&f.includeArchived, "include-archived", false, "also search archived activities")
+	fs.StringVar(&f.purpose, "purpose", "", "only actors with an active consent to this purpose covering their contact data")
+	return f
+}
+
+func (f *filterFlags) filter() (client.ActivityFilter, error) {
+	filter := client.ActivityFilter{
+		ActorTypes: f.actorTypes, Names: f.names, Telephones: f.telephones, Emails: f.emails,
+		ActivityTypes: f.activityTypes, KioskIds: f.kioskIds, DeviceTypes: f.deviceTypes,
+		Id1s: f.id1s, Id2s: f.id2s, Id3s: f.id3s, Id4s: f.id4s,
+		ResourceOwners: f.resourceOwners, ResourceTypes: f.resourceTypes, ResourceIds: f.resourceIds,
+		Start: f.start, End: f.end,
+	}
+
+	for _, value := range f.activityIds {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("-id: invalid activity id %s", value)
+		}
+		filter.ActivityIds = append(filter.ActivityIds, id)
+	}
+
+	switch f.bounds {
+	case "", "[]", "[)", "(]", "()":
+	default:
+		return filter, fmt.Errorf("-bounds: must be one of [], [), (] or ()")
+	}
+
+	options := client.QueryOptions{Bounds: f.bounds, Timezone: f.timezone, TimeFormat: f.timeFormat, IncludeArchived: f.includeArchived,
+		Purpose: f.purpose}
+
+	if f.near != "" {
+		values, err := parseNumbers(f.near, 3)
+		if err != nil {
+			return filter, fmt.Errorf("-near: %s", err)
+		}
+		options.Near = &client.GeoCircle{Latitude: values[0], Longitude: values[1], Radius: values[2]}
+	}
+
+	if f.box != "" {
+		values, err := parseNumbers(f.box, 4)
+		if err != nil {
+			return filter, fmt.Errorf("-box: %s", err)
+		}
+		options.Box = &client.GeoBox{MinLatitude: values[0], MinLongitude: values[1], MaxLatitude: values[2], MaxLongitude: values[3]}
+	}
+
+	if options != (client.QueryOptions{}) {
+		filter.Options = &options
+	}
+
+	return filter, nil
+}
+
+func parseNumbers(value string, count int) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("expecting %d comma separated numbers", count)
+	}
+
+	numbers := make([]float64, count)
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", part)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Fields(path)
+}
+
+func joinPath(words []string) string {
+	return strings.Join(words, " ")
+}
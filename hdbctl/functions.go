@@ -0,0 +1,50 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+
+	"github.com/khoazany/smart/client"
+)
+
+func functions(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("functions", flag.ExitOnError)
+	function := fs.String("function", "", "only describe this chaincode function")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	specs, err := peer.Usage(*function)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(specs)
+	}
+
+	var rows [][]string
+	for _, s := range specs {
+		rows = append(rows, []string{s.Call, s.Usage, s.Summary})
+	}
+
+	return printTable([]string{"CALL", "USAGE", "SUMMARY"}, rows)
+}
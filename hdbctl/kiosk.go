@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/khoazany/smart/client"
+)
+
+func kioskRegister(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("kiosk register", flag.ExitOnError)
+	var kiosk client.Kiosk
+	fs.StringVar(&kiosk.KioskId, "id", "", "kiosk id (required)")
+	lat := fs.String("lat", "", "latitude (required)")
+	lon := fs.String("lon", "", "longitude (required)")
+	fs.StringVar(&kiosk.Details, "details", "", "kiosk details")
+	slots := fs.Int("slots", -1, "reservable slots, the current capacity is kept when omitted")
+	fs.Parse(args)
+
+	if kiosk.KioskId == "" {
+		return errors.New("-id is required")
+	}
+
+	var err error
+	kiosk.Latitude, err = strconv.ParseFloat(*lat, 64)
+	if err != nil || kiosk.Latitude < -90 || kiosk.Latitude > 90 {
+		return errors.New("-lat: must be a number between -90 and 90")
+	}
+	kiosk.Longitude, err = strconv.ParseFloat(*lon, 64)
+	if err != nil || kiosk.Longitude < -180 || kiosk.Longitude > 180 {
+		return errors.New("-lon: must be a number between -180 and 180")
+	}
+
+	var txId string
+	if *slots >= 0 {
+		txId, err = peer.RegisterKioskSlots(kiosk, *slots)
+	} else {
+		txId, err = peer.RegisterKiosk(kiosk)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func kioskList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("kiosk list", flag.ExitOnError)
+	var kioskIds stringList
+	fs.Var(&kioskIds, "id", "kiosk ids, all kiosks when empty")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	kiosks, err := peer.ViewKiosks(kioskIds)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(kiosks)
+	}
+
+	var rows [][]string
+	for _, k := range kiosks {
+		rows = append(rows, []string{k.KioskId, strconv.FormatFloat(k.Latitude, 'f', 6, 64), strconv.FormatFloat(k.Longitude, 'f', 6, 64),
+			strconv.FormatBool(k.Registered), formatMillis(k.LastSeen), k.Details})
+	}
+
+	return printTable([]string{"KIOSK", "LATITUDE", "LONGITUDE", "REGISTERED", "LAST SEEN", "DETAILS"}, rows)
+}
+
+func kioskStale(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("kiosk stale", flag.ExitOnError)
+	threshold := fs.Duration("threshold", 0, "silence after which a kiosk is stale, the chaincode default of 1h when 0")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	kiosks, err := peer.StaleKiosks(*threshold)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(kiosks)
+	}
+
+	var rows [][]string
+	for _, k := range kiosks {
+		silentFor := time.Duration(k.SilentFor) * time.Millisecond
+		rows = append(rows, []string{k.KioskId, formatMillis(k.LastSeen), formatMillis(k.LastHeartbeat), silentFor.String(), k.Firmware})
+	}
+
+	return printTable([]string{"KIOSK", "LAST SEEN", "LAST HEARTBEAT", "SILENT FOR", "FIRMWARE"}, rows)
+}
+
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
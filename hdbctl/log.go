@@ -0,0 +1,43 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/khoazany/smart/client"
+)
+
+func logLevel(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("log level", flag.ExitOnError)
+	level := fs.String("level", "", "DEBUG, INFO, NOTICE, WARNING, ERROR or CRITICAL")
+	fs.Parse(args)
+
+	if *level == "" {
+		return errors.New("-level is required")
+	}
+
+	txId, err := peer.SetLogLevel(*level)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
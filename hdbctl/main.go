@@ -0,0 +1,167 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command hdbctl is the operator command line for the HDB chaincode.
+//
+//	hdbctl [connection flags] activity create [flags]
+//	hdbctl [connection flags] activity list [filter flags]
+//	hdbctl [connection flags] activity audit -reason code [filter flags]
+//	hdbctl [connection flags] kiosk register [flags]
+//	hdbctl [connection flags] kiosk list [flags]
+//	hdbctl [connection flags] kiosk stale [flags]
+//	hdbctl [connection flags] kiosk slots [flags]
+//	hdbctl [connection flags] reservation list|reserve|release|expire [flags]
+//	hdbctl [connection flags] alert list|ack|resolve [flags]
+//	hdbctl [connection flags] rule list|set|delete [flags]
+//	hdbctl [connection flags] document anchor [flags]
+//	hdbctl [connection flags] document verify [flags]
+//	hdbctl [connection flags] as-of resource|kiosk|device [flags]
+//	hdbctl [connection flags] tariff list|set [flags]
+//	hdbctl [connection flags] usage [flags]
+//	hdbctl [connection flags] period list|close|statements [flags]
+//	hdbctl [connection flags] token awards|award|balance|statement|transfer [flags]
+//	hdbctl [connection flags] consent list|grant|withdraw|require [flags]
+//	hdbctl [connection flags] access log [flags]
+//	hdbctl [connection flags] tenant report [flags]
+//	hdbctl [connection flags] export [filter flags]
+//	hdbctl [connection flags] stats -group-by dims [filter flags]
+//	hdbctl [connection flags] functions [flags]
+//	hdbctl [connection flags] log level -level level
+//
+// Connection flags default to the HDB_PEER, HDB_CHAINCODE and HDB_SECURE_CONTEXT environment variables.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/khoazany/smart/client"
+)
+
+type command struct {
+	Path string
+	Summary string
+	Run func(peer *client.Peer, args []string) error
+}
+
+var commands = []command{
+	{"activity create", "create an activity", activityCreate},
+	{"activity list", "list activities matching the filters", activityList},
+	{"activity audit", "view activities as an audited transaction recorded in the access log", activityAudit},
+	{"activity audited", "show the activities of your audited transaction with contact data", activityAudited},
+	{"kiosk register", "register or update a kiosk", kioskRegister},
+	{"kiosk list", "list registered and observed kiosks", kioskList},
+	{"kiosk stale", "list kiosks not seen within a threshold", kioskStale},
+	{"kiosk slots", "show the free and taken slots of a kiosk", kioskSlots},
+	{"reservation list", "list slot reservations", reservationList},
+	{"reservation reserve", "hold a slot at a kiosk for a resource", reservationReserve},
+	{"reservation release", "release a held slot", reservationRelease},
+	{"reservation expire", "record the expiry of lapsed holds", reservationExpire},
+	{"alert list", "list alerts raised by activities", alertList},
+	{"alert ack", "acknowledge an open alert", alertAcknowledge},
+	{"alert resolve", "resolve an open or acknowledged alert", alertResolve},
+	{"rule list", "list anomaly rules", ruleList},
+	{"rule set", "create or replace an anomaly rule", ruleSet},
+	{"rule delete", "delete an anomaly rule", ruleDelete},
+	{"document anchor", "anchor the hash of a local file to an activity resource", documentAnchor},
+	{"document verify", "show where a local file or digest is anchored", documentVerify},
+	{"as-of resource", "show who owned a resource and where it was at a time", asOfResource},
+	{"as-of kiosk", "show kiosk attributes and devices at a time", asOfKiosk},
+	{"as-of device", "show the kiosk a device was bound to at a time", asOfDevice},
+	{"tariff list", "list tariffs per activity type", tariffList},
+	{"tariff set", "set the tariff of an activity type", tariffSet},
+	{"usage", "meter activities per resource owner and vendor, admin only", usageReport},
+	{"period list", "list closed settlement periods", periodList},
+	{"period close", "close a settlement period and write statements", periodClose},
+	{"period statements", "show the statements of a closed period", periodStatements},
+	{"token awards", "list the tokens awarded per activity type", tokenAwards},
+	{"token award", "set the tokens awarded for an activity type", tokenAward},
+	{"token balance", "show the token balance of an account", tokenBalance},
+	{"token statement", "list the token entries of an account", tokenStatement},
+	{"token transfer", "transfer tokens between accounts", tokenTransfer},
+	{"consent list", "list the consents of an actor or every consent", consentList},
+	{"consent grant", "record an actor's consent to a purpose", consentGrant},
+	{"consent withdraw", "withdraw a consent", consentWithdraw},
+	{"consent require", "set the purposes an activity type requires consent to", consentRequire},
+	{"access log", "list audited views of actor contact data", accessLog},
+	{"tenant report", "summarise every tenant, super-admin only", tenantReport},
+	{"export", "export activities matching the filters as CSV or NDJSON", export},
+	{"stats", "count activities grouped by dimensions", stats},
+	{"functions", "describe the arguments of the chaincode functions", functions},
+	{"log level", "set the chaincode log level, super-admin only", logLevel},
+}
+
+func main() {
+	global := flag.NewFlagSet("hdbctl", flag.ExitOnError)
+	peerURL := global.String("peer", envOr("HDB_PEER", "http://localhost:7050"), "peer REST API address")
+	chaincode := global.String("chaincode", os.Getenv("HDB_CHAINCODE"), "chaincode name (deployment id)")
+	secureContext := global.String("secure-context", os.Getenv("HDB_SECURE_CONTEXT"), "enrolled user to transact as")
+	global.Usage = usage(global)
+	global.Parse(os.Args[1:])
+
+	args := global.Args()
+	for _, cmd := range commands {
+		words := len(splitPath(cmd.Path))
+		if len(args) < words || joinPath(args[:words]) != cmd.Path {
+			continue
+		}
+
+		if *chaincode == "" {
+			fail(fmt.Errorf("-chaincode or HDB_CHAINCODE is required"))
+		}
+
+		if err := cmd.Run(client.NewPeer(*peerURL, *chaincode, *secureContext), args[words:]); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	global.Usage()
+	os.Exit(2)
+}
+
+func usage(global *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintln(os.Stderr, "usage: hdbctl [connection flags] <command> [flags]")
+		fmt.Fprintln(os.Stderr, "\ncommands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.Path, cmd.Summary)
+		}
+		fmt.Fprintln(os.Stderr, "\nconnection flags:")
+		global.PrintDefaults()
+	}
+}
+
+func fail(err error) {
+	if chaincodeError, ok := err.(*client.ChaincodeError); ok {
+		if chaincodeError.ErrorCode != "" {
+			fmt.Fprintf(os.Stderr, "hdbctl: chaincode rejected %s: %s: %s\n", chaincodeError.Function, chaincodeError.ErrorCode, chaincodeError.Detail)
+		} else {
+			fmt.Fprintf(os.Stderr, "hdbctl: chaincode rejected %s: %s\n", chaincodeError.Function, chaincodeError.Data)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "hdbctl: %s\n", err)
+	}
+	os.Exit(1)
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func kioskSlots(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("kiosk slots", flag.ExitOnError)
+	kioskId := fs.String("id", "", "kiosk id (required)")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *kioskId == "" {
+		return errors.New("-id is required")
+	}
+
+	availability, err := peer.SlotAvailability(*kioskId)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(availability)
+	}
+
+	return printTable([]string{"KIOSK", "CAPACITY", "HELD", "OCCUPIED", "FREE"},
+		[][]string{{availability.KioskId, strconv.Itoa(availability.SlotCapacity), strconv.Itoa(availability.Held),
+			strconv.Itoa(availability.Occupied), strconv.Itoa(availability.Free)}})
+}
+
+func reservationList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("reservation list", flag.ExitOnError)
+	var statuses, kioskIds stringList
+	fs.Var(&statuses, "status", "statuses: held, occupied, completed, released or expired")
+	fs.Var(&kioskIds, "kiosk", "kiosk ids")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	reservations, err := peer.ViewReservations(statuses, kioskIds)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(reservations)
+	}
+
+	var rows [][]string
+	for _, r := range reservations {
+		rows = append(rows, []string{strconv.FormatInt(r.ReservationId, 10), r.KioskId, r.ResourceId, r.Status,
+			formatMillis(r.ReservedAt), formatMillis(r.ExpiresAt)})
+	}
+
+	return printTable([]string{"RESERVATION", "KIOSK", "RESOURCE", "STATUS", "RESERVED", "EXPIRES"}, rows)
+}
+
+func reservationReserve(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("reservation reserve", flag.ExitOnError)
+	kioskId := fs.String("kiosk", "", "kiosk id (required)")
+	resourceId := fs.String("resource", "", "resource id (required)")
+	holder := fs.String("holder", "", "who the slot is held for")
+	hold := fs.Duration("hold", 0, "how long the slot is held before it expires, 30m when omitted")
+	fs.Parse(args)
+
+	if *kioskId == "" || *resourceId == "" {
+		return errors.New("-kiosk and -resource are required")
+	}
+	if *hold < 0 {
+		return errors.New("-hold must not be negative")
+	}
+
+	txId, err := peer.ReserveSlot(*kioskId, *resourceId, *holder, *hold)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func reservationRelease(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("reservation release", flag.ExitOnError)
+	reservationId := fs.Int64("id", -1, "reservation id (required)")
+	fs.Parse(args)
+
+	if *reservationId < 0 {
+		return errors.New("-id is required")
+	}
+
+	txId, err := peer.ReleaseReservation(*reservationId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func reservationExpire(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("reservation expire", flag.ExitOnError)
+	fs.Parse(args)
+
+	txId, err := peer.ExpireReservations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func ruleList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("rule list", flag.ExitOnError)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	rules, err := peer.ViewRules()
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(rules)
+	}
+
+	var rows [][]string
+	for _, r := range rules {
+		parameters := "-"
+		switch r.RuleType {
+		case client.RULE_IMPOSSIBLE_TRAVEL:
+			parameters = fmt.Sprintf("max %g km/h within %d min", r.MaxSpeedKmh, r.WindowMinutes)
+		case client.RULE_COLLECT_BEFORE_DEPOSIT:
+			parameters = r.CollectType + " after " + r.DepositType
+		}
+		rows = append(rows, []string{r.RuleId, r.RuleType, r.Action, strconv.FormatBool(!r.Disabled), parameters})
+	}
+
+	return printTable([]string{"RULE", "TYPE", "ACTION", "ENABLED", "PARAMETERS"}, rows)
+}
+
+func ruleSet(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("rule set", flag.ExitOnError)
+	var rule client.Rule
+	fs.StringVar(&rule.RuleId, "id", "", "rule id (required)")
+	fs.StringVar(&rule.RuleType, "type", "", "impossible_travel or collect_before_deposit (required)")
+	fs.StringVar(&rule.Action, "action", client.RULE_FLAG, "flag to raise an alert, reject to refuse the activity")
+	fs.BoolVar(&rule.Disabled, "disabled", false, "store the rule without evaluating it")
+	fs.Float64Var(&rule.MaxSpeedKmh, "max-speed", 0, "impossible_travel: maximum plausible speed in km/h")
+	fs.IntVar(&rule.WindowMinutes, "window", 0, "impossible_travel: minutes to look back for the actor's previous activity")
+	fs.StringVar(&rule.DepositType, "deposit-type", "", "collect_before_deposit: activity type of a deposit")
+	fs.StringVar(&rule.CollectType, "collect-type", "", "collect_before_deposit: activity type of a collection")
+	fs.Parse(args)
+
+	if rule.RuleId == "" || rule.RuleType == "" {
+		return errors.New("-id and -type are required")
+	}
+	if rule.Action != client.RULE_FLAG && rule.Action != client.RULE_REJECT {
+		return fmt.Errorf("-action: must be %s or %s", client.RULE_FLAG, client.RULE_REJECT)
+	}
+
+	txId, err := peer.SetRule(rule)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func ruleDelete(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("rule delete", flag.ExitOnError)
+	ruleId := fs.String("id", "", "rule id (required)")
+	fs.Parse(args)
+
+	if *ruleId == "" {
+		return errors.New("-id is required")
+	}
+
+	txId, err := peer.DeleteRule(*ruleId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
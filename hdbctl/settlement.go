@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func tariffList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("tariff list", flag.ExitOnError)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	tariffs, err := peer.ViewTariffs()
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(tariffs)
+	}
+
+	var rows [][]string
+	for _, t := range tariffs {
+		rows = append(rows, []string{t.ActivityType, strconv.FormatInt(t.PerActivity, 10), strconv.FormatInt(t.PerResource, 10),
+			strconv.FormatInt(t.VendorFee, 10), formatMillis(t.UpdatedAt)})
+	}
+
+	return printTable([]string{"ACTIVITY TYPE", "PER ACTIVITY", "PER RESOURCE", "VENDOR FEE", "UPDATED"}, rows)
+}
+
+func tariffSet(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("tariff set", flag.ExitOnError)
+	var tariff client.Tariff
+	fs.StringVar(&tariff.ActivityType, "type", "", "activity type (required)")
+	fs.Int64Var(&tariff.PerActivity, "per-activity", 0, "charged to each resource owner per activity, in minor units")
+	fs.Int64Var(&tariff.PerResource, "per-resource", 0, "charged to the resource owner per resource, in minor units")
+	fs.Int64Var(&tariff.VendorFee, "vendor-fee", 0, "paid to a vendor actor per activity, in minor units")
+	fs.Parse(args)
+
+	if tariff.ActivityType == "" {
+		return errors.New("-type is required")
+	}
+	if tariff.PerActivity < 0 || tariff.PerResource < 0 || tariff.VendorFee < 0 {
+		return errors.New("amounts must not be negative")
+	}
+
+	txId, err := peer.SetTariff(tariff)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func usageReport(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	start := fs.String("start", "", "start of the span, inclusive (required)")
+	end := fs.String("end", "", "end of the span, exclusive (required)")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *start == "" || *end == "" {
+		return errors.New("-start and -end are required")
+	}
+
+	usages, err := peer.UsageReport(*start, *end)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(usages)
+	}
+	return printUsages(usages)
+}
+
+func periodList(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("period list", flag.ExitOnError)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	periods, err := peer.ViewPeriods()
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(periods)
+	}
+
+	var rows [][]string
+	for _, p := range periods {
+		rows = append(rows, []string{p.PeriodId, formatMillis(p.Start), formatMillis(p.End), formatMillis(p.ClosedAt),
+			p.ClosedBy, strconv.Itoa(len(p.Statements))})
+	}
+
+	return printTable([]string{"PERIOD", "START", "END", "CLOSED", "BY", "STATEMENTS"}, rows)
+}
+
+func periodClose(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("period close", flag.ExitOnError)
+	periodId := fs.String("id", "", "period id, e.g. 2016-11 (required)")
+	start := fs.String("start", "", "start of the period, inclusive (required)")
+	end := fs.String("end", "", "end of the period, exclusive (required)")
+	fs.Parse(args)
+
+	if *periodId == "" || *start == "" || *end == "" {
+		return errors.New("-id, -start and -end are required")
+	}
+
+	txId, err := peer.ClosePeriod(*periodId, *start, *end)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func periodStatements(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("period statements", flag.ExitOnError)
+	periodId := fs.String("id", "", "period id (required)")
+	party := fs.String("party", "", "only the statements of this resource owner or vendor account, e.g. vendor:<telephone>")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *periodId == "" {
+		return errors.New("-id is required")
+	}
+
+	statements, err := peer.ViewStatements(*periodId, *party)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(statements)
+	}
+
+	var usages []client.Usage
+	for _, s := range statements {
+		usages = append(usages, s.Usage)
+	}
+	return printUsages(usages)
+}
+
+func printUsages(usages []client.Usage) error {
+	var rows [][]string
+	for _, u := range usages {
+		rows = append(rows, []string{u.PartyType, u.Party, u.Name, strconv.Itoa(u.Activities), strconv.FormatInt(u.Total, 10)})
+	}
+
+	return printTable([]string{"PARTY TYPE", "PARTY", "NAME", "ACTIVITIES", "TOTAL"}, rows)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/khoazany/smart/client"
+)
+
+var dimensions = []string{"activityType", "kioskId", "actorType", "deviceType", "resourceType", "hour", "day", "week", "month"}
+
+func stats(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	filters := addFilterFlags(fs)
+	var groupBy stringList
+	fs.Var(&groupBy, "group-by", "dimensions: "+strings.Join(dimensions, ", "))
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	for _, dimension := range groupBy {
+		found := false
+		for _, known := range dimensions {
+			found = found || known == dimension
+		}
+		if !found {
+			return fmt.Errorf("-group-by: unknown dimension %s", dimension)
+		}
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	buckets, err := peer.Aggregate(groupBy, filter)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(buckets)
+	}
+
+	var header []string
+	for _, dimension := range groupBy {
+		header = append(header, strings.ToUpper(dimension))
+	}
+	header = append(header, "COUNT")
+
+	var rows [][]string
+	for _, bucket := range buckets {
+		var row []string
+		for _, dimension := range groupBy {
+			row = append(row, bucket.Key[dimension])
+		}
+		rows = append(rows, append(row, strconv.FormatInt(bucket.Count, 10)))
+	}
+
+	return printTable(header, rows)
+}
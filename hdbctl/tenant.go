@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func tenantReport(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("tenant report", flag.ExitOnError)
+	var tenants stringList
+	fs.Var(&tenants, "tenant", "tenants to report on, all when empty")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	summaries, err := peer.TenantReport(tenants)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(summaries)
+	}
+
+	var rows [][]string
+	for _, s := range summaries {
+		tenant := s.Tenant
+		if tenant == client.DEFAULT_TENANT {
+			tenant = "(default)"
+		}
+		rows = append(rows, []string{tenant, strconv.FormatInt(s.ActivityCount, 10), strconv.Itoa(s.Kiosks),
+			strconv.Itoa(s.RegisteredKiosks), strconv.Itoa(s.OpenAlerts), strconv.Itoa(s.ActiveReservations)})
+	}
+
+	return printTable([]string{"TENANT", "ACTIVITIES", "KIOSKS", "REGISTERED", "OPEN ALERTS", "RESERVATIONS"}, rows)
+}
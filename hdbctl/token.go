@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/khoazany/smart/client"
+)
+
+func tokenAwards(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("token awards", flag.ExitOnError)
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	config, err := peer.ViewTokenAwards()
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(config)
+	}
+
+	var activityTypes []string
+	for activityType := range config.Awards {
+		activityTypes = append(activityTypes, activityType)
+	}
+	sort.Strings(activityTypes)
+
+	var rows [][]string
+	for _, activityType := range activityTypes {
+		rows = append(rows, []string{activityType, strconv.FormatInt(config.Awards[activityType], 10)})
+	}
+
+	return printTable([]string{"ACTIVITY TYPE", "AWARD"}, rows)
+}
+
+func tokenAward(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("token award", flag.ExitOnError)
+	activityType := fs.String("type", "", "activity type (required)")
+	amount := fs.Int64("amount", 0, "tokens credited to the actor per activity, 0 stops the award")
+	fs.Parse(args)
+
+	if *activityType == "" {
+		return errors.New("-type is required")
+	}
+	if *amount < 0 {
+		return errors.New("-amount must not be negative")
+	}
+
+	txId, err := peer.SetTokenAward(*activityType, *amount)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
+
+func tokenBalance(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("token balance", flag.ExitOnError)
+	account := fs.String("account", "", "account id, actorType:telephone (required)")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *account == "" {
+		return errors.New("-account is required")
+	}
+
+	balance, err := peer.TokenBalance(*account)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(balance)
+	}
+
+	return printTable([]string{"ACCOUNT", "BALANCE", "NONCE"},
+		[][]string{{balance.AccountId, strconv.FormatInt(balance.Balance, 10), strconv.FormatInt(balance.Nonce, 10)}})
+}
+
+func tokenStatement(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("token statement", flag.ExitOnError)
+	account := fs.String("account", "", "account id, actorType:telephone (required)")
+	output := fs.String("o", OUTPUT_TABLE, "output format: table or json")
+	fs.Parse(args)
+
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *account == "" {
+		return errors.New("-account is required")
+	}
+
+	statement, err := peer.TokenStatement(*account)
+	if err != nil {
+		return err
+	}
+
+	if *output == OUTPUT_JSON {
+		return printJSON(statement)
+	}
+
+	var rows [][]string
+	for _, e := range statement.Entries {
+		activityId := ""
+		if e.ActivityId != nil {
+			activityId = strconv.FormatInt(*e.ActivityId, 10)
+		}
+		rows = append(rows, []string{formatMillis(e.Timestamp), e.Kind, strconv.FormatInt(e.Amount, 10),
+			strconv.FormatInt(e.Balance, 10), activityId, e.Counterparty})
+	}
+
+	return printTable([]string{"TIME", "KIND", "AMOUNT", "BALANCE", "ACTIVITY", "COUNTERPARTY"}, rows)
+}
+
+func tokenTransfer(peer *client.Peer, args []string) error {
+	fs := flag.NewFlagSet("token transfer", flag.ExitOnError)
+	from := fs.String("from", "", "account debited (required)")
+	to := fs.String("to", "", "account credited (required)")
+	amount := fs.Int64("amount", 0, "tokens to transfer (required)")
+	nonce := fs.Int64("nonce", 0, "nonce of the from account plus one, read from token balance when omitted")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		return errors.New("-from and -to are required")
+	}
+	if *amount <= 0 {
+		return errors.New("-amount must be positive")
+	}
+
+	if *nonce == 0 {
+		balance, err := peer.TokenBalance(*from)
+		if err != nil {
+			return err
+		}
+		*nonce = balance.Nonce + 1
+	}
+
+	txId, err := peer.TransferTokens(*from, *to, *amount, *nonce)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("submitted transaction %s\n", txId)
+	return nil
+}
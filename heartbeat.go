@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The last heartbeat of each kiosk is kept under _kioskHeartbeat_<kioskId>, outside the kiosk registry, so heartbeats
+// of different kiosks never write the same key.
+var kioskHeartbeatPrefix = "_kioskHeartbeat_"
+
+const EVENT_KIOSK_HEARTBEAT = "kiosk.heartbeat"
+
+// Kiosks silent for longer than this are stale when stale_kiosks is called without a threshold.
+const defaultStaleThreshold = time.Hour
+
+type KioskHeartbeat struct {
+	LastHeartbeat int64 `json:"lastHeartbeat"`
+	Firmware string `json:"firmware"`
+	Health map[string]string `json:"health"`
+}
+
+type HeartbeatEvent struct {
+	KioskId string `json:"kioskId"`
+	Firmware string `json:"firmware"`
+	Health map[string]string `json:"health"`
+}
+
+//==============================================================================================================================
+//	StaleKiosk - A kiosk that has not been seen within the threshold. SilentFor is in milliseconds.
+//==============================================================================================================================
+type StaleKiosk struct {
+	KioskRecord
+	SilentFor int64 `json:"silentFor"`
+}
+
+//=================================================================================================================================
+//	 heartbeat - args: kioskId, firmware, health as a JSON object of strings e.g. {"battery":"87","printer":"ok"}.
+//				 Called by the kiosk itself or an admin. Heartbeats are not activities and leave the kiosk registry as
+//				 it is, the kiosk must be known.
+//=================================================================================================================================
+func (t *SimpleChaincode) heartbeat(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 3. kioskId, firmware and health")
+	}
+
+	if !actsForKiosk(caller, caller_affiliation, args[0]) {
+		log_warning(stub, "Permission Denied", "kioskId", args[0]); return nil, permissionDenied("Permission Denied")
+	}
+
+	var health map[string]string
+	if args[2] != "" {
+		err := json.Unmarshal([]byte(args[2]), &health)
+		if err != nil { log_warning(stub, "Invalid health argument", "error", err); return nil, invalidArgument(2, "health", "Invalid health argument. Expecting a JSON object of strings") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	if _, ok := kiosks.Kiosks[args[0]]; !ok {
+		return nil, notFound("Kiosk " + args[0] + " not found. Register it or record an activity at it first")
+	}
+
+	heartbeat := KioskHeartbeat{LastHeartbeat: makeTimestamp(stub), Firmware: args[1], Health: health}
+
+	heartbeatAsBytes, err := json.Marshal(heartbeat)
+	if err != nil { log_failure(stub, "Failed to convert heartbeat", err); return nil, internalError("Failed to convert heartbeat", err) }
+
+	err = stub.PutState(kioskHeartbeatPrefix+args[0], heartbeatAsBytes)
+	if err != nil { log_failure(stub, "Failed to save heartbeat", err); return nil, internalError("Failed to save heartbeat", err) }
+
+	err = set_event(stub, EVENT_KIOSK_HEARTBEAT, HeartbeatEvent{KioskId: args[0], Firmware: heartbeat.Firmware, Health: heartbeat.Health})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 stale_kiosks - args[0] is an optional threshold such as "90m" or "24h", default one hour. Lists the kiosks not seen,
+//					by heartbeat or activity, within the threshold, longest silent first.
+//=================================================================================================================================
+func (t *SimpleChaincode) stale_kiosks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	threshold := defaultStaleThreshold
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		threshold, err = time.ParseDuration(args[0])
+		if err != nil || threshold <= 0 { log_warning(stub, "Invalid threshold", "threshold", args[0]); return nil, invalidArgument(0, "threshold", "Invalid threshold. Expecting a duration such as 90m or 24h") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	err = load_heartbeats(stub, &kiosks)
+	if err != nil { log_failure(stub, "Failed to retrieve heartbeats", err); return nil, internalError("Failed to retrieve heartbeats", err) }
+
+	now := makeTimestamp(stub)
+	cutoff := now - int64(threshold/time.Millisecond)
+
+	result := []StaleKiosk{}
+	for _, record := range kiosks.Kiosks {
+		if record.LastSeen >= cutoff {
+			continue
+		}
+
+		record.History = nil
+		result = append(result, StaleKiosk{KioskRecord: record, SilentFor: now - record.LastSeen})
+	}
+	sort.Sort(bySilentFor(result))
+
+	return json.Marshal(result)
+}
+
+// load_heartbeats sets the last heartbeat, firmware and health of the registered kiosks, moving LastSeen up to the last
+// heartbeat.
+func load_heartbeats(stub shim.ChaincodeStubInterface, kiosks *AllKiosks) error {
+	iter, err := stub.RangeQueryState(kioskHeartbeatPrefix, kioskHeartbeatPrefix+"~")
+	if err != nil { return err }
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, heartbeatAsBytes, err := iter.Next()
+		if err != nil { return err }
+
+		record, ok := kiosks.Kiosks[strings.TrimPrefix(key, kioskHeartbeatPrefix)]
+		if !ok {
+			continue
+		}
+
+		var heartbeat KioskHeartbeat
+		err = json.Unmarshal(heartbeatAsBytes, &heartbeat)
+		if err != nil { return corruptState(key, "Corrupt heartbeat record") }
+
+		record.LastHeartbeat = heartbeat.LastHeartbeat
+		record.Firmware = heartbeat.Firmware
+		record.Health = heartbeat.Health
+		if heartbeat.LastHeartbeat > record.LastSeen {
+			record.LastSeen = heartbeat.LastHeartbeat
+		}
+		kiosks.Kiosks[record.KioskId] = record
+	}
+
+	return nil
+}
+
+type bySilentFor []StaleKiosk
+
+func (a bySilentFor) Len() int      { return len(a) }
+func (a bySilentFor) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySilentFor) Less(i, j int) bool {
+	if a[i].SilentFor != a[j].SilentFor {
+		return a[i].SilentFor > a[j].SilentFor
+	}
+	return a[i].KioskId < a[j].KioskId
+}
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const EVENT_KIOSK_REGISTERED = "kiosk.registered"
+
+//=================================================================================================================================
+//	 register_kiosk - Admin only. args: kioskId, latitude, longitude, details and optionally slotCapacity, the number of
+//					  reservable slots. Registering an existing kiosk updates it, keeping its capacity when none is given.
+//=================================================================================================================================
+func (t *SimpleChaincode) register_kiosk(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 4 && len(args) != 5 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 4 or 5. kioskId, latitude, longitude, details and slotCapacity")
+	}
+
+	if args[0] == "" {
+		return nil, invalidArgument(0, "kioskId", "Kiosk id must not be empty")
+	}
+
+	latitude, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || !validLatitude(latitude) { log_warning(stub, "Invalid latitude", "latitude", args[1]); return nil, invalidArgument(1, "latitude", "Invalid latitude format") }
+	longitude, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || !validLongitude(longitude) { log_warning(stub, "Invalid longitude", "longitude", args[2]); return nil, invalidArgument(2, "longitude", "Invalid longitude format") }
+
+	slotCapacity := -1
+	if len(args) == 5 && args[4] != "" {
+		slotCapacity, err = strconv.Atoi(args[4])
+		if err != nil || slotCapacity < 0 { log_warning(stub, "Invalid slot capacity", "slotCapacity", args[4]); return nil, invalidArgument(4, "slotCapacity", "Invalid slot capacity. Expecting a non-negative integer") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	timestamp := makeTimestamp(stub)
+
+	record, existed := kiosks.Kiosks[args[0]]
+	if !existed {
+		record = KioskRecord{KioskId: args[0], FirstSeen: timestamp}
+	}
+
+	err = move_kiosk(stub, &record, latitude, longitude)
+	if err != nil { log_failure(stub, "Failed to index kiosk location", err); return nil, internalError("Failed to index kiosk location", err) }
+
+	record.Details = args[3]
+	record.Registered = true
+	record.RegisteredAt = timestamp
+	if slotCapacity >= 0 {
+		record.SlotCapacity = slotCapacity
+	}
+	record.revise(timestamp)
+	kiosks.Kiosks[record.KioskId] = record
+
+	err = put_kiosks(stub, kiosks)
+	if err != nil { log_failure(stub, "Failed to save kiosks", err); return nil, internalError("Failed to save kiosks", err) }
+
+	err = set_event(stub, EVENT_KIOSK_REGISTERED, record)
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(record)
+}
+
+//=================================================================================================================================
+//	 view_kiosks - args[0] is an optional JSON array of kiosk ids, empty returns every kiosk. Sorted by kiosk id.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_kiosks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var kioskIds []string
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &kioskIds)
+		if err != nil { log_warning(stub, "Invalid kioskIds argument", "error", err); return nil, invalidArgument(0, "kioskIds", "Invalid kioskIds argument") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	err = load_heartbeats(stub, &kiosks)
+	if err != nil { log_failure(stub, "Failed to retrieve heartbeats", err); return nil, internalError("Failed to retrieve heartbeats", err) }
+
+	result := []KioskRecord{}
+	for kioskId, kiosk := range kiosks.Kiosks {
+		if len(kioskIds) > 0 && !containsString(kioskIds, kioskId) {
+			continue
+		}
+		result = append(result, kiosk)
+	}
+	sort.Sort(byKioskId(result))
+
+	return json.Marshal(result)
+}
+
+// actsForKiosk tells whether the caller may act on behalf of the kiosk: an admin, or the kiosk itself, enrolled with
+// the kiosk role under its kiosk id.
+func actsForKiosk(caller string, caller_affiliation string, kioskId string) bool {
+	return caller_affiliation == ADMIN || (caller_affiliation == KIOSK && caller != "" && caller == kioskId)
+}
+
+type byKioskId []KioskRecord
+
+func (a byKioskId) Len() int           { return len(a) }
+func (a byKioskId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byKioskId) Less(i, j int) bool { return a[i].KioskId < a[j].KioskId }
@@ -0,0 +1,354 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The log level is shared by every tenant, so it is kept outside the tenant prefixes like _tenants.
+var logLevelStr = "_logLevel"
+
+const defaultLogLevel = shim.LogInfo
+
+var logLevelNames = map[shim.LoggingLevel]string{
+	shim.LogDebug: "DEBUG",
+	shim.LogInfo: "INFO",
+	shim.LogNotice: "NOTICE",
+	shim.LogWarning: "WARNING",
+	shim.LogError: "ERROR",
+	shim.LogCritical: "CRITICAL",
+}
+
+// Log lines are written here, one JSON object per line.
+var logOutput io.Writer = os.Stdout
+
+// Field names whose values are replaced by a digest, see redact.
+var piiFields = map[string]bool{"name": true, "telephone": true, "email": true, "holder": true, "actor": true}
+
+var emailPattern = regexp.MustCompile(`[^\s@"]+@[^\s@"]+\.[A-Za-z]{2,}`)
+
+// An international number with a leading +, or a local one of 8 digits, optionally split in the middle. Dates, times,
+// epoch timestamps and longer ids are left alone.
+var telephonePattern = regexp.MustCompile(`\+\d[\d -]{6,}\d|\b\d{4}[ -]?\d{4}\b`)
+
+// Digests of redacted values are keyed with HDB_LOG_KEY from the chaincode's environment, so they cannot be reversed by
+// hashing every telephone number. Without it a random key is used and digests only match within one process.
+var redactionKey = loadRedactionKey()
+
+//==============================================================================================================================
+//	CallContext - Tags every log line of a transaction with the function it called and who called it.
+//==============================================================================================================================
+type CallContext struct {
+	Function string
+	Caller string
+	Tenant string
+	Level shim.LoggingLevel
+}
+
+type LogEntry struct {
+	Time string `json:"time"`
+	Level string `json:"level"`
+	Function string `json:"function,omitempty"`
+	TxId string `json:"txId,omitempty"`
+	Caller string `json:"caller,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Message string `json:"message"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+type LogLevelChange struct {
+	Previous string `json:"previous"`
+	Level string `json:"level"`
+}
+
+// loggingStub carries the CallContext of the transaction to the log functions, through the tenantStub wrapping it.
+type loggingStub struct {
+	shim.ChaincodeStubInterface
+	call CallContext
+}
+
+//=================================================================================================================================
+//	 log_call - Wraps the stub of a transaction so every line it logs is tagged with the call, at the stored log level.
+//				The stub is wrapped at the default level when the level cannot be read.
+//=================================================================================================================================
+func log_call(stub shim.ChaincodeStubInterface, function string, caller string, tenant string) (shim.ChaincodeStubInterface, error) {
+	level, err := get_log_level(stub)
+	call := CallContext{Function: function, Caller: caller, Tenant: tenant, Level: level}
+	return &loggingStub{ChaincodeStubInterface: stub, call: call}, err
+}
+
+//=================================================================================================================================
+//	 set_log_level - Super admin only. args[0] is DEBUG, INFO, NOTICE, WARNING, ERROR or CRITICAL. Applies to every tenant from
+//					 the next transaction on. Returns the previous and the new level.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_log_level(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != SUPER_ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	level, err := parseLogLevel(args[0])
+	if err != nil { log_warning(stub, "Invalid log level", "level", args[0]); return nil, err }
+
+	// a corrupt level is overwritten, the transaction has been logging at the default level
+	previous, err := get_log_level(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve log level", err) }
+
+	err = stub.PutState(logLevelStr, []byte(logLevelNames[level]))
+	if err != nil { log_failure(stub, "Failed to save log level", err); return nil, internalError("Failed to save log level", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: logLevelStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	log_notice(stub, "Log level changed", "previous", logLevelNames[previous], "level", logLevelNames[level])
+
+	return json.Marshal(LogLevelChange{Previous: logLevelNames[previous], Level: logLevelNames[level]})
+}
+
+func get_log_level(stub shim.ChaincodeStubInterface) (shim.LoggingLevel, error) {
+	levelAsBytes, err := stub.GetState(logLevelStr)
+	if err != nil { return defaultLogLevel, err }
+
+	if len(levelAsBytes) == 0 {
+		return defaultLogLevel, nil
+	}
+
+	level, err := parseLogLevel(string(levelAsBytes))
+	if err != nil { return defaultLogLevel, corruptState(logLevelStr, "Corrupt log level") }
+
+	return level, nil
+}
+
+func parseLogLevel(value string) (shim.LoggingLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(name, value) {
+			return level, nil
+		}
+	}
+	return defaultLogLevel, invalidArgument(0, "level", "Invalid log level " + value + ". Expecting DEBUG, INFO, NOTICE, WARNING, ERROR or CRITICAL")
+}
+
+// ============================================================================================================================
+// LOG FUNCTIONS - fields are name, value pairs. stub may be nil outside a transaction.
+// ============================================================================================================================
+func log_debug(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogDebug, message, fields)
+}
+
+func log_info(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogInfo, message, fields)
+}
+
+func log_notice(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogNotice, message, fields)
+}
+
+func log_warning(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogWarning, message, fields)
+}
+
+func log_error(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogError, message, fields)
+}
+
+func log_critical(stub shim.ChaincodeStubInterface, message string, fields ...interface{}) {
+	write_log(stub, shim.LogCritical, message, fields)
+}
+
+// log_failure logs why a call failed: a rejected call, e.g. an invalid argument or a conflict, as a warning, a failure
+// of the ledger or a corrupt record as an error.
+func log_failure(stub shim.ChaincodeStubInterface, message string, err error) {
+	level := shim.LogError
+	if e, ok := err.(*ChaincodeError); ok && e.Code != ERR_INTERNAL && e.Code != ERR_CORRUPT_STATE {
+		level = shim.LogWarning
+	}
+	write_log(stub, level, message, []interface{}{"error", err})
+}
+
+func write_log(stub shim.ChaincodeStubInterface, level shim.LoggingLevel, message string, fields []interface{}) {
+	call := call_context(stub)
+	if level < call.Level {
+		return
+	}
+
+	entry := LogEntry{Time: time.Now().UTC().Format(time.RFC3339Nano), Level: logLevelNames[level], Function: call.Function,
+		Caller: call.Caller, Tenant: call.Tenant, Message: redactText(message)}
+	if stub != nil {
+		entry.TxId = stub.GetTxID()
+	}
+
+	if len(fields) > 0 {
+		entry.Fields = make(map[string]interface{})
+		for i := 0; i+1 < len(fields); i += 2 {
+			name := fmt.Sprint(fields[i])
+			entry.Fields[name] = redact(name, fields[i+1])
+		}
+	}
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		entryAsBytes = []byte(fmt.Sprintf(`{"level":%q,"message":%q}`, entry.Level, entry.Message))
+	}
+	fmt.Fprintln(logOutput, string(entryAsBytes))
+}
+
+// call_context finds the CallContext of the transaction, a call without one logs untagged at the default level.
+func call_context(stub shim.ChaincodeStubInterface) CallContext {
+	for stub != nil {
+		switch s := stub.(type) {
+		case *loggingStub:
+			return s.call
+		case *tenantStub:
+			stub = s.ChaincodeStubInterface
+		default:
+			stub = nil
+		}
+	}
+	return CallContext{Level: defaultLogLevel}
+}
+
+// redact replaces the value of a PII field by a short digest, so lines about the same actor can still be matched, and
+// scrubs e-mail addresses and telephone numbers out of everything else. Structs, e.g. an Activity or a Consent, slices
+// and maps are walked, their fields named by their json tags, so a nested actor is redacted like a top level one.
+func redact(name string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return redactReflect(name, reflect.ValueOf(value))
+}
+
+func redactReflect(name string, v reflect.Value) interface{} {
+	if v.IsValid() && v.CanInterface() {
+		if err, ok := v.Interface().(error); ok {
+			if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+				return nil
+			}
+			return redactText(err.Error())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redactReflect(name, v.Elem())
+	case reflect.String:
+		if piiFields[name] {
+			return redactValue(v.String())
+		}
+		return redactText(v.String())
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fieldName := strings.Split(field.Tag.Get("json"), ",")[0]
+			if fieldName == "-" {
+				continue
+			}
+			if fieldName == "" {
+				fieldName = field.Name
+			}
+			fields[fieldName] = redactReflect(fieldName, v.Field(i))
+		}
+		// a struct without exported fields, e.g. a time.Time, is logged as it is
+		if len(fields) == 0 && v.NumField() > 0 {
+			break
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return redactLeaf(name, fmt.Sprintf("%s", v.Interface()))
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redactReflect(name, v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		entries := make(map[string]interface{})
+		for _, key := range v.MapKeys() {
+			keyName := fmt.Sprint(key.Interface())
+			entries[keyName] = redactReflect(keyName, v.MapIndex(key))
+		}
+		return entries
+	}
+
+	if piiFields[name] {
+		return redactValue(fmt.Sprint(v.Interface()))
+	}
+	return v.Interface()
+}
+
+// redactLeaf redacts a value logged as text.
+func redactLeaf(name string, text string) string {
+	if piiFields[name] {
+		return redactValue(text)
+	}
+	return redactText(text)
+}
+
+// redactValue returns the first 128 bits of the HMAC-SHA256 of the value under the redaction key.
+func redactValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(value))
+	return "redacted:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func loadRedactionKey() []byte {
+	if key := os.Getenv("HDB_LOG_KEY"); key != "" {
+		return []byte(key)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("Failed to generate the log redaction key: " + err.Error())
+	}
+	return key
+}
+
+func redactText(text string) string {
+	text = emailPattern.ReplaceAllStringFunc(text, redactValue)
+	return telephonePattern.ReplaceAllStringFunc(text, redactValue)
+}
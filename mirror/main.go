@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command mirror replays the chaincode's activities into a local SQLite database for analytical queries.
+//
+// The peer event hub needs the gRPC event protos, so the mirror catches up by querying view_activities for the
+// ActivityIds between its checkpoint and the on-chain activity_count. Every -repair-every cycles it compares the
+// mirrored ids against the contiguous on-chain sequence and fetches any that are missing.
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/khoazany/smart/client"
+)
+
+func main() {
+	peerURL := flag.String("peer", "http://localhost:7050", "peer REST API address")
+	chaincode := flag.String("chaincode", "", "chaincode name (deployment id)")
+	secureContext := flag.String("secure-context", "", "enrolled user to query as, empty when security is disabled")
+	dbPath := flag.String("db", "hdb-mirror.db", "SQLite database file")
+	batchSize := flag.Int("batch", 200, "activities fetched per query")
+	interval := flag.Duration("interval", 10*time.Second, "time between catch-up cycles")
+	repairEvery := flag.Int("repair-every", 30, "run gap detection every n cycles, 0 disables it")
+	once := flag.Bool("once", false, "run a single catch-up and repair cycle and exit")
+	flag.Parse()
+
+	if *chaincode == "" {
+		log.Fatal("mirror: -chaincode is required")
+	}
+
+	store, err := OpenStore(*dbPath)
+	if err != nil {
+		log.Fatalf("mirror: failed to open %s: %s", *dbPath, err)
+	}
+	defer store.Close()
+
+	m := &Mirror{Peer: client.NewPeer(*peerURL, *chaincode, *secureContext), Store: store, BatchSize: *batchSize}
+
+	for cycle := 0; ; cycle++ {
+		if err := m.CatchUp(); err != nil {
+			log.Printf("mirror: catch-up failed: %s", err)
+		}
+
+		if *once || (*repairEvery > 0 && cycle%*repairEvery == 0) {
+			if err := m.Repair(); err != nil {
+				log.Printf("mirror: repair failed: %s", err)
+			}
+		}
+
+		if *once {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
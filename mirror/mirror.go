@@ -0,0 +1,117 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+
+	"github.com/khoazany/smart/client"
+)
+
+type Mirror struct {
+	Peer *client.Peer
+	Store *Store
+	BatchSize int
+}
+
+// CatchUp fetches the activities between the checkpoint and the on-chain activity count in batches, advancing the
+// checkpoint after each batch. Ids the chaincode does not return are left for Repair.
+func (m *Mirror) CatchUp() error {
+	count, err := m.Peer.ActivityCount()
+	if err != nil {
+		return err
+	}
+
+	next, err := m.Store.Checkpoint()
+	if err != nil {
+		return err
+	}
+
+	for next < count {
+		end := next + int64(m.BatchSize)
+		if end > count {
+			end = count
+		}
+
+		activities, err := m.fetch(idRange(next, end))
+		if err != nil {
+			return err
+		}
+
+		if err := m.Store.Save(activities, end, true); err != nil {
+			return err
+		}
+
+		log.Printf("mirror: stored %d activities, checkpoint %d of %d", len(activities), end, count)
+		next = end
+	}
+
+	return nil
+}
+
+// Repair refetches the ids below the checkpoint that are missing from the mirror.
+func (m *Mirror) Repair() error {
+	next, err := m.Store.Checkpoint()
+	if err != nil {
+		return err
+	}
+
+	missing, err := m.Store.MissingIds(next)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	log.Printf("mirror: %d activities missing below checkpoint %d", len(missing), next)
+
+	for start := 0; start < len(missing); start += m.BatchSize {
+		end := start + m.BatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		activities, err := m.fetch(missing[start:end])
+		if err != nil {
+			return err
+		}
+
+		if err := m.Store.Save(activities, next, false); err != nil {
+			return err
+		}
+
+		if len(activities) < end-start {
+			log.Printf("mirror: %d activities could not be recovered from the chaincode", end-start-len(activities))
+		}
+	}
+
+	return nil
+}
+
+func (m *Mirror) fetch(ids []int64) ([]client.Activity, error) {
+	// activities may have been archived on the chaincode since they were created, their telephone and email arrive blank
+	return m.Peer.ViewActivities(client.ActivityFilter{ActivityIds: ids, Options: &client.QueryOptions{IncludeArchived: true}})
+}
+
+func idRange(start, end int64) []int64 {
+	ids := make([]int64, 0, end-start)
+	for id := start; id < end; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/khoazany/smart/client"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const checkpointName = "activities"
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS actors (
+		actor_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		telephone TEXT NOT NULL,
+		email TEXT NOT NULL,
+		UNIQUE (actor_type, name, telephone, email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS kiosks (
+		kiosk_id TEXT PRIMARY KEY,
+		latitude REAL NOT NULL,
+		longitude REAL NOT NULL,
+		details TEXT NOT NULL,
+		last_activity_id INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS devices (
+		device_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_type TEXT NOT NULL,
+		id1 TEXT NOT NULL,
+		id2 TEXT NOT NULL,
+		id3 TEXT NOT NULL,
+		id4 TEXT NOT NULL,
+		UNIQUE (device_type, id1, id2, id3, id4)
+	)`,
+	`CREATE TABLE IF NOT EXISTS activities (
+		activity_id INTEGER PRIMARY KEY,
+		activity_type TEXT NOT NULL,
+		actor_id INTEGER NOT NULL REFERENCES actors (actor_id),
+		kiosk_id TEXT NOT NULL REFERENCES kiosks (kiosk_id),
+		device_id INTEGER NOT NULL REFERENCES devices (device_id),
+		remark TEXT NOT NULL,
+		timestamp INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS activities_timestamp ON activities (timestamp)`,
+	`CREATE TABLE IF NOT EXISTS resources (
+		activity_id INTEGER NOT NULL REFERENCES activities (activity_id),
+		position INTEGER NOT NULL,
+		resource_owner TEXT NOT NULL,
+		resource_type TEXT NOT NULL,
+		resource_id TEXT NOT NULL,
+		details TEXT NOT NULL,
+		PRIMARY KEY (activity_id, position)
+	)`,
+	`CREATE INDEX IF NOT EXISTS resources_resource_id ON resources (resource_id)`,
+	`CREATE TABLE IF NOT EXISTS checkpoints (
+		name TEXT PRIMARY KEY,
+		next_activity_id INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+}
+
+//==============================================================================================================================
+//	Store - The SQLite mirror. Activities are written together with the checkpoint in one transaction so a restart
+//			resumes after the last activity that was fully stored.
+//==============================================================================================================================
+type Store struct {
+	db *sql.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Checkpoint returns the ActivityId the next catch-up starts from.
+func (s *Store) Checkpoint() (int64, error) {
+	var next int64
+	err := s.db.QueryRow(`SELECT next_activity_id FROM checkpoints WHERE name = ?`, checkpointName).Scan(&next)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return next, err
+}
+
+// Save stores the activities and, when advance is set, moves the checkpoint to next.
+func (s *Store) Save(activities []client.Activity, next int64, advance bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, activity := range activities {
+		if err := saveActivity(tx, activity); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if advance {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO checkpoints (name, next_activity_id, updated_at) VALUES (?, ?, ?)`,
+			checkpointName, next, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func saveActivity(tx *sql.Tx, activity client.Activity) error {
+	actorId, err := upsertId(tx,
+		`INSERT OR IGNORE INTO actors (actor_type, name, telephone, email) VALUES (?, ?, ?, ?)`,
+		`SELECT actor_id FROM actors WHERE actor_type = ? AND name = ? AND telephone = ? AND email = ?`,
+		activity.Actor.ActorType, activity.Actor.Name, activity.Actor.Telephone, activity.Actor.Email)
+	if err != nil {
+		return err
+	}
+
+	deviceId, err := upsertId(tx,
+		`INSERT OR IGNORE INTO devices (device_type, id1, id2, id3, id4) VALUES (?, ?, ?, ?, ?)`,
+		`SELECT device_id FROM devices WHERE device_type = ? AND id1 = ? AND id2 = ? AND id3 = ? AND id4 = ?`,
+		activity.Device.DeviceType, activity.Device.Id1, activity.Device.Id2, activity.Device.Id3, activity.Device.Id4)
+	if err != nil {
+		return err
+	}
+
+	// kiosks keep the attributes reported by the latest activity, repairs of older gaps do not overwrite them
+	_, err = tx.Exec(`INSERT OR IGNORE INTO kiosks (kiosk_id, latitude, longitude, details, last_activity_id) VALUES (?, ?, ?, ?, ?)`,
+		activity.Kiosk.KioskId, activity.Kiosk.Latitude, activity.Kiosk.Longitude, activity.Kiosk.Details, activity.ActivityId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE kiosks SET latitude = ?, longitude = ?, details = ?, last_activity_id = ?
+		WHERE kiosk_id = ? AND last_activity_id < ?`,
+		activity.Kiosk.Latitude, activity.Kiosk.Longitude, activity.Kiosk.Details, activity.ActivityId, activity.Kiosk.KioskId, activity.ActivityId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO activities (activity_id, activity_type, actor_id, kiosk_id, device_id, remark, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		activity.ActivityId, activity.ActivityType, actorId, activity.Kiosk.KioskId, deviceId, activity.Remark, activity.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM resources WHERE activity_id = ?`, activity.ActivityId)
+	if err != nil {
+		return err
+	}
+
+	for position, resource := range activity.Resources {
+		_, err = tx.Exec(`INSERT INTO resources (activity_id, position, resource_owner, resource_type, resource_id, details)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			activity.ActivityId, position, resource.ResourceOwner, resource.ResourceType, resource.ResourceId, resource.Details)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func upsertId(tx *sql.Tx, insert string, query string, args ...interface{}) (int64, error) {
+	if _, err := tx.Exec(insert, args...); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
+
+// MissingIds returns the ActivityIds below next that are not in the mirror.
+func (s *Store) MissingIds(next int64) ([]int64, error) {
+	rows, err := s.db.Query(`SELECT activity_id FROM activities WHERE activity_id < ? ORDER BY activity_id`, next)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []int64
+	expected := int64(0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		for ; expected < id; expected++ {
+			missing = append(missing, expected)
+		}
+		expected = id + 1
+	}
+	for ; expected < next; expected++ {
+		missing = append(missing, expected)
+	}
+
+	return missing, rows.Err()
+}
@@ -0,0 +1,391 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var reservationsStr = "_reservations"
+
+// ============================================================================================================================
+// RESERVATION STATUS - held -> occupied -> completed. A held slot may also be released, or expire when no deposit
+//						arrives before ExpiresAt. Held and occupied reservations take a slot.
+// ============================================================================================================================
+const RESERVATION_HELD = "held"
+const RESERVATION_OCCUPIED = "occupied"
+const RESERVATION_COMPLETED = "completed"
+const RESERVATION_RELEASED = "released"
+const RESERVATION_EXPIRED = "expired"
+
+// Activity types linked to a reservation of their resource at the same kiosk.
+const RESERVATION_DEPOSIT_TYPE = "deposit"
+const RESERVATION_COLLECT_TYPE = "collect"
+
+const defaultHoldDuration = 30 * time.Minute
+
+const EVENT_SLOT_RESERVED = "slot.reserved"
+const EVENT_RESERVATION_RELEASED = "reservation.released"
+const EVENT_RESERVATIONS_EXPIRED = "reservations.expired"
+
+//==============================================================================================================================
+//	Reservation - A slot held at a kiosk for a resource. The deposit and collect activities of the resource at the kiosk
+//				  are linked by create_activity.
+//==============================================================================================================================
+type Reservation struct {
+	ReservationId int64 `json:"reservationId"`
+	KioskId string `json:"kioskId"`
+	ResourceId string `json:"resourceId"`
+	Holder string `json:"holder"`
+	Status string `json:"status"`
+	ReservedBy string `json:"reservedBy"`
+	ReservedAt int64 `json:"reservedAt"`
+	ExpiresAt int64 `json:"expiresAt"`						// a held reservation expires at this time
+	DepositActivityId *int64 `json:"depositActivityId,omitempty"`
+	CollectActivityId *int64 `json:"collectActivityId,omitempty"`
+	ClosedAt int64 `json:"closedAt,omitempty"`				// completed, released or expired
+}
+
+//==============================================================================================================================
+//	AllReservations - Reservations in the order they were made, ReservationId is the index.
+//==============================================================================================================================
+type AllReservations struct {
+	Reservations []Reservation `json:"reservations"`
+}
+
+type SlotAvailability struct {
+	KioskId string `json:"kioskId"`
+	SlotCapacity int `json:"slotCapacity"`
+	Held int `json:"held"`
+	Occupied int `json:"occupied"`
+	Free int `json:"free"`
+}
+
+type ReservationEvent struct {
+	ReservationId int64 `json:"reservationId"`
+	KioskId string `json:"kioskId"`
+	ResourceId string `json:"resourceId"`
+	Status string `json:"status"`
+}
+
+type ReservationsExpiredEvent struct {
+	ReservationIds []int64 `json:"reservationIds"`
+}
+
+//=================================================================================================================================
+//	 reserve_slot - Called by the kiosk, an admin or the holder, whose enrollment id is the holder. args: kioskId,
+//					resourceId, holder, hold duration such as 45m, empty for 30m. Fails when the kiosk has no free slot
+//					or the resource already has a held or occupied reservation.
+//=================================================================================================================================
+func (t *SimpleChaincode) reserve_slot(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 4. kioskId, resourceId, holder and hold duration")
+	}
+
+	if !actsForKiosk(caller, caller_affiliation, args[0]) && (caller == "" || caller != args[2]) {
+		log_warning(stub, "Permission Denied", "kioskId", args[0]); return nil, permissionDenied("Permission Denied")
+	}
+
+	if args[0] == "" || args[1] == "" {
+		return nil, invalidArgument(1, "", "Kiosk id and resource id must not be empty")
+	}
+
+	hold := defaultHoldDuration
+	if args[3] != "" {
+		var err error
+		hold, err = time.ParseDuration(args[3])
+		if err != nil || hold <= 0 { log_warning(stub, "Invalid hold duration", "holdDuration", args[3]); return nil, invalidArgument(3, "holdDuration", "Invalid hold duration. Expecting a duration such as 45m") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	record, ok := kiosks.Kiosks[args[0]]
+	if !ok || record.SlotCapacity <= 0 {
+		return nil, conflict("Kiosk " + args[0] + " has no reservable slots")
+	}
+
+	reservations, err := get_reservations(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve reservations", err); return nil, internalError("Failed to retrieve reservations", err) }
+
+	now := makeTimestamp(stub)
+	reservations.expire(now)
+
+	for _, reservation := range reservations.Reservations {
+		if reservation.active() && reservation.ResourceId == args[1] {
+			return nil, conflict("Resource " + args[1] + " is already reserved by reservation " + strconv.FormatInt(reservation.ReservationId, 10))
+		}
+	}
+
+	availability := reservations.availability(record)
+	if availability.Free <= 0 {
+		return nil, conflict("No free slot at kiosk " + args[0])
+	}
+
+	reservation := Reservation{ReservationId: int64(len(reservations.Reservations)), KioskId: args[0], ResourceId: args[1], Holder: args[2],
+		Status: RESERVATION_HELD, ReservedBy: caller, ReservedAt: now, ExpiresAt: now + int64(hold/time.Millisecond)}
+	reservations.Reservations = append(reservations.Reservations, reservation)
+
+	err = put_reservations(stub, reservations)
+	if err != nil { log_failure(stub, "Failed to save reservations", err); return nil, internalError("Failed to save reservations", err) }
+
+	err = set_event(stub, EVENT_SLOT_RESERVED, reservation.event())
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(reservation)
+}
+
+//=================================================================================================================================
+//	 release_reservation - args[0] is the reservationId. Frees the slot of a held reservation, only the identity that
+//						   reserved it or an admin may.
+//=================================================================================================================================
+func (t *SimpleChaincode) release_reservation(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. reservationId")
+	}
+
+	reservationId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil { log_warning(stub, "Invalid reservationId", "error", err); return nil, invalidArgument(0, "reservationId", "Invalid reservationId") }
+
+	reservations, err := get_reservations(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve reservations", err); return nil, internalError("Failed to retrieve reservations", err) }
+
+	if reservationId < 0 || reservationId >= int64(len(reservations.Reservations)) {
+		return nil, notFound("Reservation " + args[0] + " not found")
+	}
+
+	now := makeTimestamp(stub)
+	reservations.expire(now)
+
+	reservation := &reservations.Reservations[reservationId]
+	if caller_affiliation != ADMIN && (caller == "" || caller != reservation.ReservedBy) {
+		log_warning(stub, "Permission Denied", "reservationId", reservationId); return nil, permissionDenied("Permission Denied")
+	}
+
+	if reservation.Status != RESERVATION_HELD {
+		return nil, conflict("Reservation " + args[0] + " is " + reservation.Status + ", only a held reservation can be released")
+	}
+
+	reservation.Status = RESERVATION_RELEASED
+	reservation.ClosedAt = now
+
+	err = put_reservations(stub, reservations)
+	if err != nil { log_failure(stub, "Failed to save reservations", err); return nil, internalError("Failed to save reservations", err) }
+
+	err = set_event(stub, EVENT_RESERVATION_RELEASED, reservation.event())
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(reservation)
+}
+
+//=================================================================================================================================
+//	 expire_reservations - Marks the held reservations past ExpiresAt as expired and returns them. Reads treat them as
+//						   expired already, this records it, e.g. from a scheduler.
+//=================================================================================================================================
+func (t *SimpleChaincode) expire_reservations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	reservations, err := get_reservations(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve reservations", err); return nil, internalError("Failed to retrieve reservations", err) }
+
+	expired := reservations.expire(makeTimestamp(stub))
+
+	ids := []int64{}
+	for _, reservation := range expired {
+		ids = append(ids, reservation.ReservationId)
+	}
+
+	if len(expired) > 0 {
+		err = put_reservations(stub, reservations)
+		if err != nil { log_failure(stub, "Failed to save reservations", err); return nil, internalError("Failed to save reservations", err) }
+
+		err = set_event(stub, EVENT_RESERVATIONS_EXPIRED, ReservationsExpiredEvent{ReservationIds: ids})
+		if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+	}
+
+	return json.Marshal(expired)
+}
+
+//=================================================================================================================================
+//	 view_reservations - args: optional JSON arrays of statuses and kioskIds, empty matches everything. The holder, a
+//						 telephone or email, is blanked, and so is ReservedBy when the holder reserved.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_reservations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var statuses, kioskIds []string
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &statuses)
+		if err != nil { log_warning(stub, "Invalid statuses argument", "error", err); return nil, invalidArgument(0, "statuses", "Invalid statuses argument") }
+	}
+	if len(args) > 1 && args[1] != "" {
+		err := json.Unmarshal([]byte(args[1]), &kioskIds)
+		if err != nil { log_warning(stub, "Invalid kioskIds argument", "error", err); return nil, invalidArgument(1, "kioskIds", "Invalid kioskIds argument") }
+	}
+
+	reservations, err := get_reservations(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve reservations", err); return nil, internalError("Failed to retrieve reservations", err) }
+
+	reservations.expire(makeTimestamp(stub))
+
+	result := []Reservation{}
+	for _, reservation := range reservations.Reservations {
+		if (len(statuses) > 0 && !containsString(statuses, reservation.Status)) ||
+			(len(kioskIds) > 0 && !containsString(kioskIds, reservation.KioskId)) {
+			continue
+		}
+		if reservation.ReservedBy == reservation.Holder {
+			reservation.ReservedBy = ""
+		}
+		reservation.Holder = ""
+		result = append(result, reservation)
+	}
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 slot_availability - args[0] is the kioskId. Returns the capacity and the held, occupied and free slots.
+//=================================================================================================================================
+func (t *SimpleChaincode) slot_availability(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. kioskId")
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve kiosks", err); return nil, internalError("Failed to retrieve kiosks", err) }
+
+	record, ok := kiosks.Kiosks[args[0]]
+	if !ok {
+		return nil, notFound("Kiosk " + args[0] + " not found")
+	}
+
+	reservations, err := get_reservations(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve reservations", err); return nil, internalError("Failed to retrieve reservations", err) }
+
+	reservations.expire(makeTimestamp(stub))
+
+	return json.Marshal(reservations.availability(record))
+}
+
+//=================================================================================================================================
+//	 link_reservations - Links a new activity to the reservations of its resources at its kiosk. A deposit occupies a
+//						 held slot, a collect completes an occupied one. Sets Reservations on the activity.
+//=================================================================================================================================
+func link_reservations(stub shim.ChaincodeStubInterface, activity *Activity) error {
+	if activity.ActivityType != RESERVATION_DEPOSIT_TYPE && activity.ActivityType != RESERVATION_COLLECT_TYPE {
+		return nil
+	}
+
+	reservations, err := get_reservations(stub)
+	if err != nil { return err }
+
+	expired := reservations.expire(activity.Timestamp)
+	linked := len(expired) > 0
+
+	activityId := activity.ActivityId
+	for i := range reservations.Reservations {
+		reservation := &reservations.Reservations[i]
+		if reservation.KioskId != activity.Kiosk.KioskId || !hasResource(*activity, reservation.ResourceId) {
+			continue
+		}
+
+		switch {
+		case activity.ActivityType == RESERVATION_DEPOSIT_TYPE && reservation.Status == RESERVATION_HELD:
+			reservation.Status = RESERVATION_OCCUPIED
+			reservation.DepositActivityId = &activityId
+		case activity.ActivityType == RESERVATION_COLLECT_TYPE && reservation.Status == RESERVATION_OCCUPIED:
+			reservation.Status = RESERVATION_COMPLETED
+			reservation.CollectActivityId = &activityId
+			reservation.ClosedAt = activity.Timestamp
+		default:
+			continue
+		}
+
+		activity.Reservations = append(activity.Reservations, reservation.ReservationId)
+		linked = true
+	}
+
+	if !linked {
+		return nil
+	}
+	return put_reservations(stub, reservations)
+}
+
+// expire marks the held reservations whose hold ran out by now as expired and returns them.
+func (reservations *AllReservations) expire(now int64) []Reservation {
+	expired := []Reservation{}
+	for i := range reservations.Reservations {
+		reservation := &reservations.Reservations[i]
+		if reservation.Status == RESERVATION_HELD && reservation.ExpiresAt <= now {
+			reservation.Status = RESERVATION_EXPIRED
+			reservation.ClosedAt = reservation.ExpiresAt
+			expired = append(expired, *reservation)
+		}
+	}
+	return expired
+}
+
+func (reservations *AllReservations) availability(record KioskRecord) SlotAvailability {
+	availability := SlotAvailability{KioskId: record.KioskId, SlotCapacity: record.SlotCapacity}
+	for _, reservation := range reservations.Reservations {
+		if reservation.KioskId != record.KioskId {
+			continue
+		}
+		switch reservation.Status {
+		case RESERVATION_HELD:
+			availability.Held++
+		case RESERVATION_OCCUPIED:
+			availability.Occupied++
+		}
+	}
+
+	availability.Free = availability.SlotCapacity - availability.Held - availability.Occupied
+	if availability.Free < 0 {
+		availability.Free = 0
+	}
+	return availability
+}
+
+func (reservation Reservation) active() bool {
+	return reservation.Status == RESERVATION_HELD || reservation.Status == RESERVATION_OCCUPIED
+}
+
+func (reservation Reservation) event() ReservationEvent {
+	return ReservationEvent{ReservationId: reservation.ReservationId, KioskId: reservation.KioskId, ResourceId: reservation.ResourceId, Status: reservation.Status}
+}
+
+func get_reservations(stub shim.ChaincodeStubInterface) (AllReservations, error) {
+	var reservations AllReservations
+
+	reservationsAsBytes, err := stub.GetState(reservationsStr)
+	if err != nil { return reservations, err }
+
+	if len(reservationsAsBytes) > 0 {
+		err = json.Unmarshal(reservationsAsBytes, &reservations)
+		if err != nil { return reservations, corruptState(reservationsStr, "Corrupt reservations record") }
+	}
+
+	return reservations, nil
+}
+
+func put_reservations(stub shim.ChaincodeStubInterface, reservations AllReservations) error {
+	reservationsAsBytes, err := json.Marshal(reservations)
+	if err != nil { return err }
+
+	return stub.PutState(reservationsStr, reservationsAsBytes)
+}
@@ -0,0 +1,334 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var rulesStr = "_rules"
+
+// ============================================================================================================================
+// RULE TYPES
+// ============================================================================================================================
+const RULE_IMPOSSIBLE_TRAVEL = "impossible_travel"			// the actor's previous activity is too far away to have travelled
+const RULE_COLLECT_BEFORE_DEPOSIT = "collect_before_deposit"	// a resource is collected while not deposited
+
+// ============================================================================================================================
+// RULE ACTIONS
+// ============================================================================================================================
+const RULE_FLAG = "flag"									// record the activity and raise an alert
+const RULE_REJECT = "reject"								// fail create_activity
+
+const defaultMaxSpeedKmh = 120
+const defaultWindowMinutes = 60
+
+//==============================================================================================================================
+//	Rule - An anomaly rule evaluated by create_activity. The parameters not used by a rule type are ignored.
+//==============================================================================================================================
+type Rule struct {
+	RuleId string `json:"ruleId"`
+	RuleType string `json:"ruleType"`
+	Action string `json:"action"`
+	Disabled bool `json:"disabled"`
+	MaxSpeedKmh float64 `json:"maxSpeedKmh"`				// impossible_travel, default 120
+	WindowMinutes int `json:"windowMinutes"`				// impossible_travel: only the previous activity within the window counts, default 60
+	DepositType string `json:"depositType"`				// collect_before_deposit, default "deposit"
+	CollectType string `json:"collectType"`				// collect_before_deposit, default "collect"
+	UpdatedBy string `json:"updatedBy"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+//==============================================================================================================================
+//	RuleSet - Rules keyed by RuleId.
+//==============================================================================================================================
+type RuleSet struct {
+	Rules map[string]Rule `json:"rules"`
+}
+
+type RuleViolation struct {
+	Rule Rule
+	Message string
+}
+
+//=================================================================================================================================
+//	 set_rule - Admin only. args[0] is the Rule JSON, an existing rule with the same ruleId is replaced.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_rule(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. the rule JSON")
+	}
+
+	var rule Rule
+	err := json.Unmarshal([]byte(args[0]), &rule)
+	if err != nil { log_warning(stub, "Invalid rule", "error", err); return nil, invalidArgument(0, "", "Invalid rule JSON") }
+
+	err = rule.normalise()
+	if err != nil { log_failure(stub, "Invalid rule", err); return nil, err }
+
+	rules, err := get_rules(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve rules", err); return nil, internalError("Failed to retrieve rules", err) }
+
+	rule.UpdatedBy = caller
+	rule.UpdatedAt = makeTimestamp(stub)
+	rules.Rules[rule.RuleId] = rule
+
+	err = put_rules(stub, rules)
+	if err != nil { log_failure(stub, "Failed to save rules", err); return nil, internalError("Failed to save rules", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: rulesStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(rule)
+}
+
+//=================================================================================================================================
+//	 delete_rule - Admin only. args[0] is the ruleId.
+//=================================================================================================================================
+func (t *SimpleChaincode) delete_rule(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. ruleId")
+	}
+
+	rules, err := get_rules(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve rules", err); return nil, internalError("Failed to retrieve rules", err) }
+
+	if _, ok := rules.Rules[args[0]]; !ok {
+		return nil, notFound("Rule " + args[0] + " not found")
+	}
+	delete(rules.Rules, args[0])
+
+	err = put_rules(stub, rules)
+	if err != nil { log_failure(stub, "Failed to save rules", err); return nil, internalError("Failed to save rules", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: rulesStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 view_rules - Returns every rule, sorted by ruleId.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_rules(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	rules, err := get_rules(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve rules", err); return nil, internalError("Failed to retrieve rules", err) }
+
+	result := []Rule{}
+	for _, rule := range rules.Rules {
+		result = append(result, rule)
+	}
+	sort.Sort(byRuleId(result))
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 evaluate_rules - Checks a new activity against the enabled rules. previous holds the activities in _activities,
+//					  archived activities are only read when a rule needs to look further back.
+//=================================================================================================================================
+func evaluate_rules(stub shim.ChaincodeStubInterface, activity Activity, previous []Activity) ([]RuleViolation, error) {
+	rules, err := get_rules(stub)
+	if err != nil { return nil, err }
+
+	var ruleIds []string
+	for ruleId := range rules.Rules {
+		ruleIds = append(ruleIds, ruleId)
+	}
+	sort.Strings(ruleIds)
+
+	var archived []Activity
+	archivedLoaded := false
+
+	var violations []RuleViolation
+	for _, ruleId := range ruleIds {
+		rule := rules.Rules[ruleId]
+		if rule.Disabled {
+			continue
+		}
+
+		var message string
+		switch rule.RuleType {
+		case RULE_IMPOSSIBLE_TRAVEL:
+			message = impossibleTravel(rule, activity, previous)
+		case RULE_COLLECT_BEFORE_DEPOSIT:
+			if activity.ActivityType != rule.CollectType {
+				continue
+			}
+
+			// the deposit may have been archived, look again over the whole history before flagging
+			message = collectBeforeDeposit(rule, activity, previous)
+			if message != "" && !archivedLoaded {
+				archived, err = load_archived_activities(stub)
+				if err != nil { return nil, err }
+				archivedLoaded = true
+			}
+			if message != "" && len(archived) > 0 {
+				message = collectBeforeDeposit(rule, activity, append(append([]Activity{}, archived...), previous...))
+			}
+		}
+
+		if message != "" {
+			violations = append(violations, RuleViolation{Rule: rule, Message: message})
+		}
+	}
+
+	return violations, nil
+}
+
+// impossibleTravel compares the activity with the actor's latest activity within the window and reports a speed
+// above the rule maximum. Anonymous actors, without name, telephone or email, cannot be told apart and are skipped.
+func impossibleTravel(rule Rule, activity Activity, previous []Activity) string {
+	if activity.Actor.Name == "" && activity.Actor.Telephone == "" && activity.Actor.Email == "" {
+		return ""
+	}
+
+	windowStart := activity.Timestamp - int64(rule.WindowMinutes)*60*1000
+	key := actorKey(activity.Actor)
+
+	for i := len(previous) - 1; i >= 0; i-- {
+		last := previous[i]
+		if last.Timestamp < windowStart {
+			return ""
+		}
+		if actorKey(last.Actor) != key {
+			continue
+		}
+		if last.Kiosk.KioskId == activity.Kiosk.KioskId {
+			return ""
+		}
+
+		meters := haversine(last.Kiosk.Latitude, last.Kiosk.Longitude, activity.Kiosk.Latitude, activity.Kiosk.Longitude)
+		hours := float64(activity.Timestamp - last.Timestamp) / 3600000
+		if hours > 0 && meters/1000/hours <= rule.MaxSpeedKmh {
+			return ""
+		}
+
+		return "Actor travelled " + strconv.FormatFloat(meters/1000, 'f', 1, 64) + " km from kiosk " + last.Kiosk.KioskId +
+			" (activity " + strconv.FormatInt(last.ActivityId, 10) + ") in " + strconv.FormatFloat(hours*60, 'f', 1, 64) + " minutes"
+	}
+
+	return ""
+}
+
+// collectBeforeDeposit reports the first resource of a collect activity whose latest deposit or collect in previous
+// is not a deposit.
+func collectBeforeDeposit(rule Rule, activity Activity, previous []Activity) string {
+	for _, resource := range activity.Resources {
+		state := ""
+		for i := len(previous) - 1; i >= 0 && state == ""; i-- {
+			if previous[i].ActivityType != rule.DepositType && previous[i].ActivityType != rule.CollectType {
+				continue
+			}
+			if hasResource(previous[i], resource.ResourceId) {
+				state = previous[i].ActivityType
+			}
+		}
+
+		if state != rule.DepositType {
+			return "Resource " + resource.ResourceId + " collected without a preceding " + rule.DepositType
+		}
+	}
+
+	return ""
+}
+
+// normalise checks the rule and fills in the default parameters.
+func (rule *Rule) normalise() error {
+	if rule.RuleId == "" {
+		return invalidArgument(0, "ruleId", "Rule id must not be empty")
+	}
+
+	if rule.Action != RULE_FLAG && rule.Action != RULE_REJECT {
+		return invalidArgument(0, "action", "Invalid rule action " + rule.Action + ". Expecting flag or reject")
+	}
+
+	switch rule.RuleType {
+	case RULE_IMPOSSIBLE_TRAVEL:
+		if rule.MaxSpeedKmh <= 0 {
+			rule.MaxSpeedKmh = defaultMaxSpeedKmh
+		}
+		if rule.WindowMinutes <= 0 {
+			rule.WindowMinutes = defaultWindowMinutes
+		}
+	case RULE_COLLECT_BEFORE_DEPOSIT:
+		if rule.DepositType == "" {
+			rule.DepositType = "deposit"
+		}
+		if rule.CollectType == "" {
+			rule.CollectType = "collect"
+		}
+	default:
+		return invalidArgument(0, "ruleType", "Unknown rule type " + rule.RuleType)
+	}
+
+	return nil
+}
+
+// ruleAlert builds the alert for a flagged rule violation.
+func ruleAlert(activity Activity, violation RuleViolation, timestamp int64) Alert {
+	return Alert{AlertType: ALERT_RULE_VIOLATION, Rule: violation.Rule.RuleId, KioskId: activity.Kiosk.KioskId,
+		ActivityId: activity.ActivityId, Message: violation.Message, RaisedAt: timestamp}
+}
+
+// actorKey identifies an actor across activities.
+func actorKey(actor Actor) string {
+	return actor.ActorType + "|" + actor.Name + "|" + actor.Telephone + "|" + actor.Email
+}
+
+func get_rules(stub shim.ChaincodeStubInterface) (RuleSet, error) {
+	var rules RuleSet
+
+	rulesAsBytes, err := stub.GetState(rulesStr)
+	if err != nil { return rules, err }
+
+	if len(rulesAsBytes) > 0 {
+		err = json.Unmarshal(rulesAsBytes, &rules)
+		if err != nil { return rules, corruptState(rulesStr, "Corrupt rules record") }
+	}
+
+	if rules.Rules == nil {
+		rules.Rules = make(map[string]Rule)
+	}
+
+	return rules, nil
+}
+
+func put_rules(stub shim.ChaincodeStubInterface, rules RuleSet) error {
+	rulesAsBytes, err := json.Marshal(rules)
+	if err != nil { return err }
+
+	return stub.PutState(rulesStr, rulesAsBytes)
+}
+
+type byRuleId []Rule
+
+func (a byRuleId) Len() int           { return len(a) }
+func (a byRuleId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byRuleId) Less(i, j int) bool { return a[i].RuleId < a[j].RuleId }
@@ -0,0 +1,431 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var tariffsStr = "_tariffs"
+var periodsStr = "_periods"
+var statementPrefix = "_statement_"
+
+const EVENT_PERIOD_CLOSED = "period.closed"
+
+// ============================================================================================================================
+// PARTY TYPE - resource owners are charged for activities on their resources, vendors are paid for the activities they perform
+// ============================================================================================================================
+const PARTY_RESOURCE_OWNER = "resourceOwner"
+const PARTY_VENDOR = "vendor"
+
+//==============================================================================================================================
+//	Tariff - Amounts per activity type in minor currency units. A resource owner is charged PerActivity once for each
+//			 activity carrying its resources plus PerResource for each of them, a vendor actor is paid VendorFee.
+//==============================================================================================================================
+type Tariff struct {
+	ActivityType string `json:"activityType"`
+	PerActivity int64 `json:"perActivity"`
+	PerResource int64 `json:"perResource"`
+	VendorFee int64 `json:"vendorFee"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+type TariffTable struct {
+	Tariffs map[string]Tariff `json:"tariffs"`
+}
+
+//==============================================================================================================================
+//	LineItem - What one activity contributes to a party's usage.
+//==============================================================================================================================
+type LineItem struct {
+	ActivityId int64 `json:"activityId"`
+	ActivityType string `json:"activityType"`
+	Timestamp int64 `json:"timestamp"`
+	ResourceIds []string `json:"resourceIds,omitempty"`
+	Amount int64 `json:"amount"`
+}
+
+//==============================================================================================================================
+//	Usage - Metered activities of one party over a span of time. A resource owner is named by its resourceOwner, a
+//			vendor by its token account id, e.g. vendor:<telephone>, as vendor names need not be unique.
+//==============================================================================================================================
+type Usage struct {
+	Party string `json:"party"`
+	PartyType string `json:"partyType"`							// one of the PARTY_ constants
+	Name string `json:"name,omitempty"`							// the vendor's name
+	Activities int `json:"activities"`
+	Total int64 `json:"total"`
+	LineItems []LineItem `json:"lineItems"`
+}
+
+//==============================================================================================================================
+//	Statement - Settlement of one party for a closed period. Hash covers the statement with an empty Hash and never
+//				changes, statements are written once by close_period.
+//==============================================================================================================================
+type Statement struct {
+	StatementId string `json:"statementId"`						// <periodId>/<partyType>/<party>
+	PeriodId string `json:"periodId"`
+	Usage
+	Tariffs map[string]Tariff `json:"tariffs"`					// the tariffs applied
+	Hash string `json:"hash"`
+}
+
+type Period struct {
+	PeriodId string `json:"periodId"`
+	Start int64 `json:"start"`									// inclusive
+	End int64 `json:"end"`										// exclusive
+	ClosedAt int64 `json:"closedAt"`
+	ClosedBy string `json:"closedBy"`
+	Statements []string `json:"statements"`					// StatementIds
+}
+
+//==============================================================================================================================
+//	AllPeriods - Closed periods in order. Periods do not overlap, each starts at or after the end of the previous one.
+//==============================================================================================================================
+type AllPeriods struct {
+	Periods []Period `json:"periods"`
+}
+
+//=================================================================================================================================
+//	 set_tariff - Admin only. args: activityType, perActivity, perResource, vendorFee in minor currency units.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_tariff(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 4. activityType, perActivity, perResource and vendorFee")
+	}
+
+	if args[0] == "" {
+		return nil, invalidArgument(0, "activityType", "Activity type must not be empty")
+	}
+
+	tariff := Tariff{ActivityType: args[0], UpdatedAt: makeTimestamp(stub)}
+	amounts := []*int64{&tariff.PerActivity, &tariff.PerResource, &tariff.VendorFee}
+	for i, amount := range amounts {
+		value, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || value < 0 { log_warning(stub, "Invalid amount", "amount", args[i+1]); return nil, invalidArgument(i+1, "amount", "Invalid amount " + args[i+1] + ". Expecting a non-negative integer") }
+		*amount = value
+	}
+
+	tariffs, err := get_tariffs(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve tariffs", err); return nil, internalError("Failed to retrieve tariffs", err) }
+
+	tariffs.Tariffs[tariff.ActivityType] = tariff
+
+	tariffsAsBytes, err := json.Marshal(tariffs)
+	if err != nil { log_failure(stub, "Failed to convert tariffs", err); return nil, internalError("Failed to convert tariffs", err) }
+
+	err = stub.PutState(tariffsStr, tariffsAsBytes)
+	if err != nil { log_failure(stub, "Failed to save tariffs", err); return nil, internalError("Failed to save tariffs", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: tariffsStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(tariff)
+}
+
+//=================================================================================================================================
+//	 view_tariffs - Returns every tariff, sorted by activity type.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_tariffs(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	tariffs, err := get_tariffs(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve tariffs", err); return nil, internalError("Failed to retrieve tariffs", err) }
+
+	result := []Tariff{}
+	for _, tariff := range tariffs.Tariffs {
+		result = append(result, tariff)
+	}
+	sort.Sort(byActivityType(result))
+
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 usage_report - Admin only. args: start, end as in view_activities, end exclusive. Meters the activities in the span
+//					with the current tariffs without settling them.
+//=================================================================================================================================
+func (t *SimpleChaincode) usage_report(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. start and end")
+	}
+
+	start, end, err := parsePeriod(args, 0)
+	if err != nil { log_failure(stub, "Invalid period", err); return nil, err }
+
+	tariffs, err := get_tariffs(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve tariffs", err); return nil, internalError("Failed to retrieve tariffs", err) }
+
+	activities, err := load_activities(stub, true)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	return json.Marshal(meter(activities, tariffs, start, end))
+}
+
+//=================================================================================================================================
+//	 close_period - Admin only. args: periodId, start, end. Meters the activities from start up to end with the current
+//					tariffs and writes one statement per party. Periods cannot be reopened and must not overlap.
+//=================================================================================================================================
+func (t *SimpleChaincode) close_period(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 3 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 3. periodId, start and end")
+	}
+
+	if args[0] == "" {
+		return nil, invalidArgument(0, "periodId", "Period id must not be empty")
+	}
+
+	start, end, err := parsePeriod(args, 1)
+	if err != nil { log_failure(stub, "Invalid period", err); return nil, err }
+
+	periods, err := get_periods(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve periods", err); return nil, internalError("Failed to retrieve periods", err) }
+
+	for _, period := range periods.Periods {
+		if period.PeriodId == args[0] {
+			return nil, conflict("Period " + args[0] + " is already closed")
+		}
+	}
+	if len(periods.Periods) > 0 && start < periods.Periods[len(periods.Periods)-1].End {
+		return nil, conflict("Period overlaps the closed period " + periods.Periods[len(periods.Periods)-1].PeriodId)
+	}
+
+	now := makeTimestamp(stub)
+	if end > now {
+		return nil, invalidArgument(2, "end", "Period end is in the future")
+	}
+
+	tariffs, err := get_tariffs(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve tariffs", err); return nil, internalError("Failed to retrieve tariffs", err) }
+
+	activities, err := load_activities(stub, true)
+	if err != nil { log_failure(stub, "Failed to retrieve activities", err); return nil, internalError("Failed to retrieve activities", err) }
+
+	period := Period{PeriodId: args[0], Start: start, End: end, ClosedAt: now, ClosedBy: caller, Statements: []string{}}
+	for _, usage := range meter(activities, tariffs, start, end) {
+		statement := Statement{StatementId: period.PeriodId + "/" + usage.PartyType + "/" + usage.Party, PeriodId: period.PeriodId,
+			Usage: usage, Tariffs: tariffs.Tariffs}
+
+		statement.Hash, err = statementHash(statement)
+		if err != nil { log_failure(stub, "Failed to hash statement", err); return nil, internalError("Failed to hash statement", err) }
+
+		statementAsBytes, err := json.Marshal(statement)
+		if err != nil { log_failure(stub, "Failed to convert statement", err); return nil, internalError("Failed to convert statement", err) }
+
+		err = stub.PutState(statementPrefix + statement.StatementId, statementAsBytes)
+		if err != nil { log_failure(stub, "Failed to save statement", err); return nil, internalError("Failed to save statement", err) }
+
+		period.Statements = append(period.Statements, statement.StatementId)
+	}
+
+	periods.Periods = append(periods.Periods, period)
+	periodsAsBytes, err := json.Marshal(periods)
+	if err != nil { log_failure(stub, "Failed to convert periods", err); return nil, internalError("Failed to convert periods", err) }
+
+	err = stub.PutState(periodsStr, periodsAsBytes)
+	if err != nil { log_failure(stub, "Failed to save periods", err); return nil, internalError("Failed to save periods", err) }
+
+	err = set_event(stub, EVENT_PERIOD_CLOSED, period)
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(period)
+}
+
+//=================================================================================================================================
+//	 view_statements - args[0] is an optional periodId, args[1] an optional party. Without a periodId the closed periods
+//					   are returned, with one the statements of that period.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_statements(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	periods, err := get_periods(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve periods", err); return nil, internalError("Failed to retrieve periods", err) }
+
+	if len(args) == 0 || args[0] == "" {
+		if periods.Periods == nil {
+			periods.Periods = []Period{}
+		}
+		return json.Marshal(periods.Periods)
+	}
+
+	party := ""
+	if len(args) > 1 {
+		party = args[1]
+	}
+
+	for _, period := range periods.Periods {
+		if period.PeriodId != args[0] {
+			continue
+		}
+
+		statements := []Statement{}
+		for _, statementId := range period.Statements {
+			statementAsBytes, err := stub.GetState(statementPrefix + statementId)
+			if err != nil { log_failure(stub, "Failed to retrieve statement", err); return nil, internalError("Failed to retrieve statement " + statementId, err) }
+
+			var statement Statement
+			err = json.Unmarshal(statementAsBytes, &statement)
+			if err != nil { log_failure(stub, "Corrupt statement", err); return nil, corruptState(statementPrefix + statementId, "Corrupt statement " + statementId) }
+
+			if party == "" || statement.Party == party {
+				statements = append(statements, statement)
+			}
+		}
+		return json.Marshal(statements)
+	}
+
+	return nil, notFound("Period " + args[0] + " not found")
+}
+
+// meter prices the activities with start <= timestamp < end, one Usage per party sorted by party type and party.
+// Activity types without a tariff are not metered.
+func meter(activities []Activity, tariffs TariffTable, start int64, end int64) []Usage {
+	usages := make(map[string]*Usage)
+	account := func(partyType string, party string, name string, item LineItem) {
+		key := partyType + "/" + party
+		usage, ok := usages[key]
+		if !ok {
+			usage = &Usage{Party: party, PartyType: partyType, Name: name, LineItems: []LineItem{}}
+			usages[key] = usage
+		}
+		usage.Activities++
+		usage.Total += item.Amount
+		usage.LineItems = append(usage.LineItems, item)
+	}
+
+	for _, activity := range activities {
+		if activity.Timestamp < start || activity.Timestamp >= end {
+			continue
+		}
+
+		tariff, ok := tariffs.Tariffs[activity.ActivityType]
+		if !ok {
+			continue
+		}
+
+		var owners []string
+		resourceIds := make(map[string][]string)
+		for _, resource := range activity.Resources {
+			if _, seen := resourceIds[resource.ResourceOwner]; !seen {
+				owners = append(owners, resource.ResourceOwner)
+			}
+			resourceIds[resource.ResourceOwner] = append(resourceIds[resource.ResourceOwner], resource.ResourceId)
+		}
+
+		for _, owner := range owners {
+			account(PARTY_RESOURCE_OWNER, owner, "", LineItem{ActivityId: activity.ActivityId, ActivityType: activity.ActivityType,
+				Timestamp: activity.Timestamp, ResourceIds: resourceIds[owner],
+				Amount: tariff.PerActivity + tariff.PerResource*int64(len(resourceIds[owner]))})
+		}
+
+		if activity.Actor.ActorType == VENDOR && tariff.VendorFee > 0 {
+			account(PARTY_VENDOR, tokenAccountId(activity.Actor), activity.Actor.Name, LineItem{ActivityId: activity.ActivityId, ActivityType: activity.ActivityType,
+				Timestamp: activity.Timestamp, Amount: tariff.VendorFee})
+		}
+	}
+
+	var keys []string
+	for key := range usages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []Usage{}
+	for _, key := range keys {
+		result = append(result, *usages[key])
+	}
+	return result
+}
+
+// parsePeriod reads the start and end of a period, args[first] and args[first+1], in the view_activities time formats.
+// The end is exclusive, a date-only end includes that day as in view_activities.
+func parsePeriod(args []string, first int) (int64, int64, error) {
+	start, err := parseTime(args[first], time.UTC)
+	if err != nil { return 0, 0, invalidArgument(first, "start", "Invalid start time format") }
+
+	end, err := parseEndTime(args[first+1], time.UTC, false)
+	if err != nil { return 0, 0, invalidArgument(first+1, "end", "Invalid end time format") }
+
+	if !end.After(start) {
+		return 0, 0, invalidArgument(first+1, "end", "Period end must be after its start")
+	}
+
+	return start.UnixNano() / nanosPerMillisecond, end.UnixNano() / nanosPerMillisecond, nil
+}
+
+func statementHash(statement Statement) (string, error) {
+	statement.Hash = ""
+
+	statementAsBytes, err := json.Marshal(statement)
+	if err != nil { return "", err }
+
+	sum := sha256.Sum256(statementAsBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func get_tariffs(stub shim.ChaincodeStubInterface) (TariffTable, error) {
+	var tariffs TariffTable
+
+	tariffsAsBytes, err := stub.GetState(tariffsStr)
+	if err != nil { return tariffs, err }
+
+	if len(tariffsAsBytes) > 0 {
+		err = json.Unmarshal(tariffsAsBytes, &tariffs)
+		if err != nil { return tariffs, corruptState(tariffsStr, "Corrupt tariffs record") }
+	}
+
+	if tariffs.Tariffs == nil {
+		tariffs.Tariffs = make(map[string]Tariff)
+	}
+
+	return tariffs, nil
+}
+
+func get_periods(stub shim.ChaincodeStubInterface) (AllPeriods, error) {
+	var periods AllPeriods
+
+	periodsAsBytes, err := stub.GetState(periodsStr)
+	if err != nil { return periods, err }
+
+	if len(periodsAsBytes) > 0 {
+		err = json.Unmarshal(periodsAsBytes, &periods)
+		if err != nil { return periods, corruptState(periodsStr, "Corrupt periods record") }
+	}
+
+	return periods, nil
+}
+
+type byActivityType []Tariff
+
+func (a byActivityType) Len() int           { return len(a) }
+func (a byActivityType) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byActivityType) Less(i, j int) bool { return a[i].ActivityType < a[j].ActivityType }
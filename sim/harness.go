@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// CALL KINDS
+// ============================================================================================================================
+const INIT = "init"
+const INVOKE = "invoke"
+const QUERY = "query"
+
+// Caller is the identity a call is made as, Account and Role are read through ReadCertAttribute.
+type Caller struct {
+	Account string `yaml:"account"`
+	Role string `yaml:"role"`
+	Attributes map[string]string `yaml:"attributes"`					// further attributes, e.g. tenant
+}
+
+type Result struct {
+	TxID string
+	Payload []byte
+	Err error
+	Event *Event
+}
+
+//==============================================================================================================================
+//	Harness - Drives Init, Invoke and Query against the stub. Every call is its own transaction with a fresh id; invokes
+//			  that fail are rolled back.
+//==============================================================================================================================
+type Harness struct {
+	CC shim.Chaincode
+	Stub *Stub
+	seq int
+}
+
+func NewHarness(name string, cc shim.Chaincode) *Harness {
+	return &Harness{CC: cc, Stub: NewStub(name, cc)}
+}
+
+func (h *Harness) Init(caller Caller, at time.Time, function string, args []string) Result {
+	return h.call(INIT, caller, at, function, args)
+}
+
+func (h *Harness) Invoke(caller Caller, at time.Time, function string, args []string) Result {
+	return h.call(INVOKE, caller, at, function, args)
+}
+
+func (h *Harness) Query(caller Caller, at time.Time, function string, args []string) Result {
+	return h.call(QUERY, caller, at, function, args)
+}
+
+func (h *Harness) call(kind string, caller Caller, at time.Time, function string, args []string) Result {
+	h.seq++
+	txID := fmt.Sprintf("sim-tx-%d", h.seq)
+
+	h.Stub.Attributes = map[string]string{}
+	for name, value := range caller.Attributes {
+		h.Stub.Attributes[name] = value
+	}
+	if caller.Account != "" {
+		h.Stub.Attributes["account"] = caller.Account
+	}
+	if caller.Role != "" {
+		h.Stub.Attributes["role"] = caller.Role
+	}
+	h.Stub.TxTime = at
+	h.Stub.Event = nil
+
+	state, keys := h.Stub.snapshot()
+
+	h.Stub.MockTransactionStart(txID)
+	var payload []byte
+	var err error
+	switch kind {
+	case INIT:
+		payload, err = h.CC.Init(h.Stub, function, args)
+	case INVOKE:
+		payload, err = h.CC.Invoke(h.Stub, function, args)
+	default:
+		payload, err = h.CC.Query(h.Stub, function, args)
+	}
+	h.Stub.MockTransactionEnd(txID)
+
+	// queries must not write, and failed transactions leave no trace on a peer
+	if err != nil || kind == QUERY {
+		h.Stub.restore(state, keys)
+	}
+
+	result := Result{TxID: txID, Payload: payload, Err: err}
+	if err == nil && kind != QUERY {
+		result.Event = h.Stub.Event
+	}
+	return result
+}
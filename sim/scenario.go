@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultClock = "2016-01-01T00:00:00Z"
+
+//==============================================================================================================================
+//	Scenario - A YAML script of calls. Example:
+//
+//		name: deposit and collect
+//		clock: 2016-11-02T09:00:00+08:00
+//		callers:
+//		  ops: {account: ops1, role: admin}
+//		steps:
+//		  - init: init
+//		  - invoke: create_activity
+//		    as: ops
+//		    at: +5m
+//		    args: [user, Tan, "91234567", tan@example.com, deposit, K1, "1.29", "103.85", "", "", scanner, a, b, c, d]
+//		    expect: {event: activity.created.deposit}
+//		  - query: view_activities
+//		    args: ["[]", "[]", ...]
+//		    expect: {contains: ['"activityType":"deposit"']}
+//
+//	The clock advances one second per step unless the step sets "at", either RFC3339 or a duration after the previous step.
+//==============================================================================================================================
+type Scenario struct {
+	Name string `yaml:"name"`
+	Clock string `yaml:"clock"`
+	Callers map[string]Caller `yaml:"callers"`
+	Steps []Step `yaml:"steps"`
+}
+
+type Step struct {
+	Init string `yaml:"init"`
+	Invoke string `yaml:"invoke"`
+	Query string `yaml:"query"`
+	As string `yaml:"as"`
+	At string `yaml:"at"`
+	Args []string `yaml:"args"`
+	Expect Expect `yaml:"expect"`
+}
+
+// Expect is checked against the step result, an empty Expect only requires the call to succeed.
+type Expect struct {
+	Error string `yaml:"error"`									// substring of the expected error, the call must fail
+	Contains []string `yaml:"contains"`							// substrings of the payload
+	Event string `yaml:"event"`									// name of the event set by the transaction
+}
+
+type StepReport struct {
+	Index int
+	Kind string
+	Function string
+	Result Result
+	Failures []string
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	scenarioAsBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario Scenario
+	if err := yaml.Unmarshal(scenarioAsBytes, &scenario); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &scenario, nil
+}
+
+// Run executes the steps in order and reports each one, a step that does not meet its expectation does not stop the run.
+func (h *Harness) Run(scenario *Scenario) ([]StepReport, error) {
+	clockValue := scenario.Clock
+	if clockValue == "" {
+		clockValue = defaultClock
+	}
+	clock, err := time.Parse(time.RFC3339Nano, clockValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clock %s: %s", clockValue, err)
+	}
+
+	var reports []StepReport
+	for i, step := range scenario.Steps {
+		kind, function, err := step.call()
+		if err != nil {
+			return reports, fmt.Errorf("step %d: %s", i+1, err)
+		}
+
+		clock, err = advance(clock, step.At)
+		if err != nil {
+			return reports, fmt.Errorf("step %d: %s", i+1, err)
+		}
+
+		var caller Caller
+		if step.As != "" {
+			var ok bool
+			if caller, ok = scenario.Callers[step.As]; !ok {
+				return reports, fmt.Errorf("step %d: unknown caller %s", i+1, step.As)
+			}
+		}
+
+		result := h.call(kind, caller, clock, function, step.Args)
+		reports = append(reports, StepReport{Index: i + 1, Kind: kind, Function: function, Result: result, Failures: step.Expect.check(result)})
+	}
+
+	return reports, nil
+}
+
+func (s Step) call() (string, string, error) {
+	calls := 0
+	kind, function := "", ""
+	for _, c := range []struct{ kind, function string }{{INIT, s.Init}, {INVOKE, s.Invoke}, {QUERY, s.Query}} {
+		if c.function != "" {
+			calls++
+			kind, function = c.kind, c.function
+		}
+	}
+	if calls != 1 {
+		return "", "", fmt.Errorf("expecting exactly one of init, invoke or query")
+	}
+	return kind, function, nil
+}
+
+func advance(clock time.Time, at string) (time.Time, error) {
+	if at == "" {
+		return clock.Add(time.Second), nil
+	}
+	if strings.HasPrefix(at, "+") {
+		d, err := time.ParseDuration(at[1:])
+		if err != nil {
+			return clock, fmt.Errorf("invalid at %s: %s", at, err)
+		}
+		return clock.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return clock, fmt.Errorf("invalid at %s: %s", at, err)
+	}
+	return t, nil
+}
+
+func (e Expect) check(result Result) []string {
+	var failures []string
+
+	if e.Error != "" {
+		if result.Err == nil {
+			failures = append(failures, "expected error containing "+e.Error+", call succeeded")
+		} else if !strings.Contains(result.Err.Error(), e.Error) {
+			failures = append(failures, "expected error containing "+e.Error+", got "+result.Err.Error())
+		}
+		return failures
+	}
+
+	if result.Err != nil {
+		return append(failures, "unexpected error: "+result.Err.Error())
+	}
+
+	for _, substring := range e.Contains {
+		if !strings.Contains(string(result.Payload), substring) {
+			failures = append(failures, "payload does not contain "+substring)
+		}
+	}
+
+	if e.Event != "" {
+		if result.Event == nil {
+			failures = append(failures, "expected event "+e.Event+", none set")
+		} else if result.Event.Name != e.Event {
+			failures = append(failures, "expected event "+e.Event+", got "+result.Event.Name)
+		}
+	}
+
+	return failures
+}
+
+// Print writes a line per step and returns the number of failed steps.
+func Print(w io.Writer, scenario *Scenario, reports []StepReport) int {
+	failed := 0
+	fmt.Fprintf(w, "scenario %s\n", scenario.Name)
+	for _, report := range reports {
+		status := "ok  "
+		if len(report.Failures) > 0 {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "  %s %3d %s %s (%s)\n", status, report.Index, report.Kind, report.Function, report.Result.TxID)
+		for _, failure := range report.Failures {
+			fmt.Fprintf(w, "         %s\n", failure)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,64 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Save writes the world state to a JSON file so a later run can continue from it.
+func (h *Harness) Save(path string) error {
+	stateAsBytes, err := json.MarshalIndent(h.Stub.State, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, stateAsBytes, 0644)
+}
+
+// Load replaces the world state with the one saved at path. A missing file leaves the ledger empty.
+func (h *Harness) Load(path string) error {
+	stateAsBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := map[string][]byte{}
+	if err := json.Unmarshal(stateAsBytes, &state); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := list.New()
+	for _, key := range keys {
+		sorted.PushBack(key)
+	}
+
+	h.Stub.restore(state, sorted)
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sim runs a chaincode in process against an in-memory ledger, without a Fabric network.
+package sim
+
+import (
+	"container/list"
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Event is the chaincode event set by the last transaction.
+type Event struct {
+	Name string
+	Payload []byte
+}
+
+//==============================================================================================================================
+//	Stub - The shim's MockStub keeps world state and range queries. Stub adds what MockStub leaves out: caller
+//		   attributes for ReadCertAttribute, a settable transaction timestamp and the event set by the transaction.
+//==============================================================================================================================
+type Stub struct {
+	*shim.MockStub
+
+	Attributes map[string]string
+	TxTime time.Time
+	Event *Event
+}
+
+func NewStub(name string, cc shim.Chaincode) *Stub {
+	return &Stub{MockStub: shim.NewMockStub(name, cc), Attributes: map[string]string{}}
+}
+
+func (s *Stub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, ok := s.Attributes[attributeName]
+	if !ok {
+		return nil, errors.New("attribute " + attributeName + " not found in the caller certificate")
+	}
+	return []byte(value), nil
+}
+
+func (s *Stub) GetTxID() string {
+	return s.TxID
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	if s.TxTime.IsZero() {
+		return nil, nil
+	}
+	return &timestamp.Timestamp{Seconds: s.TxTime.Unix(), Nanos: int32(s.TxTime.Nanosecond())}, nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name must not be empty")
+	}
+	s.Event = &Event{Name: name, Payload: payload}
+	return nil
+}
+
+// snapshot copies the world state so a failed transaction can be rolled back, as a peer discards its writes.
+func (s *Stub) snapshot() (map[string][]byte, *list.List) {
+	state := make(map[string][]byte, len(s.State))
+	for key, value := range s.State {
+		state[key] = value
+	}
+
+	keys := list.New()
+	for e := s.Keys.Front(); e != nil; e = e.Next() {
+		keys.PushBack(e.Value)
+	}
+
+	return state, keys
+}
+
+func (s *Stub) restore(state map[string][]byte, keys *list.List) {
+	s.State = state
+	s.Keys = keys
+}
@@ -0,0 +1,80 @@
+// +build simulator
+
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/khoazany/smart/sim"
+)
+
+// ============================================================================================================================
+// Main - runs YAML scenarios against SimpleChaincode on an in-memory ledger instead of connecting to a peer
+//
+//		go build -tags simulator -o hdbsim . && ./hdbsim -state ledger.json scenarios/*.yaml
+// ============================================================================================================================
+func main() {
+	statePath := flag.String("state", "", "JSON file the ledger is loaded from and saved to, in memory only when empty")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: hdbsim [-state file] scenario.yaml...")
+		os.Exit(2)
+	}
+
+	// keep the chaincode's log lines out of the scenario report
+	logOutput = os.Stderr
+
+	harness := sim.NewHarness("hdb", new(SimpleChaincode))
+	if *statePath != "" {
+		if err := harness.Load(*statePath); err != nil {
+			fmt.Fprintf(os.Stderr, "hdbsim: failed to load %s: %s\n", *statePath, err)
+			os.Exit(1)
+		}
+	}
+
+	failed := 0
+	for _, path := range flag.Args() {
+		scenario, err := sim.LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hdbsim: %s\n", err)
+			os.Exit(1)
+		}
+
+		reports, err := harness.Run(scenario)
+		failed += sim.Print(os.Stdout, scenario, reports)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hdbsim: %s: %s\n", path, err)
+			failed++
+		}
+	}
+
+	if *statePath != "" {
+		if err := harness.Save(*statePath); err != nil {
+			fmt.Fprintf(os.Stderr, "hdbsim: failed to save %s: %s\n", *statePath, err)
+			os.Exit(1)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
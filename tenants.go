@@ -0,0 +1,274 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// _tenants is kept outside every tenant namespace.
+var tenantsStr = "_tenants"
+
+// Keys of a tenant are stored as _tenant/<tenant>/<key>. The default tenant's keys are not prefixed, so data written
+// before tenants were introduced stays where it was. Callers join it with the tenant attribute "default", or without
+// any attribute when security is disabled.
+const tenantKeyPrefix = "_tenant/"
+const reservedKeyPrefix = "_"
+const DEFAULT_TENANT = ""
+const DEFAULT_TENANT_ATTRIBUTE = "default"
+
+//==============================================================================================================================
+//	TenantRecord - A tenant seen by Invoke, registered on its first transaction.
+//==============================================================================================================================
+type TenantRecord struct {
+	Tenant string `json:"tenant"`
+	FirstSeen int64 `json:"firstSeen"`
+}
+
+type AllTenants struct {
+	Tenants []TenantRecord `json:"tenants"`
+}
+
+//==============================================================================================================================
+//	TenantSummary - One row of tenant_report.
+//==============================================================================================================================
+type TenantSummary struct {
+	Tenant string `json:"tenant"`
+	ActivityCount int64 `json:"activityCount"`
+	Kiosks int `json:"kiosks"`
+	RegisteredKiosks int `json:"registeredKiosks"`
+	OpenAlerts int `json:"openAlerts"`
+	ActiveReservations int `json:"activeReservations"`
+}
+
+//==============================================================================================================================
+//	tenantStub - Scopes the state and events of a transaction to a tenant. Keys are prefixed on the way in and stripped
+//				 from range query results, event names are prefixed with "<tenant>/".
+//==============================================================================================================================
+type tenantStub struct {
+	shim.ChaincodeStubInterface
+	tenant string
+}
+
+func (s *tenantStub) GetState(key string) ([]byte, error) {
+	return s.ChaincodeStubInterface.GetState(s.key(key))
+}
+
+func (s *tenantStub) PutState(key string, value []byte) error {
+	return s.ChaincodeStubInterface.PutState(s.key(key), value)
+}
+
+func (s *tenantStub) DelState(key string) error {
+	return s.ChaincodeStubInterface.DelState(s.key(key))
+}
+
+func (s *tenantStub) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	iter, err := s.ChaincodeStubInterface.RangeQueryState(s.key(startKey), s.key(endKey))
+	if err != nil { return nil, err }
+
+	return &tenantIterator{StateRangeQueryIteratorInterface: iter, prefix: s.key("")}, nil
+}
+
+func (s *tenantStub) SetEvent(name string, payload []byte) error {
+	return s.ChaincodeStubInterface.SetEvent(s.tenant+"/"+name, payload)
+}
+
+func (s *tenantStub) key(key string) string {
+	return tenantKeyPrefix + s.tenant + "/" + key
+}
+
+type tenantIterator struct {
+	shim.StateRangeQueryIteratorInterface
+	prefix string
+}
+
+func (iter *tenantIterator) Next() (string, []byte, error) {
+	key, value, err := iter.StateRangeQueryIteratorInterface.Next()
+	return strings.TrimPrefix(key, iter.prefix), value, err
+}
+
+// get_tenant reads the tenant attribute of the caller's certificate. A caller whose certificate has no readable tenant
+// is refused rather than put in the default tenant, unless there is no certificate at all because security is disabled.
+func get_tenant(stub shim.ChaincodeStubInterface) (string, error) {
+	tenant, err := stub.ReadCertAttribute("tenant")
+	if err != nil || len(tenant) == 0 {
+		cert, certErr := stub.GetCallerCertificate()
+		if certErr == nil && len(cert) == 0 {
+			return DEFAULT_TENANT, nil
+		}
+		if err != nil {
+			return "", permissionDenied("Couldn't get attribute 'tenant'. Error: " + err.Error())
+		}
+		return "", permissionDenied("Empty tenant attribute. Expecting a tenant or " + DEFAULT_TENANT_ATTRIBUTE)
+	}
+
+	if string(tenant) == DEFAULT_TENANT_ATTRIBUTE {
+		return DEFAULT_TENANT, nil
+	}
+
+	if strings.ContainsAny(string(tenant), "/~") {
+		return "", invalidArgument(-1, "tenant", "Invalid tenant " + string(tenant))
+	}
+
+	return string(tenant), nil
+}
+
+// reservedKey tells whether write and read refuse the key. Every key the chaincode keeps starts with reservedKeyPrefix:
+// the activities and their chain heads, the token ledger, statements, consents, the access log and the counters, and
+// outside the tenant prefixes the other tenants' keys, the tenant registry and the shared log level.
+func reservedKey(key string) bool {
+	return strings.HasPrefix(key, reservedKeyPrefix)
+}
+
+// tenant_stub returns the stub scoped to the tenant, the stub itself for the default tenant.
+func tenant_stub(stub shim.ChaincodeStubInterface, tenant string) shim.ChaincodeStubInterface {
+	if tenant == DEFAULT_TENANT {
+		return stub
+	}
+	return &tenantStub{ChaincodeStubInterface: stub, tenant: tenant}
+}
+
+//=================================================================================================================================
+//	 ensure_tenant - Registers a tenant on its first transaction and initialises its activity count like Init does for
+//					 the default tenant.
+//=================================================================================================================================
+func ensure_tenant(stub shim.ChaincodeStubInterface, tenant string) error {
+	if tenant == DEFAULT_TENANT {
+		return nil
+	}
+
+	tenants, err := get_tenants(stub)
+	if err != nil { return err }
+
+	for _, record := range tenants.Tenants {
+		if record.Tenant == tenant {
+			return nil
+		}
+	}
+
+	tenants.Tenants = append(tenants.Tenants, TenantRecord{Tenant: tenant, FirstSeen: makeTimestamp(stub)})
+
+	tenantsAsBytes, err := json.Marshal(tenants)
+	if err != nil { return err }
+
+	err = stub.PutState(tenantsStr, tenantsAsBytes)
+	if err != nil { return err }
+
+	return tenant_stub(stub, tenant).PutState(activityCountStr, []byte(strconv.FormatInt(0, 10)))
+}
+
+//=================================================================================================================================
+//	 tenant_report - Super-admin only. Summarises every tenant, the default tenant first. args[0] is an optional JSON
+//					 array of tenants to report on.
+//=================================================================================================================================
+func (t *SimpleChaincode) tenant_report(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != SUPER_ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	var only []string
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &only)
+		if err != nil { log_warning(stub, "Invalid tenants argument", "error", err); return nil, invalidArgument(0, "tenants", "Invalid tenants argument") }
+	}
+
+	tenants, err := get_tenants(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve tenants", err); return nil, internalError("Failed to retrieve tenants", err) }
+
+	names := []string{}
+	for _, record := range tenants.Tenants {
+		names = append(names, record.Tenant)
+	}
+	sort.Strings(names)
+	names = append([]string{DEFAULT_TENANT}, names...)
+
+	result := []TenantSummary{}
+	for _, tenant := range names {
+		if len(only) > 0 && !containsString(only, tenant) {
+			continue
+		}
+
+		summary, err := summarise_tenant(tenant_stub(stub, tenant), tenant)
+		if err != nil { log_failure(stub, "Failed to summarise tenant " + tenant, err); return nil, internalError("Failed to summarise tenant " + tenant, err) }
+
+		result = append(result, summary)
+	}
+
+	return json.Marshal(result)
+}
+
+func summarise_tenant(stub shim.ChaincodeStubInterface, tenant string) (TenantSummary, error) {
+	summary := TenantSummary{Tenant: tenant}
+
+	activityCountAsBytes, err := stub.GetState(activityCountStr)
+	if err != nil { return summary, err }
+
+	if len(activityCountAsBytes) > 0 {
+		summary.ActivityCount, err = strconv.ParseInt(string(activityCountAsBytes), 10, 64)
+		if err != nil { return summary, corruptState(activityCountStr, "Corrupt activity count record") }
+	}
+
+	kiosks, err := get_kiosks(stub)
+	if err != nil { return summary, err }
+
+	for _, record := range kiosks.Kiosks {
+		summary.Kiosks++
+		if record.Registered {
+			summary.RegisteredKiosks++
+		}
+	}
+
+	alerts, err := get_alerts(stub)
+	if err != nil { return summary, err }
+
+	for _, alert := range alerts.Alerts {
+		if alert.Status != ALERT_RESOLVED {
+			summary.OpenAlerts++
+		}
+	}
+
+	reservations, err := get_reservations(stub)
+	if err != nil { return summary, err }
+
+	reservations.expire(makeTimestamp(stub))
+	for _, reservation := range reservations.Reservations {
+		if reservation.active() {
+			summary.ActiveReservations++
+		}
+	}
+
+	return summary, nil
+}
+
+func get_tenants(stub shim.ChaincodeStubInterface) (AllTenants, error) {
+	var tenants AllTenants
+
+	tenantsAsBytes, err := stub.GetState(tenantsStr)
+	if err != nil { return tenants, err }
+
+	if len(tenantsAsBytes) > 0 {
+		err = json.Unmarshal(tenantsAsBytes, &tenants)
+		if err != nil { return tenants, corruptState(tenantsStr, "Corrupt tenants record") }
+	}
+
+	return tenants, nil
+}
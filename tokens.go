@@ -0,0 +1,429 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var tokenConfigStr = "_tokenConfig"
+var tokenLedgerStr = "_tokenLedger"
+
+// Activity type of the activities created by redeem_tokens, they never earn an award.
+const REDEEM_ACTIVITY_TYPE = "redeem"
+
+const EVENT_TOKENS_TRANSFERRED = "tokens.transferred"
+
+// ============================================================================================================================
+// TOKEN ENTRY KIND
+// ============================================================================================================================
+const ENTRY_AWARD = "award"
+const ENTRY_TRANSFER_IN = "transfer_in"
+const ENTRY_TRANSFER_OUT = "transfer_out"
+const ENTRY_REDEEM = "redeem"
+
+//==============================================================================================================================
+//	TokenConfig - Tokens awarded to the actor of an activity, per activity type.
+//==============================================================================================================================
+type TokenConfig struct {
+	Awards map[string]int64 `json:"awards"`
+}
+
+//==============================================================================================================================
+//	TokenEntry - One balance change. Amount is negative for debits, Balance is the balance after the change. Caller is
+//				 the identity that made the change.
+//==============================================================================================================================
+type TokenEntry struct {
+	Kind string `json:"kind"`
+	Amount int64 `json:"amount"`
+	Balance int64 `json:"balance"`
+	ActivityId *int64 `json:"activityId,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`			// the kiosk of an award or redemption, the other account of a transfer
+	Caller string `json:"caller,omitempty"`
+	TxId string `json:"txId"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+//==============================================================================================================================
+//	TokenAccount - Nonce is the nonce of the last debit, each debit must carry Nonce+1 so a debit cannot be replayed.
+//==============================================================================================================================
+type TokenAccount struct {
+	AccountId string `json:"accountId"`
+	Balance int64 `json:"balance"`
+	Nonce int64 `json:"nonce"`
+	Entries []TokenEntry `json:"entries"`
+}
+
+type TokenLedger struct {
+	Accounts map[string]TokenAccount `json:"accounts"`
+}
+
+type TokenBalance struct {
+	AccountId string `json:"accountId"`
+	Balance int64 `json:"balance"`
+	Nonce int64 `json:"nonce"`
+}
+
+//==============================================================================================================================
+//	TokensTransferredEvent - Account ids carry the actor's telephone or email, so the event names the accounts by digest
+//							 and only adds the ids when the event config includes PII.
+//==============================================================================================================================
+type TokensTransferredEvent struct {
+	FromDigest string `json:"fromDigest"`
+	ToDigest string `json:"toDigest"`
+	Amount int64 `json:"amount"`
+	From string `json:"from,omitempty"`
+	To string `json:"to,omitempty"`
+}
+
+//=================================================================================================================================
+//	 set_token_award - Admin only. args: activityType, amount. An amount of 0 stops awarding tokens for the activity type.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_token_award(stub shim.ChaincodeStubInterface, caller_affiliation string, args []string) ([]byte, error) {
+	if caller_affiliation != ADMIN {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	if len(args) != 2 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 2. activityType and amount")
+	}
+
+	if args[0] == "" || args[0] == REDEEM_ACTIVITY_TYPE {
+		return nil, invalidArgument(0, "activityType", "Invalid activity type " + args[0])
+	}
+
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount < 0 { log_warning(stub, "Invalid amount", "amount", args[1]); return nil, invalidArgument(1, "amount", "Invalid amount. Expecting a non-negative integer") }
+
+	config, err := get_token_config(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token config", err); return nil, internalError("Failed to retrieve token config", err) }
+
+	if amount == 0 {
+		delete(config.Awards, args[0])
+	} else {
+		config.Awards[args[0]] = amount
+	}
+
+	configAsBytes, err := json.Marshal(config)
+	if err != nil { log_failure(stub, "Failed to convert token config", err); return nil, internalError("Failed to convert token config", err) }
+
+	err = stub.PutState(tokenConfigStr, configAsBytes)
+	if err != nil { log_failure(stub, "Failed to save token config", err); return nil, internalError("Failed to save token config", err) }
+
+	err = set_event(stub, EVENT_CONFIG_UPDATED, ConfigUpdatedEvent{Config: tokenConfigStr})
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return configAsBytes, nil
+}
+
+//=================================================================================================================================
+//	 transfer_tokens - Called by an admin, the holder of the from account, whose enrollment id is the account id, or a
+//					   kiosk the from account earned or redeemed tokens at, for the actor in front of it. args: from
+//					   accountId, to accountId, amount, nonce. The nonce must be the from account's nonce + 1.
+//=================================================================================================================================
+func (t *SimpleChaincode) transfer_tokens(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 4. from, to, amount and nonce")
+	}
+
+	from, to := args[0], args[1]
+	if to == "" || from == to {
+		return nil, invalidArgument(1, "to", "Invalid recipient account " + to)
+	}
+
+	amount, nonce, err := parseDebit(args, 2)
+	if err != nil { log_failure(stub, "Invalid amount or nonce", err); return nil, err }
+
+	ledger, err := get_token_ledger(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token ledger", err); return nil, internalError("Failed to retrieve token ledger", err) }
+
+	if !ledger.mayDebit(from, caller, caller_affiliation) {
+		log_warning(stub, "Permission Denied"); return nil, permissionDenied("Permission Denied")
+	}
+
+	timestamp := makeTimestamp(stub)
+	txId := stub.GetTxID()
+
+	err = ledger.debit(from, amount, nonce, TokenEntry{Kind: ENTRY_TRANSFER_OUT, Counterparty: to, Caller: caller, TxId: txId, Timestamp: timestamp})
+	if err != nil { log_failure(stub, "Debit rejected", err); return nil, err }
+
+	ledger.credit(to, amount, TokenEntry{Kind: ENTRY_TRANSFER_IN, Counterparty: from, Caller: caller, TxId: txId, Timestamp: timestamp})
+
+	err = put_token_ledger(stub, ledger)
+	if err != nil { log_failure(stub, "Failed to save token ledger", err); return nil, internalError("Failed to save token ledger", err) }
+
+	config, err := get_event_config(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve event config", err); return nil, internalError("Failed to retrieve event config", err) }
+
+	event := TokensTransferredEvent{FromDigest: accountDigest(from), ToDigest: accountDigest(to), Amount: amount}
+	if config.IncludePII {
+		event.From, event.To = from, to
+	}
+
+	err = set_event(stub, EVENT_TOKENS_TRANSFERRED, event)
+	if err != nil { log_failure(stub, "Failed to set event", err); return nil, internalError("Failed to set event", err) }
+
+	return json.Marshal(ledger.balance(from))
+}
+
+//=================================================================================================================================
+//	 redeem_tokens - Called by the kiosk of the activity or an admin. args: amount, nonce, then the create_activity
+//					 arguments with activityType "redeem". Records the redemption activity at the kiosk and debits the
+//					 account of its actor.
+//=================================================================================================================================
+func (t *SimpleChaincode) redeem_tokens(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) < 17 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting amount, nonce and the create_activity arguments")
+	}
+
+	if !actsForKiosk(caller, caller_affiliation, args[7]) {
+		log_warning(stub, "Permission Denied", "kioskId", args[7]); return nil, permissionDenied("Permission Denied")
+	}
+
+	if args[6] != REDEEM_ACTIVITY_TYPE {
+		return nil, invalidArgument(6, "activityType", "Invalid activity type " + args[6] + ". Expecting " + REDEEM_ACTIVITY_TYPE)
+	}
+
+	amount, nonce, err := parseDebit(args, 0)
+	if err != nil { log_failure(stub, "Invalid amount or nonce", err); return nil, err }
+
+	activityAsBytes, err := t.create_activity(stub, caller, caller_affiliation, args[2:])
+	if err != nil { return nil, err }
+
+	var activity Activity
+	err = json.Unmarshal(activityAsBytes, &activity)
+	if err != nil { log_failure(stub, "Failed to read the new activity", err); return nil, internalError("Failed to read the new activity", err) }
+
+	ledger, err := get_token_ledger(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token ledger", err); return nil, internalError("Failed to retrieve token ledger", err) }
+
+	activityId := activity.ActivityId
+	err = ledger.debit(tokenAccountId(activity.Actor), amount, nonce, TokenEntry{Kind: ENTRY_REDEEM, ActivityId: &activityId,
+		Counterparty: activity.Kiosk.KioskId, Caller: caller, TxId: stub.GetTxID(), Timestamp: activity.Timestamp})
+	if err != nil { log_failure(stub, "Debit rejected", err); return nil, err }
+
+	err = put_token_ledger(stub, ledger)
+	if err != nil { log_failure(stub, "Failed to save token ledger", err); return nil, internalError("Failed to save token ledger", err) }
+
+	return activityAsBytes, nil
+}
+
+//=================================================================================================================================
+//	 token_balance - args[0] is the accountId.
+//=================================================================================================================================
+func (t *SimpleChaincode) token_balance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. accountId")
+	}
+
+	ledger, err := get_token_ledger(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token ledger", err); return nil, internalError("Failed to retrieve token ledger", err) }
+
+	return json.Marshal(ledger.balance(args[0]))
+}
+
+//=================================================================================================================================
+//	 token_statement - args[0] is the accountId. Returns the account with every entry, oldest first. The other account of
+//					   a transfer is blanked, its id is the other actor's telephone or email.
+//=================================================================================================================================
+func (t *SimpleChaincode) token_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgument(-1, "", "Incorrect number of arguments. Expecting 1. accountId")
+	}
+
+	ledger, err := get_token_ledger(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token ledger", err); return nil, internalError("Failed to retrieve token ledger", err) }
+
+	account := ledger.account(args[0])
+
+	entries := make([]TokenEntry, len(account.Entries))
+	for i, entry := range account.Entries {
+		if entry.Kind == ENTRY_TRANSFER_IN || entry.Kind == ENTRY_TRANSFER_OUT {
+			entry.Counterparty = ""
+		}
+		entries[i] = entry
+	}
+	account.Entries = entries
+
+	return json.Marshal(account)
+}
+
+//=================================================================================================================================
+//	 view_token_awards - Returns the configured awards per activity type.
+//=================================================================================================================================
+func (t *SimpleChaincode) view_token_awards(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	config, err := get_token_config(stub)
+	if err != nil { log_failure(stub, "Failed to retrieve token config", err); return nil, internalError("Failed to retrieve token config", err) }
+
+	return json.Marshal(config)
+}
+
+//=================================================================================================================================
+//	 award_tokens - Credits the award configured for the activity type to the actor of a new activity.
+//=================================================================================================================================
+func award_tokens(stub shim.ChaincodeStubInterface, caller string, activity Activity) error {
+	config, err := get_token_config(stub)
+	if err != nil { return err }
+
+	amount := config.Awards[activity.ActivityType]
+	if amount <= 0 {
+		return nil
+	}
+
+	ledger, err := get_token_ledger(stub)
+	if err != nil { return err }
+
+	activityId := activity.ActivityId
+	ledger.credit(tokenAccountId(activity.Actor), amount, TokenEntry{Kind: ENTRY_AWARD, ActivityId: &activityId,
+		Counterparty: activity.Kiosk.KioskId, Caller: caller, TxId: stub.GetTxID(), Timestamp: activity.Timestamp})
+
+	return put_token_ledger(stub, ledger)
+}
+
+func (ledger *TokenLedger) credit(accountId string, amount int64, entry TokenEntry) {
+	account := ledger.account(accountId)
+	account.Balance += amount
+
+	entry.Amount = amount
+	entry.Balance = account.Balance
+	account.Entries = append(account.Entries, entry)
+	ledger.Accounts[accountId] = account
+}
+
+// debit fails rather than let the balance go negative or accept a nonce other than the next one.
+func (ledger *TokenLedger) debit(accountId string, amount int64, nonce int64, entry TokenEntry) error {
+	account := ledger.account(accountId)
+	if nonce != account.Nonce+1 {
+		return conflict("Invalid nonce " + strconv.FormatInt(nonce, 10) + ". Expecting " + strconv.FormatInt(account.Nonce+1, 10))
+	}
+	if amount > account.Balance {
+		return conflict("Insufficient balance " + strconv.FormatInt(account.Balance, 10) + " in account " + accountId)
+	}
+
+	account.Balance -= amount
+	account.Nonce = nonce
+
+	entry.Amount = -amount
+	entry.Balance = account.Balance
+	account.Entries = append(account.Entries, entry)
+	ledger.Accounts[accountId] = account
+	return nil
+}
+
+// mayDebit tells whether the caller may transfer out of the account: an admin, the holder, or a kiosk the account
+// earned or redeemed tokens at.
+func (ledger *TokenLedger) mayDebit(accountId string, caller string, caller_affiliation string) bool {
+	if caller_affiliation == ADMIN || (caller != "" && caller == accountId) {
+		return true
+	}
+
+	for _, entry := range ledger.account(accountId).Entries {
+		if (entry.Kind == ENTRY_AWARD || entry.Kind == ENTRY_REDEEM) && actsForKiosk(caller, caller_affiliation, entry.Counterparty) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ledger *TokenLedger) account(accountId string) TokenAccount {
+	account, ok := ledger.Accounts[accountId]
+	if !ok {
+		account = TokenAccount{AccountId: accountId, Entries: []TokenEntry{}}
+	}
+	return account
+}
+
+func (ledger *TokenLedger) balance(accountId string) TokenBalance {
+	account := ledger.account(accountId)
+	return TokenBalance{AccountId: accountId, Balance: account.Balance, Nonce: account.Nonce}
+}
+
+// tokenAccountId identifies the token account of an actor: the actor type and the telephone, email or name, whichever
+// is set first.
+func tokenAccountId(actor Actor) string {
+	for _, id := range []string{actor.Telephone, actor.Email, actor.Name} {
+		if id != "" {
+			return actor.ActorType + ":" + id
+		}
+	}
+	return actor.ActorType + ":"
+}
+
+// accountDigest is the hex SHA-256 of the account id, naming an account without its contact data.
+func accountDigest(accountId string) string {
+	sum := sha256.Sum256([]byte(accountId))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseDebit reads the amount from args[first] and the nonce from args[first+1].
+func parseDebit(args []string, first int) (int64, int64, error) {
+	amount, err := strconv.ParseInt(args[first], 10, 64)
+	if err != nil || amount <= 0 { return 0, 0, invalidArgument(first, "amount", "Invalid amount. Expecting a positive integer") }
+
+	nonce, err := strconv.ParseInt(args[first+1], 10, 64)
+	if err != nil { return 0, 0, invalidArgument(first+1, "nonce", "Invalid nonce") }
+
+	return amount, nonce, nil
+}
+
+func get_token_config(stub shim.ChaincodeStubInterface) (TokenConfig, error) {
+	var config TokenConfig
+
+	configAsBytes, err := stub.GetState(tokenConfigStr)
+	if err != nil { return config, err }
+
+	if len(configAsBytes) > 0 {
+		err = json.Unmarshal(configAsBytes, &config)
+		if err != nil { return config, corruptState(tokenConfigStr, "Corrupt token config record") }
+	}
+
+	if config.Awards == nil {
+		config.Awards = make(map[string]int64)
+	}
+
+	return config, nil
+}
+
+func get_token_ledger(stub shim.ChaincodeStubInterface) (TokenLedger, error) {
+	var ledger TokenLedger
+
+	ledgerAsBytes, err := stub.GetState(tokenLedgerStr)
+	if err != nil { return ledger, err }
+
+	if len(ledgerAsBytes) > 0 {
+		err = json.Unmarshal(ledgerAsBytes, &ledger)
+		if err != nil { return ledger, corruptState(tokenLedgerStr, "Corrupt token ledger record") }
+	}
+
+	if ledger.Accounts == nil {
+		ledger.Accounts = make(map[string]TokenAccount)
+	}
+
+	return ledger, nil
+}
+
+func put_token_ledger(stub shim.ChaincodeStubInterface, ledger TokenLedger) error {
+	ledgerAsBytes, err := json.Marshal(ledger)
+	if err != nil { return err }
+
+	return stub.PutState(tokenLedgerStr, ledgerAsBytes)
+}